@@ -0,0 +1,61 @@
+// molecule-conformance is a testee for the conformance_test_runner of the protobuf
+// project, which decodes and encodes the binary payloads of the conformance tests with
+// molecule.
+//
+// Install it with:
+//
+//	go install github.com/richardartoul/molecule/cmd/molecule-conformance@latest
+//
+// The test messages are not linked into the binary, so it needs a descriptor set of
+// them, which protoc can generate from the protobuf source tree:
+//
+//	protoc --include_imports --descriptor_set_out=test_messages.pb \
+//		-I src \
+//		src/google/protobuf/test_messages_proto2.proto \
+//		src/google/protobuf/test_messages_proto3.proto
+//
+// Then run the runner with a wrapper script, since it does not pass arguments to the
+// testee:
+//
+//	echo 'exec molecule-conformance -descriptor_set test_messages.pb' > testee.sh
+//	chmod +x testee.sh
+//	conformance_test_runner --enforce_recommended ./testee.sh
+//
+// Only binary input is supported, so every test with JSON or text format input is
+// skipped. Groups are not supported by molecule, so the tests that use them fail with
+// parse errors. The conformance package describes the protocol.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/richardartoul/molecule/src/conformance"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func main() {
+	descriptorSet := flag.String("descriptor_set", "", "serialized FileDescriptorSet of the test messages")
+	flag.Parse()
+
+	if err := run(*descriptorSet); err != nil {
+		fmt.Fprintf(os.Stderr, "molecule-conformance: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(descriptorSet string) error {
+	var files *protoregistry.Files
+	if descriptorSet != "" {
+		b, err := os.ReadFile(descriptorSet)
+		if err != nil {
+			return err
+		}
+		if files, err = conformance.LoadDescriptorSet(b); err != nil {
+			return err
+		}
+	}
+	return conformance.NewTestee(files).Serve(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,85 @@
+// molecule-infer guesses the schema of protobuf messages whose .proto files are not
+// available, from sample messages of the same type.
+//
+// Install it with:
+//
+//	go install github.com/richardartoul/molecule/cmd/molecule-infer@latest
+//
+// and pass it files that each hold a single encoded message:
+//
+//	molecule-infer -name Event -package events sample1.bin sample2.bin > event.proto
+//
+// The more samples it is given, the better the guesses. The -descriptor flag also writes
+// a serialized google.protobuf.FileDescriptorSet. See the infer package for how the
+// types are inferred.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/richardartoul/molecule/src/infer"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func main() {
+	var (
+		name       = flag.String("name", "Message", "name of the inferred message")
+		pkg        = flag.String("package", "inferred", "package of the inferred message")
+		output     = flag.String("o", "", "file to write the .proto file to, instead of stdout")
+		descriptor = flag.String("descriptor", "", "file to write a serialized FileDescriptorSet to")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] sample...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*name, *pkg, *output, *descriptor, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "molecule-infer: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(name, pkg, output, descriptor string, samples []string) error {
+	inf := infer.NewInferrer()
+	for _, path := range samples {
+		sample, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := inf.Add(sample); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	m := inf.Infer(name)
+	if descriptor != "" {
+		fd := m.FileDescriptor(name+".proto", pkg)
+		// Validate the descriptor before writing it.
+		if _, err := protodesc.NewFile(fd, nil); err != nil {
+			return fmt.Errorf("invalid descriptor: %v", err)
+		}
+		b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fd}})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(descriptor, b, 0o644); err != nil {
+			return err
+		}
+	}
+
+	if output == "" {
+		_, err := os.Stdout.WriteString(m.Proto(pkg))
+		return err
+	}
+	return os.WriteFile(output, []byte(m.Proto(pkg)), 0o644)
+}
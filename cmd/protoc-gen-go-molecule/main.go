@@ -0,0 +1,30 @@
+// protoc-gen-go-molecule is a protoc plugin that generates zero-allocation, typed
+// decoders and encoders built on top of molecule for the messages in .proto files.
+//
+// Install it with:
+//
+//	go install github.com/richardartoul/molecule/cmd/protoc-gen-go-molecule@latest
+//
+// and run it alongside protoc-gen-go:
+//
+//	protoc --go_out=. --go-molecule_out=. foo.proto
+//
+// See the codegen package for a description of the generated code.
+package main
+
+import (
+	"github.com/richardartoul/molecule/src/codegen"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+func main() {
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		for _, f := range gen.Files {
+			if f.Generate {
+				codegen.GenerateFile(gen, f)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,116 @@
+package molecule
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+const (
+	// The contextCheckFields is the number of fields or values between checks of
+	// whether the context passed to a *Context function is done.
+	contextCheckFields = 256
+	// The contextCheckBytes is the number of bytes after which the context is
+	// checked regardless of the number of fields, so that a few large fields do
+	// not delay the check.
+	contextCheckBytes = 64 * 1024
+)
+
+// A DecodeError is returned by MessageEachContext and PackedRepeatedEachContext when the
+// input can't be decoded, or when their context is done.  Errors returned by the
+// callback are returned unchanged.
+type DecodeError struct {
+	// The Offset is the position in the buffer, relative to where iteration started, at
+	// which decoding stopped.
+	Offset int
+	// The Err is the underlying error, such as context.Canceled.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding at offset %d: %v", e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error, so that errors.Is(err, context.Canceled) reports
+// whether iteration was cancelled.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// A contextChecker decides when to check whether a context is done.
+type contextChecker struct {
+	ctx    context.Context
+	fields int
+	// The nextCheck is the value of buffer.Len() at or below which the
+	// context is checked next.
+	nextCheck int
+}
+
+// check returns the error of ctx if it is done, checking only every contextCheckFields
+// fields or contextCheckBytes bytes.
+func (c *contextChecker) check(buffer *codec.Buffer) error {
+	c.fields++
+	if c.fields < contextCheckFields && buffer.Len() > c.nextCheck {
+		return nil
+	}
+	c.fields = 0
+	c.nextCheck = buffer.Len() - contextCheckBytes
+	return c.ctx.Err()
+}
+
+// MessageEachContext is like MessageEach, but it stops and returns a *DecodeError wrapping
+// ctx.Err() if ctx is done. The context is checked before the first field and then
+// periodically, every few hundred fields or tens of kilobytes.
+func MessageEachContext(ctx context.Context, buffer *codec.Buffer, fn MessageEachFn) error {
+	var (
+		start   = buffer.Len()
+		checker = contextChecker{ctx: ctx, fields: contextCheckFields}
+	)
+	for !buffer.EOF() {
+		if err := checker.check(buffer); err != nil {
+			return &DecodeError{Offset: start - buffer.Len(), Err: err}
+		}
+
+		var value Value
+		fieldNum, err := Next(buffer, &value)
+		if err != nil {
+			return &DecodeError{Offset: start - buffer.Len(), Err: err}
+		}
+		shouldContinue, err := fn(fieldNum, value)
+		if err != nil || !shouldContinue {
+			return err
+		}
+	}
+	return nil
+}
+
+// PackedRepeatedEachContext is like PackedRepeatedEach, but it stops and returns a
+// *DecodeError wrapping ctx.Err() if ctx is done. The context is checked before the first
+// value and then periodically, every few hundred values or tens of kilobytes.
+func PackedRepeatedEachContext(ctx context.Context, buffer *codec.Buffer, fieldType codec.FieldType, fn PackedRepeatedEachFn) error {
+	var (
+		start   = buffer.Len()
+		checker = contextChecker{ctx: ctx, fields: contextCheckFields}
+		fnErr   bool
+	)
+	if err := checker.check(buffer); err != nil {
+		return &DecodeError{Offset: 0, Err: err}
+	}
+	err := PackedRepeatedEach(buffer, fieldType, func(value Value) (bool, error) {
+		shouldContinue, err := fn(value)
+		if err != nil || !shouldContinue {
+			fnErr = err != nil
+			return false, err
+		}
+		if err := checker.check(buffer); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil && !fnErr {
+		return &DecodeError{Offset: start - buffer.Len(), Err: err}
+	}
+	return err
+}
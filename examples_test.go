@@ -1,6 +1,7 @@
 package molecule
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/richardartoul/molecule/src/codec"
@@ -190,6 +191,59 @@ func Example_repeated() {
 	// Int64s: [1 2 3 4 5 6 7]
 }
 
+// ExampleNextField demonstrates how NextField and ProtoStream.Write can be used to
+// rewrite a message, copying the fields that are not changed verbatim.
+func ExampleNextField() {
+	// Proto definitions:
+	//
+	//   message Test {
+	//     string string_field = 1;
+	//     int64 int64_field = 2;
+	//     repeated int64 repeated_int64_field = 3;
+	//   }
+
+	m := &simple.Test{
+		StringField:        "hello world!",
+		Int64Field:         10,
+		RepeatedInt64Field: []int64{1, 2, 3},
+	}
+	marshaled, err := proto.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		buffer = codec.NewBuffer(marshaled)
+		output = bytes.NewBuffer(nil)
+		ps     = NewProtoStream(output)
+		value  Value
+	)
+	for !buffer.EOF() {
+		fieldNum, field, err := NextField(buffer, &value)
+		if err != nil {
+			panic(err)
+		}
+		if fieldNum == 2 {
+			// Replace the int64 field.
+			err = ps.Int64(2, 42)
+		} else {
+			_, err = ps.Write(field)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var rewritten simple.Test
+	if err := proto.Unmarshal(output.Bytes(), &rewritten); err != nil {
+		panic(err)
+	}
+	fmt.Println(rewritten.StringField, rewritten.Int64Field, rewritten.RepeatedInt64Field)
+
+	// Output:
+	// hello world! 42 [1 2 3]
+}
+
 // ExampleMessageEach_SelectAField desmonates how the MessageEach function can
 // be used to select an individual field.
 func ExampleMessageEach_selectAField() {
@@ -1,11 +1,18 @@
 module github.com/richardartoul/molecule
 
-go 1.13
+go 1.20
 
 require (
 	github.com/google/gofuzz v1.1.0
-	github.com/pkg/errors v0.9.1 // indirect
 	github.com/stretchr/testify v1.5.1
-	google.golang.org/protobuf v1.33.0
+	google.golang.org/protobuf v1.34.2
 	gotest.tools v2.2.0+incompatible
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/google/go-cmp v0.5.5 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
@@ -0,0 +1,200 @@
+package molecule
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+const (
+	// The maxDenseFieldNumber is the largest field number for which an Index uses
+	// a table with an entry per field number.  Messages with larger field numbers
+	// are looked up with a binary search instead, so that a single large field
+	// number does not blow up the size of the table.
+	maxDenseFieldNumber = 4096
+)
+
+// An Index provides random access to the top-level fields of a message. It scans the
+// message once, after which the values of any field can be looked up without scanning
+// the message again, in constant time for messages whose field numbers are all at most
+// 4096 and logarithmic time otherwise.
+//
+// The values returned by an Index contain unsafe views over the bytes of the message,
+// just like MessageEach, and are only valid until the Index is Reset.
+//
+// The zero value is an empty Index, and an Index can be reused for many messages by
+// calling Reset, which retains the memory it uses.
+type Index struct {
+	// The fieldNums and values are the fields of the message, sorted by field
+	// number.  Fields with the same number are kept in the order in which they
+	// appear in the message.
+	fieldNums []int32
+	values    []Value
+
+	// The offsets is the dense table, if the message has no field numbers
+	// larger than maxDenseFieldNumber.  The fields with number f are
+	// values[offsets[f]:offsets[f+1]].
+	offsets []int32
+	dense   bool
+
+	// The scanned holds the fields in the order in which they were scanned,
+	// before they are sorted.
+	scanned []indexEntry
+}
+
+// An indexEntry is a field scanned by an Index.
+type indexEntry struct {
+	fieldNum int32
+	value    Value
+}
+
+// NewIndex creates a new Index over the message stored in buffer.
+func NewIndex(buffer *codec.Buffer) (*Index, error) {
+	ix := &Index{}
+	if err := ix.Reset(buffer); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// Reset replaces the contents of the Index with the fields of the message stored in
+// buffer. If an error is returned, the Index is left empty.
+func (ix *Index) Reset(buffer *codec.Buffer) error {
+	ix.clear()
+
+	var maxFieldNumber int32
+	for !buffer.EOF() {
+		// Next does not clear the fields of value that it does not set, so a new
+		// value is used for each field.
+		var value Value
+		fieldNum, err := Next(buffer, &value)
+		if err != nil {
+			ix.clear()
+			return fmt.Errorf("Reset: %v", err)
+		}
+		ix.scanned = append(ix.scanned, indexEntry{fieldNum: fieldNum, value: value})
+		if fieldNum > maxFieldNumber {
+			maxFieldNumber = fieldNum
+		}
+	}
+
+	ix.grow(len(ix.scanned))
+	if maxFieldNumber <= maxDenseFieldNumber {
+		ix.sortDense(maxFieldNumber)
+	} else {
+		ix.sortSparse()
+	}
+	return nil
+}
+
+// sortDense sorts the scanned fields with a counting sort, which also builds the dense
+// table.
+func (ix *Index) sortDense(maxFieldNumber int32) {
+	ix.dense = true
+	if n := int(maxFieldNumber) + 2; cap(ix.offsets) < n {
+		ix.offsets = make([]int32, n)
+	} else {
+		ix.offsets = ix.offsets[:n]
+		for i := range ix.offsets {
+			ix.offsets[i] = 0
+		}
+	}
+
+	// Count the fields with each number, shifted by one so that the prefix sums
+	// are the start of each field number.
+	for _, e := range ix.scanned {
+		ix.offsets[e.fieldNum+1]++
+	}
+	for f := 1; f < len(ix.offsets); f++ {
+		ix.offsets[f] += ix.offsets[f-1]
+	}
+	// Place each field, using offsets[f] as the next position for number f, which
+	// leaves offsets[f] at the end of number f.
+	for _, e := range ix.scanned {
+		i := ix.offsets[e.fieldNum]
+		ix.fieldNums[i] = e.fieldNum
+		ix.values[i] = e.value
+		ix.offsets[e.fieldNum]++
+	}
+	// Shift the ends back to the starts.
+	copy(ix.offsets[1:], ix.offsets[:len(ix.offsets)-1])
+	ix.offsets[0] = 0
+}
+
+// sortSparse sorts the scanned fields for lookups with a binary search.
+func (ix *Index) sortSparse() {
+	ix.dense = false
+	sort.Stable(byFieldNumber(ix.scanned))
+	for i, e := range ix.scanned {
+		ix.fieldNums[i] = e.fieldNum
+		ix.values[i] = e.value
+	}
+}
+
+// Lookup returns the value of the last occurrence of the field with number fieldNum,
+// which is the value that takes precedence for fields that are not repeated, and whether
+// the field is present.
+func (ix *Index) Lookup(fieldNum int32) (Value, bool) {
+	values := ix.LookupAll(fieldNum)
+	if len(values) == 0 {
+		return Value{}, false
+	}
+	return values[len(values)-1], true
+}
+
+// LookupAll returns the values of all occurrences of the field with number fieldNum, in
+// the order in which they appear in the message. The returned slice must not be modified.
+func (ix *Index) LookupAll(fieldNum int32) []Value {
+	if ix.dense {
+		if fieldNum < 0 || int(fieldNum) >= len(ix.offsets)-1 {
+			return nil
+		}
+		return ix.values[ix.offsets[fieldNum]:ix.offsets[fieldNum+1]:ix.offsets[fieldNum+1]]
+	}
+
+	start := sort.Search(len(ix.fieldNums), func(i int) bool { return ix.fieldNums[i] >= fieldNum })
+	end := start
+	for end < len(ix.fieldNums) && ix.fieldNums[end] == fieldNum {
+		end++
+	}
+	return ix.values[start:end:end]
+}
+
+// Len returns the number of fields in the message, counting each occurrence separately.
+func (ix *Index) Len() int {
+	return len(ix.values)
+}
+
+// grow sizes fieldNums and values to hold n fields.
+func (ix *Index) grow(n int) {
+	if cap(ix.values) < n {
+		ix.fieldNums = make([]int32, n)
+		ix.values = make([]Value, n)
+		return
+	}
+	ix.fieldNums = ix.fieldNums[:n]
+	ix.values = ix.values[:n]
+}
+
+// clear empties the Index, dropping its references to the bytes of the previous message.
+func (ix *Index) clear() {
+	for i := range ix.values {
+		ix.values[i] = Value{}
+	}
+	for i := range ix.scanned {
+		ix.scanned[i] = indexEntry{}
+	}
+	ix.fieldNums = ix.fieldNums[:0]
+	ix.values = ix.values[:0]
+	ix.scanned = ix.scanned[:0]
+	ix.offsets = ix.offsets[:0]
+	ix.dense = false
+}
+
+// byFieldNumber implements sort.Interface to sort fields by number.
+type byFieldNumber []indexEntry
+
+func (s byFieldNumber) Len() int           { return len(s) }
+func (s byFieldNumber) Less(i, j int) bool { return s[i].fieldNum < s[j].fieldNum }
+func (s byFieldNumber) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -94,6 +94,18 @@ func Next(buffer *codec.Buffer, value *Value) (fieldNum int32, err error) {
 	return
 }
 
+// NextField is like Next, but it also returns the original encoding of the entire field,
+// including its key, so that it can be copied verbatim with ProtoStream.Write. The
+// returned slice is an unsafe view over the bytes in the buffer, just like Value.Bytes.
+func NextField(buffer *codec.Buffer, value *Value) (fieldNum int32, field []byte, err error) {
+	start := buffer.Bytes()
+	fieldNum, err = Next(buffer, value)
+	if err != nil {
+		return 0, nil, err
+	}
+	return fieldNum, start[:len(start)-buffer.Len()], nil
+}
+
 // PackedRepeatedEachFn is a function that is called for each value in a repeated field.
 type PackedRepeatedEachFn func(value Value) (bool, error)
 
@@ -164,3 +176,20 @@ func PackedRepeatedEach(buffer *codec.Buffer, fieldType codec.FieldType, fn Pack
 
 	return nil
 }
+
+// PackedRepeatedStringEachFn is a function that is called for each string in a repeated field.
+type PackedRepeatedStringEachFn func(value string) (bool, error)
+
+// PackedRepeatedStringEach iterates over each length-delimited string stored back to back in
+// buffer and calls fn on each one. It is the string equivalent of calling PackedRepeatedEach
+// with codec.FieldType_STRING followed by AsStringUnsafe on each value: the strings passed to
+// fn are unsafe views over the underlying bytes and iteration does not allocate.
+func PackedRepeatedStringEach(buffer *codec.Buffer, fn PackedRepeatedStringEachFn) error {
+	return PackedRepeatedEach(buffer, codec.FieldType_STRING, func(value Value) (bool, error) {
+		s, err := value.AsStringUnsafe()
+		if err != nil {
+			return false, err
+		}
+		return fn(s)
+	})
+}
@@ -0,0 +1,156 @@
+package molecule
+
+import (
+	"sort"
+
+	"github.com/richardartoul/molecule/src/protowire"
+)
+
+const (
+	// The maxEmbeddedLengthSize is the number of bytes reserved for the length
+	// prefix of an embedded message written to an OutputBuffer.  Five bytes is
+	// enough for any message smaller than 32GiB, and protobuf messages are limited
+	// to 2GiB.
+	maxEmbeddedLengthSize = 5
+)
+
+// An OutputBuffer is a contiguous, growable buffer that a ProtoStream can write to.
+//
+// When a ProtoStream writes to an OutputBuffer, `Embedded` encodes nested messages
+// directly into the buffer instead of encoding them into a separate child buffer
+// and copying them.  A fixed size slot is reserved for the length prefix of every
+// embedded message, and once the outermost embedded message is complete the real
+// lengths are filled in and the buffer is compacted in a single pass.  Deeply nested
+// messages are therefore encoded with a single ProtoStream, and each byte is moved
+// at most once regardless of the nesting depth.
+//
+// The contents of the buffer are only valid once the outermost call to `Embedded`
+// has returned.
+type OutputBuffer struct {
+	buf []byte
+
+	// The lengths are the length prefixes reserved by embedded messages that have
+	// not been compacted yet, in the order that the messages were started.
+	lengths []pendingLength
+
+	// The depth is the number of calls to `Embedded` that are in progress.
+	depth int
+}
+
+// A pendingLength is the reserved length prefix of an embedded message.
+type pendingLength struct {
+	// The offset is the position of the reserved length prefix in the buffer.
+	offset int
+	// The end is the position in the buffer just after the embedded message.
+	end int
+	// The size is the final size of the embedded message, once compacted.
+	size int
+	// The savings is the total number of reserved bytes that are released by
+	// compacting the length prefixes of this message and all those after it.
+	savings int
+}
+
+// NewOutputBuffer creates a new OutputBuffer that appends to buf, which may be nil.
+func NewOutputBuffer(buf []byte) *OutputBuffer {
+	return &OutputBuffer{buf: buf}
+}
+
+// Write appends p to the buffer.  It never returns an error.
+func (b *OutputBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// WriteString appends s to the buffer.  It never returns an error.
+func (b *OutputBuffer) WriteString(s string) (int, error) {
+	b.buf = append(b.buf, s...)
+	return len(s), nil
+}
+
+// Bytes returns the contents of the buffer.  The returned slice is only valid until
+// the next write to, or Reset of, the buffer.
+func (b *OutputBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// Len returns the number of bytes in the buffer.
+func (b *OutputBuffer) Len() int {
+	return len(b.buf)
+}
+
+// Reset empties the buffer, retaining its capacity for reuse.
+func (b *OutputBuffer) Reset() {
+	b.buf = b.buf[:0]
+	b.lengths = b.lengths[:0]
+	b.depth = 0
+}
+
+// embedded implements `ProtoStream.Embedded` for streams that write to an
+// OutputBuffer.  It writes the key, reserves a slot for the length prefix, and
+// encodes the nested message with ps itself.
+func (b *OutputBuffer) embedded(ps *ProtoStream, fieldNumber int, inner func(*ProtoStream) error) error {
+	start := len(b.buf)
+	b.buf = protowire.AppendVarint(b.buf, uint64(fieldNumber)<<3+uint64(protowire.BytesType))
+	i := len(b.lengths)
+	b.lengths = append(b.lengths, pendingLength{offset: len(b.buf)})
+	b.buf = append(b.buf, make([]byte, maxEmbeddedLengthSize)...)
+
+	b.depth++
+	err := inner(ps)
+	b.depth--
+	if err != nil {
+		// Discard the partially encoded message so that, like with other writers,
+		// nothing is written if inner fails.
+		b.buf = b.buf[:start]
+		b.lengths = b.lengths[:i]
+		return err
+	}
+
+	b.lengths[i].end = len(b.buf)
+	if b.depth == 0 {
+		b.compact()
+	}
+	return nil
+}
+
+// compact fills in the reserved length prefixes and removes the unused reserved
+// bytes from the buffer.
+func (b *OutputBuffer) compact() {
+	// Messages are started in order, so the messages nested in lengths[i] are the
+	// ones immediately after it that start before it ends.  Walking backwards
+	// means that the final sizes of all of them are known by the time lengths[i]
+	// is reached.
+	for i := len(b.lengths) - 1; i >= 0; i-- {
+		l := &b.lengths[i]
+		nested := b.lengths[i+1:]
+		numNested := sort.Search(len(nested), func(j int) bool { return nested[j].offset >= l.end })
+
+		var nestedSavings int
+		if numNested > 0 {
+			nestedSavings = nested[0].savings
+			if numNested < len(nested) {
+				nestedSavings -= nested[numNested].savings
+			}
+		}
+		l.size = l.end - l.offset - maxEmbeddedLengthSize - nestedSavings
+		l.savings = maxEmbeddedLengthSize - protowire.SizeVarint(uint64(l.size))
+		if len(nested) > 0 {
+			l.savings += nested[0].savings
+		}
+	}
+
+	// Move everything after the first length prefix into place, one segment at a
+	// time.  The write position never passes the read position.
+	var (
+		w = b.lengths[0].offset
+		r = w
+	)
+	for _, l := range b.lengths {
+		w += copy(b.buf[w:], b.buf[r:l.offset])
+		w += len(protowire.AppendVarint(b.buf[w:w], uint64(l.size)))
+		r = l.offset + maxEmbeddedLengthSize
+	}
+	w += copy(b.buf[w:], b.buf[r:])
+	b.buf = b.buf[:w]
+	b.lengths = b.lengths[:0]
+}
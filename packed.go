@@ -0,0 +1,387 @@
+package molecule
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+// msbs has the most significant bit of every byte in a word set.
+const msbs = 0x8080808080808080
+
+// DecodePackedInt32 decodes all of the values in the packed repeated int32 field stored
+// in buffer and appends them to dst, which is returned. It is equivalent to, but much faster
+// than, calling PackedRepeatedEach with codec.FieldType_INT32 and appending the result of
+// AsInt32 for each value.
+//
+// The DecodePacked* functions consume the whole buffer. If an error is returned, dst
+// contains the values that were decoded before the error and the buffer is left unchanged.
+func DecodePackedInt32(buffer *codec.Buffer, dst []int32) ([]int32, error) {
+	b := buffer.Bytes()
+	dst = grow(dst, countVarints(b))
+	for len(b) > 0 {
+		if w, ok := smallVarints(b); ok {
+			dst = append(dst,
+				int32(w&0xff), int32(w>>8&0xff), int32(w>>16&0xff), int32(w>>24&0xff),
+				int32(w>>32&0xff), int32(w>>40&0xff), int32(w>>48&0xff), int32(w>>56))
+			b = b[8:]
+			continue
+		}
+
+		if c := b[0]; c < 0x80 {
+			dst = append(dst, int32(c))
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, fmt.Errorf("DecodePackedInt32: error reading value from buffer: %v", err)
+		}
+		if s := int64(v); s > math.MaxInt32 || s < math.MinInt32 {
+			return dst, fmt.Errorf("DecodePackedInt32: %d overflows int32", s)
+		}
+		dst = append(dst, int32(v))
+		b = b[n:]
+	}
+	return dst, buffer.Skip(buffer.Len())
+}
+
+// DecodePackedInt64 decodes all of the values in the packed repeated int64 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedInt64(buffer *codec.Buffer, dst []int64) ([]int64, error) {
+	b := buffer.Bytes()
+	dst = grow(dst, countVarints(b))
+	for len(b) > 0 {
+		if w, ok := smallVarints(b); ok {
+			dst = append(dst,
+				int64(w&0xff), int64(w>>8&0xff), int64(w>>16&0xff), int64(w>>24&0xff),
+				int64(w>>32&0xff), int64(w>>40&0xff), int64(w>>48&0xff), int64(w>>56))
+			b = b[8:]
+			continue
+		}
+
+		if c := b[0]; c < 0x80 {
+			dst = append(dst, int64(c))
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, fmt.Errorf("DecodePackedInt64: error reading value from buffer: %v", err)
+		}
+		dst = append(dst, int64(v))
+		b = b[n:]
+	}
+	return dst, buffer.Skip(buffer.Len())
+}
+
+// DecodePackedUint32 decodes all of the values in the packed repeated uint32 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedUint32(buffer *codec.Buffer, dst []uint32) ([]uint32, error) {
+	b := buffer.Bytes()
+	dst = grow(dst, countVarints(b))
+	for len(b) > 0 {
+		if w, ok := smallVarints(b); ok {
+			dst = append(dst,
+				uint32(w&0xff), uint32(w>>8&0xff), uint32(w>>16&0xff), uint32(w>>24&0xff),
+				uint32(w>>32&0xff), uint32(w>>40&0xff), uint32(w>>48&0xff), uint32(w>>56))
+			b = b[8:]
+			continue
+		}
+
+		if c := b[0]; c < 0x80 {
+			dst = append(dst, uint32(c))
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, fmt.Errorf("DecodePackedUint32: error reading value from buffer: %v", err)
+		}
+		if v > math.MaxUint32 {
+			return dst, fmt.Errorf("DecodePackedUint32: %d overflows uint32", v)
+		}
+		dst = append(dst, uint32(v))
+		b = b[n:]
+	}
+	return dst, buffer.Skip(buffer.Len())
+}
+
+// DecodePackedUint64 decodes all of the values in the packed repeated uint64 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedUint64(buffer *codec.Buffer, dst []uint64) ([]uint64, error) {
+	b := buffer.Bytes()
+	dst = grow(dst, countVarints(b))
+	for len(b) > 0 {
+		if w, ok := smallVarints(b); ok {
+			dst = append(dst,
+				w&0xff, w>>8&0xff, w>>16&0xff, w>>24&0xff,
+				w>>32&0xff, w>>40&0xff, w>>48&0xff, w>>56)
+			b = b[8:]
+			continue
+		}
+
+		if c := b[0]; c < 0x80 {
+			dst = append(dst, uint64(c))
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, fmt.Errorf("DecodePackedUint64: error reading value from buffer: %v", err)
+		}
+		dst = append(dst, v)
+		b = b[n:]
+	}
+	return dst, buffer.Skip(buffer.Len())
+}
+
+// DecodePackedSint32 decodes all of the values in the packed repeated sint32 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedSint32(buffer *codec.Buffer, dst []int32) ([]int32, error) {
+	b := buffer.Bytes()
+	dst = grow(dst, countVarints(b))
+	for len(b) > 0 {
+		if w, ok := smallVarints(b); ok {
+			dst = append(dst,
+				codec.DecodeZigZag32(w&0xff), codec.DecodeZigZag32(w>>8&0xff), codec.DecodeZigZag32(w>>16&0xff), codec.DecodeZigZag32(w>>24&0xff),
+				codec.DecodeZigZag32(w>>32&0xff), codec.DecodeZigZag32(w>>40&0xff), codec.DecodeZigZag32(w>>48&0xff), codec.DecodeZigZag32(w>>56))
+			b = b[8:]
+			continue
+		}
+
+		if c := b[0]; c < 0x80 {
+			dst = append(dst, codec.DecodeZigZag32(uint64(c)))
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, fmt.Errorf("DecodePackedSint32: error reading value from buffer: %v", err)
+		}
+		if v > math.MaxUint32 {
+			return dst, fmt.Errorf("DecodePackedSint32: %d overflows int32", v)
+		}
+		dst = append(dst, codec.DecodeZigZag32(v))
+		b = b[n:]
+	}
+	return dst, buffer.Skip(buffer.Len())
+}
+
+// DecodePackedSint64 decodes all of the values in the packed repeated sint64 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedSint64(buffer *codec.Buffer, dst []int64) ([]int64, error) {
+	b := buffer.Bytes()
+	dst = grow(dst, countVarints(b))
+	for len(b) > 0 {
+		if w, ok := smallVarints(b); ok {
+			dst = append(dst,
+				codec.DecodeZigZag64(w&0xff), codec.DecodeZigZag64(w>>8&0xff), codec.DecodeZigZag64(w>>16&0xff), codec.DecodeZigZag64(w>>24&0xff),
+				codec.DecodeZigZag64(w>>32&0xff), codec.DecodeZigZag64(w>>40&0xff), codec.DecodeZigZag64(w>>48&0xff), codec.DecodeZigZag64(w>>56))
+			b = b[8:]
+			continue
+		}
+
+		if c := b[0]; c < 0x80 {
+			dst = append(dst, codec.DecodeZigZag64(uint64(c)))
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, fmt.Errorf("DecodePackedSint64: error reading value from buffer: %v", err)
+		}
+		dst = append(dst, codec.DecodeZigZag64(v))
+		b = b[n:]
+	}
+	return dst, buffer.Skip(buffer.Len())
+}
+
+// DecodePackedBool decodes all of the values in the packed repeated bool field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedBool(buffer *codec.Buffer, dst []bool) ([]bool, error) {
+	b := buffer.Bytes()
+	dst = grow(dst, countVarints(b))
+	for len(b) > 0 {
+		if w, ok := smallVarints(b); ok {
+			dst = append(dst,
+				w&0xff == 1, w>>8&0xff == 1, w>>16&0xff == 1, w>>24&0xff == 1,
+				w>>32&0xff == 1, w>>40&0xff == 1, w>>48&0xff == 1, w>>56 == 1)
+			b = b[8:]
+			continue
+		}
+
+		if c := b[0]; c < 0x80 {
+			dst = append(dst, c == 1)
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, fmt.Errorf("DecodePackedBool: error reading value from buffer: %v", err)
+		}
+		dst = append(dst, v == 1)
+		b = b[n:]
+	}
+	return dst, buffer.Skip(buffer.Len())
+}
+
+// DecodePackedFixed32 decodes all of the values in the packed repeated fixed32 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedFixed32(buffer *codec.Buffer, dst []uint32) ([]uint32, error) {
+	dst, err := decodePackedFixed32(buffer, dst)
+	if err != nil {
+		return dst, fmt.Errorf("DecodePackedFixed32: %v", err)
+	}
+	return dst, nil
+}
+
+// DecodePackedFixed64 decodes all of the values in the packed repeated fixed64 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedFixed64(buffer *codec.Buffer, dst []uint64) ([]uint64, error) {
+	dst, err := decodePackedFixed64(buffer, dst)
+	if err != nil {
+		return dst, fmt.Errorf("DecodePackedFixed64: %v", err)
+	}
+	return dst, nil
+}
+
+// DecodePackedSfixed32 decodes all of the values in the packed repeated sfixed32 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedSfixed32(buffer *codec.Buffer, dst []int32) ([]int32, error) {
+	dst, err := decodePackedFixed32(buffer, dst)
+	if err != nil {
+		return dst, fmt.Errorf("DecodePackedSfixed32: %v", err)
+	}
+	return dst, nil
+}
+
+// DecodePackedSfixed64 decodes all of the values in the packed repeated sfixed64 field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedSfixed64(buffer *codec.Buffer, dst []int64) ([]int64, error) {
+	dst, err := decodePackedFixed64(buffer, dst)
+	if err != nil {
+		return dst, fmt.Errorf("DecodePackedSfixed64: %v", err)
+	}
+	return dst, nil
+}
+
+// DecodePackedFloat decodes all of the values in the packed repeated float field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedFloat(buffer *codec.Buffer, dst []float32) ([]float32, error) {
+	dst, err := decodePackedFixed32(buffer, dst)
+	if err != nil {
+		return dst, fmt.Errorf("DecodePackedFloat: %v", err)
+	}
+	return dst, nil
+}
+
+// DecodePackedDouble decodes all of the values in the packed repeated double field stored
+// in buffer and appends them to dst, which is returned.
+func DecodePackedDouble(buffer *codec.Buffer, dst []float64) ([]float64, error) {
+	dst, err := decodePackedFixed64(buffer, dst)
+	if err != nil {
+		return dst, fmt.Errorf("DecodePackedDouble: %v", err)
+	}
+	return dst, nil
+}
+
+// fixed32 is the set of types that are encoded with the fixed32 wire type.
+type fixed32 interface {
+	uint32 | int32 | float32
+}
+
+// fixed64 is the set of types that are encoded with the fixed64 wire type.
+type fixed64 interface {
+	uint64 | int64 | float64
+}
+
+func decodePackedFixed32[T fixed32](buffer *codec.Buffer, dst []T) ([]T, error) {
+	b := buffer.Bytes()
+	if len(b)%4 != 0 {
+		return dst, fmt.Errorf("packed fixed32 field has invalid length: %d", len(b))
+	}
+	n := len(dst)
+	dst = grow(dst, len(b)/4)[:n+len(b)/4]
+	copyFixed32(dst[n:], b)
+	return dst, buffer.Skip(len(b))
+}
+
+func decodePackedFixed64[T fixed64](buffer *codec.Buffer, dst []T) ([]T, error) {
+	b := buffer.Bytes()
+	if len(b)%8 != 0 {
+		return dst, fmt.Errorf("packed fixed64 field has invalid length: %d", len(b))
+	}
+	n := len(dst)
+	dst = grow(dst, len(b)/8)[:n+len(b)/8]
+	copyFixed64(dst[n:], b)
+	return dst, buffer.Skip(len(b))
+}
+
+// smallVarints returns the next eight bytes of b as a little-endian word, and whether
+// they are all single byte varints, which is common for small values.
+func smallVarints(b []byte) (uint64, bool) {
+	if len(b) < 8 {
+		return 0, false
+	}
+	w := binary.LittleEndian.Uint64(b)
+	return w, w&msbs == 0
+}
+
+// decodeVarint decodes a single varint from the beginning of b and returns it along with
+// its size in bytes. Varints of up to 8 bytes are decoded a word at a time, without
+// looping over the individual bytes.
+func decodeVarint(b []byte) (uint64, int, error) {
+	if len(b) >= 8 {
+		w := binary.LittleEndian.Uint64(b)
+		if stops := ^w & msbs; stops != 0 {
+			// The varint ends at the first byte that does not have its continuation bit set.
+			size := bits.TrailingZeros64(stops)/8 + 1
+			w &= math.MaxUint64 >> (64 - 8*uint(size))
+
+			// Drop the continuation bits and pack the remaining 7 bit groups together.
+			x := w &^ msbs
+			x = (x & 0x007f007f007f007f) | ((x & 0x7f007f007f007f00) >> 1)
+			x = (x & 0x00003fff00003fff) | ((x & 0x3fff00003fff0000) >> 2)
+			x = (x & 0x000000000fffffff) | ((x & 0x0fffffff00000000) >> 4)
+			return x, size, nil
+		}
+	}
+
+	// Slow path for short buffers and varints that are longer than 8 bytes.
+	buffer := codec.NewBuffer(b)
+	v, err := buffer.DecodeVarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return v, len(b) - buffer.Len(), nil
+}
+
+// countVarints returns the number of varints that end in b, which is the number of bytes
+// that do not have their continuation bit set.
+func countVarints(b []byte) int {
+	count := 0
+	for len(b) >= 8 {
+		count += bits.OnesCount64(^binary.LittleEndian.Uint64(b) & msbs)
+		b = b[8:]
+	}
+	for _, c := range b {
+		if c < 0x80 {
+			count++
+		}
+	}
+	return count
+}
+
+// grow ensures that s has room for at least n more elements without reallocating.
+func grow[T any](s []T, n int) []T {
+	if n <= cap(s)-len(s) {
+		return s
+	}
+	grown := make([]T, len(s), len(s)+n)
+	copy(grown, s)
+	return grown
+}
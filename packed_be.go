@@ -0,0 +1,22 @@
+//go:build !(386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm)
+
+package molecule
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// copyFixed32 copies the little-endian fixed32 values in b into dst.
+func copyFixed32[T fixed32](dst []T, b []byte) {
+	for i := range dst {
+		*(*uint32)(unsafe.Pointer(&dst[i])) = binary.LittleEndian.Uint32(b[i*4:])
+	}
+}
+
+// copyFixed64 copies the little-endian fixed64 values in b into dst.
+func copyFixed64[T fixed64](dst []T, b []byte) {
+	for i := range dst {
+		*(*uint64)(unsafe.Pointer(&dst[i])) = binary.LittleEndian.Uint64(b[i*8:])
+	}
+}
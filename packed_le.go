@@ -0,0 +1,23 @@
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm
+
+package molecule
+
+import "unsafe"
+
+// copyFixed32 copies the little-endian fixed32 values in b into dst. On little-endian
+// platforms the in-memory representation matches the wire format, so this is a memcpy.
+func copyFixed32[T fixed32](dst []T, b []byte) {
+	if len(dst) == 0 {
+		return
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(dst))), len(dst)*4), b)
+}
+
+// copyFixed64 copies the little-endian fixed64 values in b into dst. On little-endian
+// platforms the in-memory representation matches the wire format, so this is a memcpy.
+func copyFixed64[T fixed64](dst []T, b []byte) {
+	if len(dst) == 0 {
+		return
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(dst))), len(dst)*8), b)
+}
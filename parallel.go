@@ -0,0 +1,184 @@
+package molecule
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+const (
+	// The parallelBatchSize is the number of entries that a worker claims at a
+	// time unless the entries are statically partitioned, which keeps contention
+	// on the shared counter low while still balancing the load between workers.
+	parallelBatchSize = 64
+)
+
+// ParallelRepeatedEachFn is a function that is called for each entry of the repeated
+// field passed to ParallelRepeatedEach. The worker is the index of the worker calling
+// it, from 0 to Workers-1, and the index is the position of the entry in the field.
+// The buffer is owned by the worker and is only valid until fn returns.
+type ParallelRepeatedEachFn func(worker int, index int, buffer *codec.Buffer) error
+
+// ParallelOptions configures ParallelRepeatedEach.
+type ParallelOptions struct {
+	// The Workers is the number of goroutines that call fn concurrently.  It
+	// defaults to runtime.GOMAXPROCS(0).
+	Workers int
+
+	// The StaticPartitioning flag splits the entries up front into contiguous
+	// ranges, one per worker in order, and has each worker process its range in
+	// order.  The workers still run concurrently, so fn is not called in the
+	// order of the entries, but results that each worker accumulates can be
+	// concatenated in worker order to preserve the order of the entries.
+	// Otherwise, entries are handed out to whichever worker is free, which
+	// balances the load better when entries vary in cost.
+	StaticPartitioning bool
+}
+
+// ParallelRepeatedEach decodes the entries of a repeated embedded message (or bytes)
+// field in parallel. It first scans the message stored in buffer and records the
+// occurrences of the field with number fieldNum, skipping over all other fields, and
+// then calls fn on each entry from a pool of workers, each with its own codec.Buffer
+// over the entry.
+//
+// ParallelRepeatedEach returns once every call to fn has returned. It stops handing out
+// entries as soon as fn returns an error or ctx is cancelled, and returns the first
+// error, or the error of ctx.
+func ParallelRepeatedEach(ctx context.Context, buffer *codec.Buffer, fieldNum int32, opts ParallelOptions, fn ParallelRepeatedEachFn) error {
+	entries, err := repeatedEntries(buffer, fieldNum)
+	if err != nil {
+		return fmt.Errorf("ParallelRepeatedEach: %v", err)
+	}
+	if len(entries) == 0 {
+		return ctx.Err()
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(entries) {
+		workers = len(entries)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		next     atomic.Int64
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	// process calls fn on entries[start:end] in order.
+	process := func(worker int, buffer *codec.Buffer, start, end int) bool {
+		for i := start; i < end; i++ {
+			if err := ctx.Err(); err != nil {
+				fail(err)
+				return false
+			}
+			buffer.Reset(entries[i])
+			if err := fn(worker, i, buffer); err != nil {
+				fail(err)
+				return false
+			}
+		}
+		return true
+	}
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(worker int) {
+			defer wg.Done()
+			// The entries are views over the message of the caller, which are not
+			// poisoned.
+			buffer := codec.NewBuffer(nil)
+			buffer.PoisonOnReset(false)
+			if opts.StaticPartitioning {
+				process(worker, buffer, worker*len(entries)/workers, (worker+1)*len(entries)/workers)
+				return
+			}
+			for {
+				start := int(next.Add(parallelBatchSize)) - parallelBatchSize
+				if start >= len(entries) {
+					return
+				}
+				end := start + parallelBatchSize
+				if end > len(entries) {
+					end = len(entries)
+				}
+				if !process(worker, buffer, start, end) {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// repeatedEntries returns the payloads of the occurrences of the length-delimited field
+// with number fieldNum in the message stored in buffer, skipping over the values of all
+// other fields without decoding them.
+func repeatedEntries(buffer *codec.Buffer, fieldNum int32) ([][]byte, error) {
+	var entries [][]byte
+	for !buffer.EOF() {
+		v, err := buffer.DecodeVarint()
+		if err != nil {
+			return nil, err
+		}
+		num, wireType, err := codec.AsTagAndWireType(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if num == fieldNum {
+			if wireType != codec.WireBytes {
+				return nil, fmt.Errorf("field %d has wire type %d, expected a length-delimited field", fieldNum, wireType)
+			}
+			entry, err := buffer.DecodeRawBytes(false)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+			continue
+		}
+
+		switch wireType {
+		case codec.WireVarint:
+			_, err = buffer.DecodeVarint()
+		case codec.WireFixed32:
+			err = buffer.Skip(4)
+		case codec.WireFixed64:
+			err = buffer.Skip(8)
+		case codec.WireBytes:
+			var n uint64
+			n, err = buffer.DecodeVarint()
+			if err == nil {
+				if n > uint64(buffer.Len()) {
+					err = fmt.Errorf("length %d of field %d is longer than the remaining %d bytes", n, num, buffer.Len())
+				} else {
+					err = buffer.Skip(int(n))
+				}
+			}
+		case codec.WireStartGroup, codec.WireEndGroup:
+			err = fmt.Errorf("encountered group wire type: %d. Groups not supported", wireType)
+		default:
+			err = fmt.Errorf("unknown wireType: %d", wireType)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
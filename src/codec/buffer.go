@@ -14,6 +14,9 @@ import (
 // data to the end of the buffer while reading pops data from the head
 // of the buffer. So the same buffer can be used to both read and write.
 type Buffer struct {
+	// debug is only used in debug builds, and is zero-sized otherwise. It is the first
+	// field so that it does not add any padding to the struct.
+	debug debugState
 	buf   []byte
 	index int
 	len   int
@@ -27,7 +30,13 @@ func NewBuffer(buf []byte) *Buffer {
 
 // Reset resets this buffer back to empty. Any subsequent writes/encodes
 // to the buffer will allocate a new backing slice of bytes.
+//
+// Unsafe views obtained from the buffer before Reset should no longer be used. In
+// debug builds this is enforced: see Debug.
 func (cb *Buffer) Reset(buf []byte) {
+	if Debug {
+		cb.debugReset(buf)
+	}
 	cb.buf = buf
 	cb.index = 0
 	cb.len = len(buf)
@@ -0,0 +1,162 @@
+//go:build moleculedebug
+
+package codec
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Debug reports whether molecule was built with the moleculedebug build tag.
+//
+// In debug builds every Buffer tracks the unsafe views (slices aliasing its input) that
+// it hands out and invalidates them when it is Reset: CheckView (and therefore the
+// Value.As*Unsafe methods) returns ErrStaleView for a view that outlived the Reset of
+// the Buffer it came from. Buffers also overwrite their previous input with a poison
+// pattern when they are Reset, so that views read directly turn into recognizable
+// garbage, unless they opt out with PoisonOnReset.
+const Debug = true
+
+// poisonByte is the value written over the previous input of a Buffer when it is Reset.
+const poisonByte = 0xdb
+
+// maxTrackedViews bounds the number of views that are tracked at once. When it is
+// exceeded, views that are already stale are forgotten, or all of the views if most of
+// them are still live. Tracked views keep the memory
+// they alias alive, so this also bounds the memory retained by debug builds.
+const maxTrackedViews = 1 << 16
+
+// debugState is the per-Buffer state used in debug builds.
+type debugState struct {
+	// generation is incremented every time the buffer is Reset.
+	generation uint64
+	// views maps the first byte of each view handed out by the buffer to the
+	// generation in which it was handed out.
+	views map[*byte]uint64
+	// noPoison is set by PoisonOnReset(false).
+	noPoison bool
+}
+
+// owners indexes the Buffers that handed out views by the first byte of the views, so
+// that CheckView can find them. Several Buffers can hand out views starting at the same
+// byte, for example when they decode the same input. Indexing by pointer keeps the
+// memory of tracked views alive, so their addresses can not be reused by the garbage
+// collector while they are tracked.
+var owners = struct {
+	sync.Mutex
+	m map[*byte][]*Buffer
+	// n is the number of views tracked by all of the Buffers.
+	n int
+}{m: make(map[*byte][]*Buffer)}
+
+// PoisonOnReset sets whether Reset overwrites the previous input of the buffer with a
+// poison pattern in debug builds, so that unsafe views of it that are read after Reset
+// turn into recognizable garbage. Poisoning is enabled by default: disable it for
+// buffers whose inputs are shared, for example inputs that are read again after the
+// buffer has been Reset, or that alias memory read by anything else, such as the
+// embedded messages of another buffer. The previous input is never poisoned if it shares
+// its backing array with the new one. PoisonOnReset has no effect unless molecule was
+// built with the moleculedebug build tag.
+func (cb *Buffer) PoisonOnReset(poison bool) {
+	cb.debug.noPoison = !poison
+}
+
+// debugReset invalidates all of the views handed out by cb, and poisons the previous
+// input unless cb opted out with PoisonOnReset.
+func (cb *Buffer) debugReset(buf []byte) {
+	owners.Lock()
+	cb.debug.generation++
+	owners.Unlock()
+
+	if cb.debug.noPoison || overlaps(cb.buf, buf) {
+		return
+	}
+	for i := range cb.buf {
+		cb.buf[i] = poisonByte
+	}
+}
+
+// trackView records that view was handed out by cb.
+func (cb *Buffer) trackView(view []byte) {
+	if len(view) == 0 {
+		return
+	}
+
+	first := &view[0]
+	owners.Lock()
+	defer owners.Unlock()
+	if cb.debug.views == nil {
+		cb.debug.views = make(map[*byte]uint64)
+	}
+	if _, ok := cb.debug.views[first]; !ok {
+		if owners.n >= maxTrackedViews {
+			forgetViews(false)
+			if owners.n >= maxTrackedViews/2 {
+				// Most of the views are still live, for example because their Buffers
+				// are never Reset, so forget all of them instead of pruning on every call.
+				forgetViews(true)
+			}
+		}
+		owners.m[first] = append(owners.m[first], cb)
+		owners.n++
+	}
+	cb.debug.views[first] = cb.debug.generation
+}
+
+// forgetViews stops tracking the views that are stale, or all of the views if all is
+// true. Views that are no longer tracked are never reported as stale. owners must be
+// locked.
+func forgetViews(all bool) {
+	for first, buffers := range owners.m {
+		live := buffers[:0]
+		for _, b := range buffers {
+			if !all && b.debug.views[first] == b.debug.generation {
+				live = append(live, b)
+				continue
+			}
+			delete(b.debug.views, first)
+			owners.n--
+		}
+		if len(live) == 0 {
+			delete(owners.m, first)
+		} else {
+			owners.m[first] = live
+		}
+	}
+}
+
+// CheckView returns ErrStaleView if view was handed out by a Buffer that has since been
+// Reset. A view handed out by several Buffers is only stale once all of them have been
+// Reset. It always returns nil unless molecule was built with the moleculedebug build
+// tag.
+func CheckView(view []byte) error {
+	if len(view) == 0 {
+		return nil
+	}
+
+	first := &view[0]
+	owners.Lock()
+	defer owners.Unlock()
+	buffers := owners.m[first]
+	for _, b := range buffers {
+		if b.debug.views[first] == b.debug.generation {
+			return nil
+		}
+	}
+	if len(buffers) > 0 {
+		return ErrStaleView
+	}
+	return nil
+}
+
+// overlaps returns whether the backing arrays of a and b overlap.
+func overlaps(a, b []byte) bool {
+	if cap(a) == 0 || cap(b) == 0 {
+		return false
+	}
+	var (
+		aStart = uintptr(unsafe.Pointer(&a[:cap(a)][0]))
+		bStart = uintptr(unsafe.Pointer(&b[:cap(b)][0]))
+	)
+	return aStart < bStart+uintptr(cap(b)) && bStart < aStart+uintptr(cap(a))
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 )
 
 // ErrOverflow is returned when an integer is too large to be represented.
@@ -15,6 +16,10 @@ var ErrOverflow = errors.New("proto: integer overflow")
 // is not valid.
 var ErrBadWireType = errors.New("proto: bad wiretype")
 
+// ErrStaleView is returned in debug builds when an unsafe view is used after the Buffer
+// it was obtained from has been Reset.
+var ErrStaleView = errors.New("molecule: unsafe view used after its Buffer was Reset")
+
 var varintTypes = map[FieldType]bool{}
 var fixed32Types = map[FieldType]bool{}
 var fixed64Types = map[FieldType]bool{}
@@ -349,6 +354,9 @@ func (cb *Buffer) DecodeRawBytes(alloc bool) (buf []byte, err error) {
 		// to read past the end of this slice
 		buf = cb.buf[cb.index:end:end]
 		cb.index = end
+		if Debug {
+			cb.trackView(buf)
+		}
 		return
 	}
 
@@ -377,6 +385,9 @@ func (cb *Buffer) ReadGroup(alloc bool) ([]byte, error) {
 	var results []byte
 	if !alloc {
 		results = cb.buf[cb.index:dataEnd]
+		if Debug {
+			cb.trackView(results)
+		}
 	} else {
 		results = make([]byte, dataEnd-cb.index)
 		copy(results, cb.buf[cb.index:])
@@ -474,7 +485,8 @@ func AsTagAndWireType(v uint64) (tag int32, wireType WireType, err error) {
 	// low 7 bits is wire type
 	wireType = WireType(v & 7)
 	tag = int32(v >> 3)
-	if tag <= 0 {
+	if tag <= 0 || v>>3 > math.MaxInt32 {
+		// Field numbers that don't fit in an int32 would otherwise be truncated.
 		err = ErrBadWireType // We return a constant error here as this allows the function to be inlined
 	}
 
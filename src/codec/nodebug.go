@@ -0,0 +1,32 @@
+//go:build !moleculedebug
+
+package codec
+
+// Debug reports whether molecule was built with the moleculedebug build tag. Debug builds
+// detect unsafe views that are used after the Buffer they were obtained from has been
+// Reset (see CheckView), and poison the previous input of a Buffer when it is Reset (see
+// PoisonOnReset).
+const Debug = false
+
+// debugState is the per-Buffer state used in debug builds. It takes up no space otherwise.
+type debugState struct{}
+
+// PoisonOnReset sets whether Reset overwrites the previous input of the buffer with a
+// poison pattern in debug builds, so that unsafe views of it that are read after Reset
+// turn into recognizable garbage. Poisoning is enabled by default: disable it for
+// buffers whose inputs are shared, for example inputs that are read again after the
+// buffer has been Reset, or that alias memory read by anything else, such as the
+// embedded messages of another buffer. The previous input is never poisoned if it shares
+// its backing array with the new one. PoisonOnReset has no effect unless molecule was
+// built with the moleculedebug build tag.
+func (cb *Buffer) PoisonOnReset(poison bool) {}
+
+func (cb *Buffer) debugReset(buf []byte) {}
+
+func (cb *Buffer) trackView(view []byte) {}
+
+// CheckView returns ErrStaleView if view was handed out by a Buffer that has since been
+// Reset. It always returns nil unless molecule was built with the moleculedebug build tag.
+func CheckView(view []byte) error {
+	return nil
+}
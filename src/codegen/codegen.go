@@ -0,0 +1,585 @@
+// Package codegen contains the code generator used by protoc-gen-go-molecule. For each
+// message in a .proto file it emits:
+//
+//  1. Field number constants.
+//  2. A visitor struct with one typed callback per field and a DecodeXxx function that
+//     drives it with molecule.Next.
+//  3. An encoder type with one typed method per field that writes to a molecule.ProtoStream.
+//  4. A reusable view struct that messages can be decoded into without allocating.
+//
+// The generated code only depends on molecule, and not on the generated protoc-gen-go
+// structs, so it can be used with or without them.
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/richardartoul/molecule/src/internal/protokind"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	moleculePackage = protogen.GoImportPath("github.com/richardartoul/molecule")
+	codecPackage    = protogen.GoImportPath("github.com/richardartoul/molecule/src/codec")
+)
+
+// FileSuffix is the suffix of the files generated by GenerateFile.
+const FileSuffix = "_molecule.pb.go"
+
+// kindInfo describes how values of a given protobuf kind are decoded and encoded.
+type kindInfo struct {
+	// goType is the Go type passed to visitor callbacks and encoder methods.
+	goType string
+	// wireType is the name of the codec.WireType constant the kind is encoded with.
+	wireType string
+	// asMethod is the molecule.Value method that interprets the raw value.
+	asMethod string
+	// decodeMethod is the codec.Buffer method used to read packed elements.
+	decodeMethod string
+	// writeMethod is the molecule.ProtoStream method that encodes the value.
+	writeMethod string
+}
+
+var kinds = map[protoreflect.Kind]kindInfo{
+	protoreflect.DoubleKind:   {"float64", "WireFixed64", "AsDouble", "DecodeFixed64", "Double"},
+	protoreflect.FloatKind:    {"float32", "WireFixed32", "AsFloat", "DecodeFixed32", "Float"},
+	protoreflect.Int32Kind:    {"int32", "WireVarint", "AsInt32", "DecodeVarint", "Int32"},
+	protoreflect.Int64Kind:    {"int64", "WireVarint", "AsInt64", "DecodeVarint", "Int64"},
+	protoreflect.Uint32Kind:   {"uint32", "WireVarint", "AsUint32", "DecodeVarint", "Uint32"},
+	protoreflect.Uint64Kind:   {"uint64", "WireVarint", "AsUint64", "DecodeVarint", "Uint64"},
+	protoreflect.Sint32Kind:   {"int32", "WireVarint", "AsSint32", "DecodeVarint", "Sint32"},
+	protoreflect.Sint64Kind:   {"int64", "WireVarint", "AsSint64", "DecodeVarint", "Sint64"},
+	protoreflect.Fixed32Kind:  {"uint32", "WireFixed32", "AsFixed32", "DecodeFixed32", "Fixed32"},
+	protoreflect.Fixed64Kind:  {"uint64", "WireFixed64", "AsFixed64", "DecodeFixed64", "Fixed64"},
+	protoreflect.Sfixed32Kind: {"int32", "WireFixed32", "AsSFixed32", "DecodeFixed32", "Sfixed32"},
+	protoreflect.Sfixed64Kind: {"int64", "WireFixed64", "AsSFixed64", "DecodeFixed64", "Sfixed64"},
+	protoreflect.BoolKind:     {"bool", "WireVarint", "AsBool", "DecodeVarint", "Bool"},
+	protoreflect.EnumKind:     {"int32", "WireVarint", "AsInt32", "DecodeVarint", "Int32"},
+	protoreflect.StringKind:   {"string", "WireBytes", "AsStringUnsafe", "", "String"},
+	protoreflect.BytesKind:    {"[]byte", "WireBytes", "AsBytesUnsafe", "", "Bytes"},
+	protoreflect.MessageKind:  {"[]byte", "WireBytes", "AsBytesUnsafe", "", "Embedded"},
+}
+
+// GenerateFile generates the molecule code for the messages in file. It returns nil
+// if file does not contain any messages. Groups are not supported by molecule, so if
+// file declares a group field, GenerateFile reports an error to gen and returns nil
+// rather than generating code that silently drops the field.
+func GenerateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	if len(file.Messages) == 0 {
+		return nil
+	}
+	for _, message := range file.Messages {
+		if err := checkSupported(message); err != nil {
+			gen.Error(fmt.Errorf("%s: %v", file.Desc.Path(), err))
+			return nil
+		}
+	}
+
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+FileSuffix, file.GoImportPath)
+	g.P("// Code generated by protoc-gen-go-molecule. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, message := range file.Messages {
+		genMessage(g, message)
+	}
+	return g
+}
+
+// checkSupported returns an error if message, or a message nested in it, declares a
+// field that the generator cannot handle. It also renames the fields whose names collide
+// with the generated identifiers (see renameCollisions).
+func checkSupported(message *protogen.Message) error {
+	for _, field := range message.Fields {
+		if _, ok := kinds[field.Desc.Kind()]; !ok {
+			return fmt.Errorf("field %s is a %v, which is not supported by molecule", field.Desc.FullName(), field.Desc.Kind())
+		}
+	}
+	renameCollisions(message)
+	for _, nested := range message.Messages {
+		if err := checkSupported(nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generatedNames are the names of the members that the generated types of a message
+// declare besides the fields: the Unknown callback of the visitor, and the Decode and
+// Reset methods of the view.  Merge is reserved too, since the cache of an embedded view
+// named Merge would collide with the merge method.
+var generatedNames = map[string]bool{
+	"Unknown": true,
+	"Decode":  true,
+	"Reset":   true,
+	"Merge":   true,
+}
+
+// renameCollisions renames the fields of message whose Go names collide with
+// generatedNames, or with the fields renamed before them, by adding underscores to them
+// like protoc-gen-go does for its own generated methods.
+func renameCollisions(message *protogen.Message) {
+	used := make(map[string]bool, len(message.Fields))
+	for _, field := range message.Fields {
+		used[field.GoName] = true
+	}
+	for _, field := range message.Fields {
+		if !generatedNames[field.GoName] {
+			continue
+		}
+		name := field.GoName + "_"
+		for generatedNames[name] || used[name] {
+			name += "_"
+		}
+		used[name] = true
+		field.GoName = name
+	}
+}
+
+func genMessage(g *protogen.GeneratedFile, message *protogen.Message) {
+	// Map entries are an implementation detail of map fields, which are exposed as the
+	// encoded bytes of each entry, so they do not get types of their own.
+	if message.Desc.IsMapEntry() {
+		return
+	}
+	fields := message.Fields
+
+	genFieldNumbers(g, message, fields)
+	genVisitor(g, message, fields)
+	genDecode(g, message, fields)
+	genEncoder(g, message, fields)
+	genView(g, message, fields)
+
+	for _, nested := range message.Messages {
+		genMessage(g, nested)
+	}
+}
+
+func genFieldNumbers(g *protogen.GeneratedFile, message *protogen.Message, fields []*protogen.Field) {
+	if len(fields) == 0 {
+		return
+	}
+	g.P("// Field numbers for ", message.Desc.FullName(), ".")
+	g.P("const (")
+	for _, field := range fields {
+		g.P(fieldNumberName(message, field), " = ", field.Desc.Number())
+	}
+	g.P(")")
+	g.P()
+}
+
+func genVisitor(g *protogen.GeneratedFile, message *protogen.Message, fields []*protogen.Field) {
+	var (
+		name          = visitorName(message)
+		moleculeValue = g.QualifiedGoIdent(moleculePackage.Ident("Value"))
+	)
+	g.P("// ", name, " contains the callbacks invoked by ", decodeName(message), " for each field")
+	g.P("// of a ", message.Desc.FullName(), " message. Nil callbacks are skipped. Returning false from")
+	g.P("// a callback stops decoding.")
+	g.P("type ", name, " struct {")
+	for _, field := range fields {
+		info := kinds[field.Desc.Kind()]
+		switch {
+		case field.Desc.Kind() == protoreflect.MessageKind:
+			g.P("// ", field.GoName, " is called with the encoded bytes of the ", field.Desc.Name(), " field, which is")
+			g.P("// an unsafe view over the decoded buffer.")
+		case field.Desc.Kind() == protoreflect.StringKind || field.Desc.Kind() == protoreflect.BytesKind:
+			g.P("// ", field.GoName, " is called with the value of the ", field.Desc.Name(), " field, which is an")
+			g.P("// unsafe view over the decoded buffer.")
+		default:
+			g.P("// ", field.GoName, " is called with the value of the ", field.Desc.Name(), " field.")
+		}
+		switch {
+		case field.Desc.IsMap():
+			g.P("// It is called once for each entry, which is encoded as a message with the key in")
+			g.P("// field 1 and the value in field 2.")
+		case field.Desc.IsList():
+			g.P("// It is called once for each element.")
+		}
+		g.P(field.GoName, " func(v ", info.goType, ") (bool, error)")
+	}
+	g.P("// Unknown is called for any field that is not part of ", message.Desc.FullName(), ",")
+	g.P("// or that is encoded with an unexpected wire type.")
+	g.P("Unknown func(fieldNum int32, value ", moleculeValue, ") (bool, error)")
+	g.P("}")
+	g.P()
+}
+
+func genDecode(g *protogen.GeneratedFile, message *protogen.Message, fields []*protogen.Field) {
+	var (
+		name        = decodeName(message)
+		codecBuffer = g.QualifiedGoIdent(codecPackage.Ident("Buffer"))
+		hasPacked   = false
+	)
+	for _, field := range fields {
+		if isPackable(field) {
+			hasPacked = true
+		}
+	}
+
+	g.P("// ", name, " decodes the ", message.Desc.FullName(), " message stored in buffer, calling the")
+	g.P("// callbacks in v for each field. Repeated scalar fields are accepted in both packed and")
+	g.P("// expanded form.")
+	g.P("func ", name, "(buffer *", codecBuffer, ", v *", visitorName(message), ") error {")
+	if hasPacked {
+		g.P("var (")
+		g.P("value ", moleculePackage.Ident("Value"))
+		g.P("packed ", codecBuffer)
+		g.P(")")
+		g.P("// The packed fields are views over the bytes in buffer, which are not poisoned.")
+		g.P("packed.PoisonOnReset(false)")
+	} else {
+		g.P("var value ", moleculePackage.Ident("Value"))
+	}
+	g.P("for !buffer.EOF() {")
+	g.P("fieldNum, err := ", moleculePackage.Ident("Next"), "(buffer, &value)")
+	g.P("if err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P()
+	g.P("switch uint64(fieldNum)<<3 | uint64(value.WireType) {")
+	for _, field := range fields {
+		info := kinds[field.Desc.Kind()]
+		g.P("case ", fieldNumberName(message, field), "<<3 | uint64(", codecPackage.Ident(info.wireType), "):")
+		g.P("if v.", field.GoName, " == nil {")
+		g.P("continue")
+		g.P("}")
+		g.P("x, err := value.", info.asMethod, "()")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("if shouldContinue, err := v.", field.GoName, "(x); err != nil || !shouldContinue {")
+		g.P("return err")
+		g.P("}")
+
+		if !isPackable(field) {
+			continue
+		}
+		g.P("case ", fieldNumberName(message, field), "<<3 | uint64(", codecPackage.Ident("WireBytes"), "):")
+		g.P("if v.", field.GoName, " == nil {")
+		g.P("continue")
+		g.P("}")
+		g.P("packed.Reset(value.Bytes)")
+		g.P("for !packed.EOF() {")
+		g.P("value.Number, err = packed.", info.decodeMethod, "()")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("x, err := value.", info.asMethod, "()")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("if shouldContinue, err := v.", field.GoName, "(x); err != nil || !shouldContinue {")
+		g.P("return err")
+		g.P("}")
+		g.P("}")
+	}
+	g.P("default:")
+	g.P("if v.Unknown == nil {")
+	g.P("continue")
+	g.P("}")
+	g.P("if shouldContinue, err := v.Unknown(fieldNum, value); err != nil || !shouldContinue {")
+	g.P("return err")
+	g.P("}")
+	g.P("}")
+	g.P("}")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+}
+
+func genEncoder(g *protogen.GeneratedFile, message *protogen.Message, fields []*protogen.Field) {
+	var (
+		name        = encoderName(message)
+		protoStream = g.QualifiedGoIdent(moleculePackage.Ident("ProtoStream"))
+	)
+	g.P("// ", name, " writes the fields of a ", message.Desc.FullName(), " message to a ProtoStream.")
+	g.P("type ", name, " struct {")
+	g.P("ps *", protoStream)
+	g.P("}")
+	g.P()
+	g.P("// New", name, " returns a ", name, " that writes to ps.")
+	g.P("func New", name, "(ps *", protoStream, ") ", name, " {")
+	g.P("return ", name, "{ps: ps}")
+	g.P("}")
+	g.P()
+
+	for _, field := range fields {
+		var (
+			info   = kinds[field.Desc.Kind()]
+			number = fieldNumberName(message, field)
+		)
+		switch {
+		case field.Desc.Kind() == protoreflect.MessageKind:
+			g.P("// ", field.GoName, " writes the ", field.Desc.Name(), " field as an embedded message, calling inner")
+			g.P("// to write its fields.")
+			switch {
+			case field.Desc.IsMap():
+				g.P("// Call it once for each entry, writing the key to field 1 and the value to field 2.")
+			case field.Desc.IsList():
+				g.P("// Call it once for each element.")
+			}
+			g.P("func (e ", name, ") ", field.GoName, "(inner func(*", protoStream, ") error) error {")
+			g.P("return e.ps.Embedded(", number, ", inner)")
+			g.P("}")
+		case isPackable(field) && field.Desc.IsPacked():
+			g.P("// ", field.GoName, " writes the ", field.Desc.Name(), " field in packed form.")
+			g.P("func (e ", name, ") ", field.GoName, "(v []", info.goType, ") error {")
+			g.P("return e.ps.", info.writeMethod, "Packed(", number, ", v)")
+			g.P("}")
+		case isPackable(field):
+			g.P("// ", field.GoName, " writes the ", field.Desc.Name(), " field in expanded (non-packed) form.")
+			g.P("func (e ", name, ") ", field.GoName, "(v []", info.goType, ") error {")
+			g.P("return e.ps.", info.writeMethod, "Expanded(", number, ", v)")
+			g.P("}")
+		case field.Desc.IsList():
+			g.P("// ", field.GoName, " writes each element of the ", field.Desc.Name(), " field, including zero values.")
+			g.P("func (e ", name, ") ", field.GoName, "(v []", info.goType, ") error {")
+			genEmitZeroValues(g)
+			g.P("for _, x := range v {")
+			g.P("if err := e.ps.", info.writeMethod, "(", number, ", x); err != nil {")
+			g.P("return err")
+			g.P("}")
+			g.P("}")
+			g.P("return nil")
+			g.P("}")
+		case field.Desc.HasPresence():
+			g.P("// ", field.GoName, " writes the ", field.Desc.Name(), " field. The field has explicit presence, so it")
+			g.P("// is written even if v is the zero value.")
+			g.P("func (e ", name, ") ", field.GoName, "(v ", info.goType, ") error {")
+			genEmitZeroValues(g)
+			g.P("return e.ps.", info.writeMethod, "(", number, ", v)")
+			g.P("}")
+		default:
+			g.P("// ", field.GoName, " writes the ", field.Desc.Name(), " field.")
+			g.P("func (e ", name, ") ", field.GoName, "(v ", info.goType, ") error {")
+			g.P("return e.ps.", info.writeMethod, "(", number, ", v)")
+			g.P("}")
+		}
+		g.P()
+	}
+}
+
+// genEmitZeroValues generates code that sets EmitZeroValues on the encoder's
+// ProtoStream until the enclosing function returns, for fields whose zero values must
+// be written.
+func genEmitZeroValues(g *protogen.GeneratedFile) {
+	g.P("emitZeroValues := e.ps.EmitZeroValues")
+	g.P("e.ps.EmitZeroValues = true")
+	g.P("defer func() { e.ps.EmitZeroValues = emitZeroValues }()")
+}
+
+func genView(g *protogen.GeneratedFile, message *protogen.Message, fields []*protogen.Field) {
+	var (
+		name        = viewName(message)
+		codecBuffer = g.QualifiedGoIdent(codecPackage.Ident("Buffer"))
+		hasInner    = false
+	)
+	g.P("// ", name, " is a reusable struct that ", message.Desc.FullName(), " messages can be decoded into.")
+	g.P("// String and bytes fields are unsafe views over the decoded buffer, and Reset truncates")
+	g.P("// repeated fields and retains nested messages instead of releasing them, so once a ", name)
+	g.P("// has grown to fit the messages decoded into it, decoding does not allocate. Callers may")
+	g.P("// also preallocate repeated fields with the capacity they expect.")
+	g.P("type ", name, " struct {")
+	for _, field := range fields {
+		switch {
+		case isViewMessage(message, field) && field.Desc.IsList():
+			hasInner = true
+			g.P(field.GoName, " []", viewName(field.Message))
+		case isViewMessage(message, field):
+			hasInner = true
+			g.P("// ", field.GoName, " is nil if the ", field.Desc.Name(), " field is not present.")
+			g.P(field.GoName, " *", viewName(field.Message))
+		case field.Desc.IsMap():
+			g.P("// ", field.GoName, " holds the encoded entries of the ", field.Desc.Name(), " map.")
+			g.P(field.GoName, " [][]byte")
+		case isRepeated(field):
+			if isPackable(field) {
+				hasInner = true
+			}
+			g.P(field.GoName, " []", kinds[field.Desc.Kind()].goType)
+		default:
+			g.P(field.GoName, " ", kinds[field.Desc.Kind()].goType)
+		}
+	}
+	for _, field := range fields {
+		if isViewMessage(message, field) && !field.Desc.IsList() {
+			g.P()
+			g.P("// ", cacheName(field), " retains ", field.GoName, " across calls to Reset for reuse.")
+			g.P(cacheName(field), " *", viewName(field.Message))
+		}
+	}
+	g.P("}")
+	g.P()
+
+	g.P("// Reset clears m so that it can be reused, retaining any memory it has allocated.")
+	g.P("func (m *", name, ") Reset() {")
+	for _, field := range fields {
+		switch {
+		case isViewMessage(message, field) && !field.Desc.IsList():
+			g.P("if m.", field.GoName, " != nil {")
+			g.P("m.", cacheName(field), " = m.", field.GoName)
+			g.P("m.", field.GoName, " = nil")
+			g.P("}")
+		case isRepeated(field):
+			g.P("m.", field.GoName, " = m.", field.GoName, "[:0]")
+		default:
+			g.P("m.", field.GoName, " = ", zeroValue(field))
+		}
+	}
+	g.P("}")
+	g.P()
+
+	g.P("// Decode resets m and decodes the ", message.Desc.FullName(), " message stored in buffer into it.")
+	g.P("// Repeated scalar fields are accepted in both packed and expanded form.")
+	g.P("func (m *", name, ") Decode(buffer *", codecBuffer, ") error {")
+	g.P("m.Reset()")
+	g.P("return m.merge(buffer)")
+	g.P("}")
+	g.P()
+
+	g.P("// merge decodes the message stored in buffer into m without resetting it first, which")
+	g.P("// implements the merge semantics of repeated occurrences of embedded messages.")
+	g.P("func (m *", name, ") merge(buffer *", codecBuffer, ") error {")
+	if hasInner {
+		g.P("var (")
+		g.P("value ", moleculePackage.Ident("Value"))
+		g.P("inner ", codecBuffer)
+		g.P(")")
+		g.P("// The embedded messages are views over the bytes in buffer, which are not poisoned.")
+		g.P("inner.PoisonOnReset(false)")
+	} else {
+		g.P("var value ", moleculePackage.Ident("Value"))
+	}
+	g.P("for !buffer.EOF() {")
+	g.P("fieldNum, err := ", moleculePackage.Ident("Next"), "(buffer, &value)")
+	g.P("if err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P()
+	g.P("switch uint64(fieldNum)<<3 | uint64(value.WireType) {")
+	for _, field := range fields {
+		info := kinds[field.Desc.Kind()]
+		g.P("case ", fieldNumberName(message, field), "<<3 | uint64(", codecPackage.Ident(info.wireType), "):")
+		switch {
+		case isViewMessage(message, field) && field.Desc.IsList():
+			g.P("n := len(m.", field.GoName, ")")
+			g.P("if n < cap(m.", field.GoName, ") {")
+			g.P("m.", field.GoName, " = m.", field.GoName, "[:n+1]")
+			g.P("m.", field.GoName, "[n].Reset()")
+			g.P("} else {")
+			g.P("m.", field.GoName, " = append(m.", field.GoName, ", ", viewName(field.Message), "{})")
+			g.P("}")
+			g.P("inner.Reset(value.Bytes)")
+			g.P("if err := m.", field.GoName, "[n].merge(&inner); err != nil {")
+			g.P("return err")
+			g.P("}")
+		case isViewMessage(message, field):
+			g.P("if m.", field.GoName, " == nil {")
+			g.P("if m.", cacheName(field), " == nil {")
+			g.P("m.", cacheName(field), " = &", viewName(field.Message), "{}")
+			g.P("} else {")
+			g.P("m.", cacheName(field), ".Reset()")
+			g.P("}")
+			g.P("m.", field.GoName, " = m.", cacheName(field))
+			g.P("}")
+			g.P("inner.Reset(value.Bytes)")
+			g.P("if err := m.", field.GoName, ".merge(&inner); err != nil {")
+			g.P("return err")
+			g.P("}")
+		case isRepeated(field):
+			g.P("x, err := value.", info.asMethod, "()")
+			g.P("if err != nil {")
+			g.P("return err")
+			g.P("}")
+			g.P("m.", field.GoName, " = append(m.", field.GoName, ", x)")
+		default:
+			g.P("if m.", field.GoName, ", err = value.", info.asMethod, "(); err != nil {")
+			g.P("return err")
+			g.P("}")
+		}
+
+		if !isPackable(field) {
+			continue
+		}
+		g.P("case ", fieldNumberName(message, field), "<<3 | uint64(", codecPackage.Ident("WireBytes"), "):")
+		g.P("inner.Reset(value.Bytes)")
+		g.P("for !inner.EOF() {")
+		g.P("value.Number, err = inner.", info.decodeMethod, "()")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("x, err := value.", info.asMethod, "()")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("m.", field.GoName, " = append(m.", field.GoName, ", x)")
+		g.P("}")
+	}
+	g.P("}")
+	g.P("}")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+}
+
+// isViewMessage returns whether field is an embedded message that is decoded into a
+// nested view. Views are only generated for messages in the same Go package, so embedded
+// messages from other packages, and the entries of map fields, are exposed as their
+// encoded bytes instead.
+func isViewMessage(message *protogen.Message, field *protogen.Field) bool {
+	return field.Message != nil && !field.Desc.IsMap() && field.Message.GoIdent.GoImportPath == message.GoIdent.GoImportPath
+}
+
+// isRepeated returns whether field may occur more than once in a message: a repeated
+// field, or a map field, which occurs once for each entry.
+func isRepeated(field *protogen.Field) bool {
+	return field.Desc.IsList() || field.Desc.IsMap()
+}
+
+func zeroValue(field *protogen.Field) string {
+	switch kinds[field.Desc.Kind()].goType {
+	case "bool":
+		return "false"
+	case "string":
+		return `""`
+	case "[]byte":
+		return "nil"
+	default:
+		return "0"
+	}
+}
+
+// isPackable returns whether field is a repeated scalar field that may be encoded in
+// packed form.
+func isPackable(field *protogen.Field) bool {
+	return field.Desc.IsList() && protokind.IsPackable(field.Desc.Kind())
+}
+
+func fieldNumberName(message *protogen.Message, field *protogen.Field) string {
+	return fmt.Sprintf("%s_%s_FieldNumber", message.GoIdent.GoName, field.GoName)
+}
+
+func visitorName(message *protogen.Message) string {
+	return message.GoIdent.GoName + "Visitor"
+}
+
+func decodeName(message *protogen.Message) string {
+	return "Decode" + message.GoIdent.GoName
+}
+
+func viewName(message *protogen.Message) string {
+	return message.GoIdent.GoName + "View"
+}
+
+func cacheName(field *protogen.Field) string {
+	return strings.ToLower(field.GoName[:1]) + field.GoName[1:]
+}
+
+func encoderName(message *protogen.Message) string {
+	return message.GoIdent.GoName + "Encoder"
+}
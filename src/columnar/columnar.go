@@ -0,0 +1,357 @@
+// Package columnar decodes batches of messages of the same type into columns, in the
+// style of Apache Arrow: each column holds the values of one field for every message in
+// a typed slice, along with a validity bitmap that records which messages had the field.
+//
+// The fields to extract are described by a schema of Columns, each of which has the
+// path of field numbers leading from the root message to the field, and the field's
+// type. A Decoder scans each message once, regardless of the number of columns, and does
+// not allocate other than to grow the columns.
+package columnar
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/internal/protokind"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// A Column holds the values of a single field for a batch of messages.
+//
+// Depending on the Type, the values are stored in one of:
+//
+//   - Int64s for INT32, INT64, SINT32, SINT64, SFIXED32, SFIXED64 and ENUM fields.
+//   - Uint64s for UINT32, UINT64, FIXED32 and FIXED64 fields.
+//   - Float64s for FLOAT and DOUBLE fields.
+//   - Bools for BOOL fields.
+//   - Offsets and Data for STRING, BYTES and MESSAGE fields, where the value for message
+//     i is Data[Offsets[i]:Offsets[i+1]]. The Data of a column can not grow past
+//     math.MaxInt32 bytes, and appending a message that would make it do so fails.
+//
+// Messages that do not have the field get the zero value, and their bit in Validity is
+// not set. If a field occurs more than once, the last occurrence wins, as it does for
+// fields that are not repeated.
+type Column struct {
+	// The Path is the field numbers of the embedded message fields leading from the
+	// root message to the field, followed by the number of the field itself.
+	Path []int32
+	// The Type is the type of the field.
+	Type codec.FieldType
+
+	Int64s   []int64
+	Uint64s  []uint64
+	Float64s []float64
+	Bools    []bool
+	Offsets  []int32
+	Data     []byte
+
+	// The Validity is a bitmap with a bit per message, in least significant bit
+	// order, that is set if the message had the field.
+	Validity []byte
+	// The Len is the number of messages in the column.
+	Len int
+	// The NullCount is the number of messages that did not have the field.
+	NullCount int
+
+	// The value and present track the last occurrence of the field in the
+	// message being decoded, and the converted holds the value once it has been
+	// converted to the type of the values of the column.
+	value     molecule.Value
+	present   bool
+	converted converted
+}
+
+// A converted is a value converted to the type of the values of a Column.  Only the field
+// that corresponds to the Type of the Column is set.
+type converted struct {
+	int64Value   int64
+	uint64Value  uint64
+	float64Value float64
+	boolValue    bool
+}
+
+// IsValid returns whether message i had the field.
+func (c *Column) IsValid(i int) bool {
+	return c.Validity[i/8]&(1<<(i%8)) != 0
+}
+
+// Reset empties the column, retaining its memory for reuse.
+func (c *Column) Reset() {
+	c.Int64s = c.Int64s[:0]
+	c.Uint64s = c.Uint64s[:0]
+	c.Float64s = c.Float64s[:0]
+	c.Bools = c.Bools[:0]
+	c.Offsets = c.Offsets[:0]
+	c.Data = c.Data[:0]
+	c.Validity = c.Validity[:0]
+	c.Len = 0
+	c.NullCount = 0
+}
+
+// convert converts the value tracked for the message that was just decoded to the type
+// of the values of the column, and checks that the Offsets of the column can hold it, so
+// that appending it with appendRow can not fail.
+func (c *Column) convert() error {
+	c.converted = converted{}
+	if !c.present {
+		return nil
+	}
+
+	var (
+		v   = &c.value
+		err error
+	)
+	switch c.Type {
+	case codec.FieldType_INT32, codec.FieldType_ENUM:
+		var x int32
+		x, err = v.AsInt32()
+		c.converted.int64Value = int64(x)
+	case codec.FieldType_SINT32:
+		var x int32
+		x, err = v.AsSint32()
+		c.converted.int64Value = int64(x)
+	case codec.FieldType_SFIXED32:
+		var x int32
+		x, err = v.AsSFixed32()
+		c.converted.int64Value = int64(x)
+	case codec.FieldType_INT64:
+		c.converted.int64Value, err = v.AsInt64()
+	case codec.FieldType_SINT64:
+		c.converted.int64Value, err = v.AsSint64()
+	case codec.FieldType_SFIXED64:
+		c.converted.int64Value, err = v.AsSFixed64()
+	case codec.FieldType_UINT32:
+		var x uint32
+		x, err = v.AsUint32()
+		c.converted.uint64Value = uint64(x)
+	case codec.FieldType_FIXED32:
+		var x uint32
+		x, err = v.AsFixed32()
+		c.converted.uint64Value = uint64(x)
+	case codec.FieldType_UINT64:
+		c.converted.uint64Value, err = v.AsUint64()
+	case codec.FieldType_FIXED64:
+		c.converted.uint64Value, err = v.AsFixed64()
+	case codec.FieldType_FLOAT:
+		var x float32
+		x, err = v.AsFloat()
+		c.converted.float64Value = float64(x)
+	case codec.FieldType_DOUBLE:
+		c.converted.float64Value, err = v.AsDouble()
+	case codec.FieldType_BOOL:
+		c.converted.boolValue, err = v.AsBool()
+	default:
+		if int64(len(c.Data))+int64(len(v.Bytes)) > math.MaxInt32 {
+			err = fmt.Errorf("data would grow to %d bytes, which overflows the offsets", int64(len(c.Data))+int64(len(v.Bytes)))
+		}
+	}
+	return err
+}
+
+// appendRow appends the value tracked for the message that was just decoded, which must
+// have been converted with convert.
+func (c *Column) appendRow() {
+	if c.Len%8 == 0 {
+		c.Validity = append(c.Validity, 0)
+	}
+	if c.present {
+		c.Validity[c.Len/8] |= 1 << (c.Len % 8)
+	} else {
+		c.NullCount++
+	}
+	c.Len++
+
+	switch c.Type {
+	case codec.FieldType_INT32, codec.FieldType_ENUM, codec.FieldType_SINT32, codec.FieldType_SFIXED32,
+		codec.FieldType_INT64, codec.FieldType_SINT64, codec.FieldType_SFIXED64:
+		c.Int64s = append(c.Int64s, c.converted.int64Value)
+	case codec.FieldType_UINT32, codec.FieldType_FIXED32, codec.FieldType_UINT64, codec.FieldType_FIXED64:
+		c.Uint64s = append(c.Uint64s, c.converted.uint64Value)
+	case codec.FieldType_FLOAT, codec.FieldType_DOUBLE:
+		c.Float64s = append(c.Float64s, c.converted.float64Value)
+	case codec.FieldType_BOOL:
+		c.Bools = append(c.Bools, c.converted.boolValue)
+	default:
+		if len(c.Offsets) == 0 {
+			c.Offsets = append(c.Offsets, 0)
+		}
+		if c.present {
+			c.Data = append(c.Data, c.value.Bytes...)
+		}
+		c.Offsets = append(c.Offsets, int32(len(c.Data)))
+	}
+	c.discard()
+}
+
+// discard forgets the value tracked for the message that was just decoded.
+func (c *Column) discard() {
+	c.value = molecule.Value{}
+	c.present = false
+}
+
+// A Decoder decodes batches of messages into a set of Columns.
+//
+// The columns do not refer to the bytes of the messages once they have been appended.
+//
+// Decoder instances are *not* threadsafe.
+type Decoder struct {
+	columns []*Column
+	root    node
+
+	// The buffers are used and re-used to decode the embedded messages at each
+	// level of the paths.
+	buffers []codec.Buffer
+}
+
+// A node is an element of the trie formed by the paths of the columns.
+type node struct {
+	fieldNum int32
+	// The columns are the columns whose path ends at this node.
+	columns []*Column
+	// The children are the nodes for the fields of the embedded message at
+	// this node.
+	children []*node
+}
+
+// NewDecoder creates a new Decoder that appends to columns.
+func NewDecoder(columns []*Column) (*Decoder, error) {
+	d := &Decoder{columns: columns}
+	maxDepth := 0
+	for _, c := range columns {
+		if len(c.Path) == 0 {
+			return nil, fmt.Errorf("NewDecoder: column has an empty path")
+		}
+		switch c.Type {
+		case codec.FieldType_GROUP:
+			return nil, fmt.Errorf("NewDecoder: column %v: groups are not supported", c.Path)
+		case 0:
+			return nil, fmt.Errorf("NewDecoder: column %v: missing type", c.Path)
+		}
+		if c.Type > codec.FieldType_SINT64 {
+			return nil, fmt.Errorf("NewDecoder: column %v: unknown type: %d", c.Path, c.Type)
+		}
+
+		n := &d.root
+		for _, fieldNum := range c.Path {
+			n = n.child(fieldNum)
+		}
+		n.columns = append(n.columns, c)
+		if len(c.Path) > maxDepth {
+			maxDepth = len(c.Path)
+		}
+	}
+	d.buffers = make([]codec.Buffer, maxDepth)
+	for i := range d.buffers {
+		// The buffers hold the messages passed to Append and their embedded messages,
+		// which are not poisoned.
+		d.buffers[i].PoisonOnReset(false)
+	}
+	return d, nil
+}
+
+// child returns the child of n for fieldNum, adding it if it doesn't exist.
+func (n *node) child(fieldNum int32) *node {
+	for _, c := range n.children {
+		if c.fieldNum == fieldNum {
+			return c
+		}
+	}
+	c := &node{fieldNum: fieldNum}
+	n.children = append(n.children, c)
+	return c
+}
+
+// Append decodes each of messages and appends a row for it to every column. If an error
+// is returned, the rows for the messages before the one that failed have been appended.
+func (d *Decoder) Append(messages [][]byte) error {
+	for i, message := range messages {
+		if err := d.AppendMessage(message); err != nil {
+			return fmt.Errorf("Append: message %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// AppendMessage decodes message and appends a row for it to every column. If an error is
+// returned, no row is appended to any of the columns.
+func (d *Decoder) AppendMessage(message []byte) error {
+	d.buffers[0].Reset(message)
+	if err := d.scan(&d.root, 0); err != nil {
+		d.discard()
+		return fmt.Errorf("AppendMessage: %v", err)
+	}
+
+	// Convert every value before appending any of them, so that a value that can not
+	// be converted does not leave the columns with different lengths.
+	for _, c := range d.columns {
+		if err := c.convert(); err != nil {
+			d.discard()
+			return fmt.Errorf("AppendMessage: column %v: %v", c.Path, err)
+		}
+	}
+	for _, c := range d.columns {
+		c.appendRow()
+	}
+	return nil
+}
+
+// discard forgets the values tracked for the message that was just decoded.
+func (d *Decoder) discard() {
+	for _, c := range d.columns {
+		c.discard()
+	}
+}
+
+// Reset empties every column, retaining their memory for reuse.
+func (d *Decoder) Reset() {
+	for _, c := range d.columns {
+		c.Reset()
+	}
+}
+
+// scan scans the message in d.buffers[depth], whose fields are described by the children
+// of n, and records the values of the columns.
+func (d *Decoder) scan(n *node, depth int) error {
+	buffer := &d.buffers[depth]
+	for !buffer.EOF() {
+		// Next does not clear the fields of value that it does not set, so a new
+		// value is used for each field.
+		var value molecule.Value
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+
+		var child *node
+		for _, c := range n.children {
+			if c.fieldNum == fieldNum {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			continue
+		}
+
+		for _, c := range child.columns {
+			if expected := protokind.WireType(protoreflect.Kind(c.Type)); value.WireType != expected {
+				return fmt.Errorf("column %v: wire type %d does not match type %d, expected wire type %d", c.Path, value.WireType, c.Type, expected)
+			}
+			c.value = value
+			c.present = true
+		}
+		if len(child.children) > 0 {
+			if value.WireType != codec.WireBytes {
+				return fmt.Errorf("field %d: expected an embedded message, got wire type %d", fieldNum, value.WireType)
+			}
+			d.buffers[depth+1].Reset(value.Bytes)
+			if err := d.scan(child, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+// Package conformance implements a testee for the conformance_test_runner of the
+// protobuf project, so that molecule's decoder and ProtoStream encoder can be checked
+// against the official conformance suite.
+//
+// The runner starts the testee as a subprocess and sends it ConformanceRequest messages
+// on stdin, each preceded by its length as a 4-byte little-endian integer, and expects a
+// ConformanceResponse, framed in the same way, on stdout for each of them.  Requests and
+// responses are decoded and encoded with molecule itself, and the payloads of the tests
+// are converted with protobridge, so that neither depends on generated code.
+//
+// Only protobuf input is supported.  Tests with JSON, JSPB or text format input are
+// reported as skipped, and so are tests of message types that the Testee cannot resolve.
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/protobridge"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// WireFormat is the conformance.WireFormat enum.
+type WireFormat int32
+
+// The values of the conformance.WireFormat enum.
+const (
+	WireFormatUnspecified WireFormat = 0
+	WireFormatProtobuf    WireFormat = 1
+	WireFormatJSON        WireFormat = 2
+	WireFormatJSPB        WireFormat = 3
+	WireFormatTextFormat  WireFormat = 4
+)
+
+// The field numbers of conformance.ConformanceRequest.
+const (
+	requestProtobufPayload       = 1
+	requestJSONPayload           = 2
+	requestRequestedOutputFormat = 3
+	requestMessageType           = 4
+	requestTestCategory          = 5
+	requestJSPBPayload           = 7
+	requestTextPayload           = 8
+	requestPrintUnknownFields    = 9
+)
+
+// The field numbers of conformance.ConformanceResponse.
+const (
+	responseParseError      = 1
+	responseProtobufPayload = 3
+	responseJSONPayload     = 4
+	responseSkipped         = 5
+	responseSerializeError  = 6
+)
+
+// failureSetType is the message type of the request that the runner sends first, to ask
+// the testee which tests it is expected to fail.
+const failureSetType = "conformance.FailureSet"
+
+// maxRequestSize bounds the size of a single request read by Serve.
+const maxRequestSize = 64 << 20
+
+// Request is a decoded conformance.ConformanceRequest.
+type Request struct {
+	// PayloadFormat is the format of Payload, which is WireFormatUnspecified if the
+	// request has no payload.
+	PayloadFormat WireFormat
+	// Payload is an unsafe view over the payload in the encoded request.
+	Payload               []byte
+	RequestedOutputFormat WireFormat
+	MessageType           string
+	TestCategory          int32
+	PrintUnknownFields    bool
+}
+
+// DecodeRequest decodes the conformance.ConformanceRequest stored in buffer into req.
+func DecodeRequest(buffer *codec.Buffer, req *Request) error {
+	*req = Request{}
+	err := molecule.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+		var err error
+		switch fieldNum {
+		case requestProtobufPayload:
+			req.PayloadFormat = WireFormatProtobuf
+			req.Payload, err = value.AsBytesUnsafe()
+		case requestJSONPayload:
+			req.PayloadFormat = WireFormatJSON
+			req.Payload, err = value.AsBytesUnsafe()
+		case requestJSPBPayload:
+			req.PayloadFormat = WireFormatJSPB
+			req.Payload, err = value.AsBytesUnsafe()
+		case requestTextPayload:
+			req.PayloadFormat = WireFormatTextFormat
+			req.Payload, err = value.AsBytesUnsafe()
+		case requestRequestedOutputFormat:
+			var v int32
+			v, err = value.AsInt32()
+			req.RequestedOutputFormat = WireFormat(v)
+		case requestMessageType:
+			req.MessageType, err = value.AsStringSafe()
+		case requestTestCategory:
+			req.TestCategory, err = value.AsInt32()
+		case requestPrintUnknownFields:
+			req.PrintUnknownFields, err = value.AsBool()
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("DecodeRequest: %v", err)
+	}
+	return nil
+}
+
+// Testee answers conformance requests for the message types that it can resolve.
+type Testee struct {
+	files *protoregistry.Files
+	types *protoregistry.Types
+	// Each response is encoded into output before it is framed.
+	output bytes.Buffer
+	ps     *molecule.ProtoStream
+	req    Request
+}
+
+// NewTestee returns a Testee that resolves message types in files, or in
+// protoregistry.GlobalFiles if files is nil.
+func NewTestee(files *protoregistry.Files) *Testee {
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+	t := &Testee{files: files, types: new(protoregistry.Types)}
+	t.ps = molecule.NewProtoStream(&t.output)
+	// The result of a response is a oneof, so it must be written even if it is empty.
+	t.ps.EmitZeroValues = true
+	return t
+}
+
+// LoadDescriptorSet builds a registry from a serialized google.protobuf.FileDescriptorSet,
+// such as the output of protoc --descriptor_set_out --include_imports, for use with
+// NewTestee.
+func LoadDescriptorSet(b []byte) (*protoregistry.Files, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("LoadDescriptorSet: error decoding descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("LoadDescriptorSet: %v", err)
+	}
+	return files, nil
+}
+
+// Handle answers the encoded conformance.ConformanceRequest in request, and returns the
+// encoded conformance.ConformanceResponse.  The returned slice is only valid until the
+// next call to Handle.
+//
+// Failures of the test itself are reported in the response; an error is only returned
+// if request is not a valid ConformanceRequest.
+func (t *Testee) Handle(request []byte) ([]byte, error) {
+	if err := DecodeRequest(codec.NewBuffer(request), &t.req); err != nil {
+		return nil, fmt.Errorf("Handle: %v", err)
+	}
+
+	t.output.Reset()
+	if err := t.respond(&t.req); err != nil {
+		return nil, fmt.Errorf("Handle: error encoding response: %v", err)
+	}
+	return t.output.Bytes(), nil
+}
+
+func (t *Testee) respond(req *Request) error {
+	if req.MessageType == failureSetType {
+		// No tests are expected to fail.
+		return t.ps.Bytes(responseProtobufPayload, nil)
+	}
+
+	desc, err := t.files.FindDescriptorByName(protoreflect.FullName(req.MessageType))
+	if err != nil {
+		return t.ps.String(responseSkipped, fmt.Sprintf("unknown message type %q", req.MessageType))
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return t.ps.String(responseSkipped, fmt.Sprintf("%q is not a message type", req.MessageType))
+	}
+	if req.PayloadFormat != WireFormatProtobuf {
+		return t.ps.String(responseSkipped, "only protobuf input is supported")
+	}
+
+	m := dynamicpb.NewMessage(md)
+	if err := protobridge.Populate(codec.NewBuffer(req.Payload), m); err != nil {
+		return t.ps.String(responseParseError, err.Error())
+	}
+	// Like proto.Unmarshal, reject messages that are missing required fields.
+	if err := proto.CheckInitialized(m); err != nil {
+		return t.ps.String(responseParseError, err.Error())
+	}
+
+	switch req.RequestedOutputFormat {
+	case WireFormatProtobuf:
+		// Embedded only writes to output once the message has been encoded, so
+		// a failure leaves nothing behind.
+		err := t.ps.Embedded(responseProtobufPayload, func(ps *molecule.ProtoStream) error {
+			return protobridge.Stream(ps, m)
+		})
+		if err != nil {
+			return t.ps.String(responseSerializeError, err.Error())
+		}
+		return nil
+	case WireFormatJSON:
+		b, err := protojson.MarshalOptions{Resolver: t.resolver()}.Marshal(m)
+		if err != nil {
+			return t.ps.String(responseSerializeError, err.Error())
+		}
+		return t.ps.String(responseJSONPayload, string(b))
+	default:
+		return t.ps.String(responseSkipped, fmt.Sprintf("unsupported output format %d", req.RequestedOutputFormat))
+	}
+}
+
+// resolver returns the resolver used for the google.protobuf.Any messages in JSON output.
+func (t *Testee) resolver() *protoregistry.Types {
+	if t.files == protoregistry.GlobalFiles {
+		return protoregistry.GlobalTypes
+	}
+	if t.types.NumMessages() == 0 {
+		t.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			registerMessages(t.types, fd.Messages())
+			return true
+		})
+	}
+	return t.types
+}
+
+func registerMessages(types *protoregistry.Types, messages protoreflect.MessageDescriptors) {
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		// Map entries and duplicates are not interesting, so errors are ignored.
+		_ = types.RegisterMessage(dynamicpb.NewMessageType(md))
+		registerMessages(types, md.Messages())
+	}
+}
+
+// Serve answers the requests read from r, writing the responses to w, until r is
+// exhausted.
+func (t *Testee) Serve(r io.Reader, w io.Writer) error {
+	var (
+		size    [4]byte
+		request []byte
+	)
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("Serve: error reading request size: %v", err)
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n > maxRequestSize {
+			return fmt.Errorf("Serve: request of %d bytes exceeds the maximum of %d", n, maxRequestSize)
+		}
+		if cap(request) < int(n) {
+			request = make([]byte, n)
+		}
+		request = request[:n]
+		if _, err := io.ReadFull(r, request); err != nil {
+			return fmt.Errorf("Serve: error reading request: %v", err)
+		}
+
+		response, err := t.Handle(request)
+		if err != nil {
+			return fmt.Errorf("Serve: %v", err)
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(len(response)))
+		if _, err := w.Write(size[:]); err != nil {
+			return fmt.Errorf("Serve: error writing response size: %v", err)
+		}
+		if _, err := w.Write(response); err != nil {
+			return fmt.Errorf("Serve: error writing response: %v", err)
+		}
+	}
+}
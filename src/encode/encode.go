@@ -0,0 +1,186 @@
+// Package encode provides functions that append protobuf encoded fields to a
+// []byte, for callers that want to build messages in memory without going
+// through the io.Writer used by molecule.ProtoStream.
+//
+// Like ProtoStream, the Append* functions do not write zero values, and the
+// *Packed functions do not write empty slices.
+package encode
+
+import (
+	"math"
+
+	"github.com/richardartoul/molecule/src/protowire"
+)
+
+// AppendDouble appends a field of proto type double to b.
+func AppendDouble(b []byte, fieldNumber int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// AppendFloat appends a field of proto type float to b.
+func AppendFloat(b []byte, fieldNumber int, v float32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, math.Float32bits(v))
+}
+
+// AppendInt32 appends a field of proto type int32 to b.
+func AppendInt32(b []byte, fieldNumber int, v int32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// AppendInt64 appends a field of proto type int64 to b.
+func AppendInt64(b []byte, fieldNumber int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// AppendUint32 appends a field of proto type uint32 to b.
+func AppendUint32(b []byte, fieldNumber int, v uint32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// AppendUint64 appends a field of proto type uint64 to b.
+func AppendUint64(b []byte, fieldNumber int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// AppendSint32 appends a field of proto type sint32 to b.
+func AppendSint32(b []byte, fieldNumber int, v int32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(int64(v)))
+}
+
+// AppendSint64 appends a field of proto type sint64 to b.
+func AppendSint64(b []byte, fieldNumber int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+}
+
+// AppendFixed32 appends a field of proto type fixed32 to b.
+func AppendFixed32(b []byte, fieldNumber int, v uint32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, v)
+}
+
+// AppendFixed64 appends a field of proto type fixed64 to b.
+func AppendFixed64(b []byte, fieldNumber int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+// AppendSfixed32 appends a field of proto type sfixed32 to b.
+func AppendSfixed32(b []byte, fieldNumber int, v int32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, uint32(v))
+}
+
+// AppendSfixed64 appends a field of proto type sfixed64 to b.
+func AppendSfixed64(b []byte, fieldNumber int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, uint64(v))
+}
+
+// AppendBool appends a field of proto type bool to b.
+func AppendBool(b []byte, fieldNumber int, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.VarintType)
+	return append(b, 1)
+}
+
+// AppendString appends a field of proto type string to b.
+func AppendString(b []byte, fieldNumber int, v string) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendBytes appends a field of proto type bytes to b.  It may also be used to
+// append an embedded message that has already been encoded.
+func AppendBytes(b []byte, fieldNumber int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendKey(b, fieldNumber, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendEmbeddedStart begins an embedded message by appending its key and
+// reserving a single byte for its length.  The fields of the embedded message
+// should then be appended to the returned slice, followed by a call to
+// AppendEmbeddedEnd with the returned position.
+//
+// Unlike the other functions in this package, an empty embedded message is still
+// written.
+func AppendEmbeddedStart(b []byte, fieldNumber int) ([]byte, int) {
+	b = appendKey(b, fieldNumber, protowire.BytesType)
+	return append(b, 0), len(b)
+}
+
+// AppendEmbeddedEnd completes the embedded message started at start, which must be
+// the position returned by the matching call to AppendEmbeddedStart, by back-patching
+// its length.  Embedded messages of 128 bytes or more need a longer length prefix than
+// the reserved byte, so they are shifted over to make room for it.
+func AppendEmbeddedEnd(b []byte, start int) []byte {
+	size := len(b) - start - 1
+	if sizeLen := protowire.SizeVarint(uint64(size)); sizeLen > 1 {
+		for i := 1; i < sizeLen; i++ {
+			b = append(b, 0)
+		}
+		copy(b[start+sizeLen:], b[start+1:start+1+size])
+	}
+	// There is always room for the length at start, so appending to the empty
+	// slice there overwrites the reserved bytes in place.
+	protowire.AppendVarint(b[start:start], uint64(size))
+	return b
+}
+
+// appendKey appends a protobuf key to b.
+func appendKey(b []byte, fieldNumber int, wireType protowire.Type) []byte {
+	return protowire.AppendVarint(b, uint64(fieldNumber)<<3+uint64(wireType))
+}
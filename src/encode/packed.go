@@ -0,0 +1,201 @@
+package encode
+
+import (
+	"math"
+
+	"github.com/richardartoul/molecule/src/protowire"
+)
+
+// AppendDoublePacked appends a packed repeated field of proto type double to b.
+func AppendDoublePacked(b []byte, fieldNumber int, v []float64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendPackedHeader(b, fieldNumber, len(v)*8)
+	for _, value := range v {
+		b = protowire.AppendFixed64(b, math.Float64bits(value))
+	}
+	return b
+}
+
+// AppendFloatPacked appends a packed repeated field of proto type float to b.
+func AppendFloatPacked(b []byte, fieldNumber int, v []float32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendPackedHeader(b, fieldNumber, len(v)*4)
+	for _, value := range v {
+		b = protowire.AppendFixed32(b, math.Float32bits(value))
+	}
+	return b
+}
+
+// AppendInt32Packed appends a packed repeated field of proto type int32 to b.
+func AppendInt32Packed(b []byte, fieldNumber int, v []int32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	size := 0
+	for _, value := range v {
+		size += protowire.SizeVarint(uint64(value))
+	}
+	b = appendPackedHeader(b, fieldNumber, size)
+	for _, value := range v {
+		b = protowire.AppendVarint(b, uint64(value))
+	}
+	return b
+}
+
+// AppendInt64Packed appends a packed repeated field of proto type int64 to b.
+func AppendInt64Packed(b []byte, fieldNumber int, v []int64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	size := 0
+	for _, value := range v {
+		size += protowire.SizeVarint(uint64(value))
+	}
+	b = appendPackedHeader(b, fieldNumber, size)
+	for _, value := range v {
+		b = protowire.AppendVarint(b, uint64(value))
+	}
+	return b
+}
+
+// AppendUint32Packed appends a packed repeated field of proto type uint32 to b.
+func AppendUint32Packed(b []byte, fieldNumber int, v []uint32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	size := 0
+	for _, value := range v {
+		size += protowire.SizeVarint(uint64(value))
+	}
+	b = appendPackedHeader(b, fieldNumber, size)
+	for _, value := range v {
+		b = protowire.AppendVarint(b, uint64(value))
+	}
+	return b
+}
+
+// AppendUint64Packed appends a packed repeated field of proto type uint64 to b.
+func AppendUint64Packed(b []byte, fieldNumber int, v []uint64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	size := 0
+	for _, value := range v {
+		size += protowire.SizeVarint(value)
+	}
+	b = appendPackedHeader(b, fieldNumber, size)
+	for _, value := range v {
+		b = protowire.AppendVarint(b, value)
+	}
+	return b
+}
+
+// AppendSint32Packed appends a packed repeated field of proto type sint32 to b.
+func AppendSint32Packed(b []byte, fieldNumber int, v []int32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	size := 0
+	for _, value := range v {
+		size += protowire.SizeVarint(protowire.EncodeZigZag(int64(value)))
+	}
+	b = appendPackedHeader(b, fieldNumber, size)
+	for _, value := range v {
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(value)))
+	}
+	return b
+}
+
+// AppendSint64Packed appends a packed repeated field of proto type sint64 to b.
+func AppendSint64Packed(b []byte, fieldNumber int, v []int64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	size := 0
+	for _, value := range v {
+		size += protowire.SizeVarint(protowire.EncodeZigZag(value))
+	}
+	b = appendPackedHeader(b, fieldNumber, size)
+	for _, value := range v {
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(value))
+	}
+	return b
+}
+
+// AppendFixed32Packed appends a packed repeated field of proto type fixed32 to b.
+func AppendFixed32Packed(b []byte, fieldNumber int, v []uint32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendPackedHeader(b, fieldNumber, len(v)*4)
+	for _, value := range v {
+		b = protowire.AppendFixed32(b, value)
+	}
+	return b
+}
+
+// AppendFixed64Packed appends a packed repeated field of proto type fixed64 to b.
+func AppendFixed64Packed(b []byte, fieldNumber int, v []uint64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendPackedHeader(b, fieldNumber, len(v)*8)
+	for _, value := range v {
+		b = protowire.AppendFixed64(b, value)
+	}
+	return b
+}
+
+// AppendSfixed32Packed appends a packed repeated field of proto type sfixed32 to b.
+func AppendSfixed32Packed(b []byte, fieldNumber int, v []int32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendPackedHeader(b, fieldNumber, len(v)*4)
+	for _, value := range v {
+		b = protowire.AppendFixed32(b, uint32(value))
+	}
+	return b
+}
+
+// AppendSfixed64Packed appends a packed repeated field of proto type sfixed64 to b.
+func AppendSfixed64Packed(b []byte, fieldNumber int, v []int64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendPackedHeader(b, fieldNumber, len(v)*8)
+	for _, value := range v {
+		b = protowire.AppendFixed64(b, uint64(value))
+	}
+	return b
+}
+
+// AppendBoolPacked appends a packed repeated field of proto type bool to b.
+func AppendBoolPacked(b []byte, fieldNumber int, v []bool) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	// Bools are always encoded as a single byte varint.
+	b = appendPackedHeader(b, fieldNumber, len(v))
+	for _, value := range v {
+		if value {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+// appendPackedHeader appends the key and length prefix of a packed field whose
+// values are size bytes long, and grows b so that the values fit without
+// reallocating.
+func appendPackedHeader(b []byte, fieldNumber int, size int) []byte {
+	b = appendKey(b, fieldNumber, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(size))
+	return append(b, make([]byte, size)...)[:len(b)]
+}
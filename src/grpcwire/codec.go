@@ -0,0 +1,112 @@
+package grpcwire
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+// A Marshaler is a message that can encode itself with a ProtoStream.
+type Marshaler interface {
+	MarshalMolecule(ps *molecule.ProtoStream) error
+}
+
+// An Unmarshaler is a message that can decode itself from a codec.Buffer, for example with
+// MessageEach or a generated visitor.
+//
+// The bytes in buffer may be reused once UnmarshalMolecule returns, so unsafe views over
+// them must not be retained.
+type Unmarshaler interface {
+	UnmarshalMolecule(buffer *codec.Buffer) error
+}
+
+// MarshalFunc is an adapter that allows an ordinary function to be used as a Marshaler.
+type MarshalFunc func(ps *molecule.ProtoStream) error
+
+// MarshalMolecule calls f(ps).
+func (f MarshalFunc) MarshalMolecule(ps *molecule.ProtoStream) error {
+	return f(ps)
+}
+
+// UnmarshalFunc is an adapter that allows an ordinary function to be used as an
+// Unmarshaler.
+type UnmarshalFunc func(buffer *codec.Buffer) error
+
+// UnmarshalMolecule calls f(buffer).
+func (f UnmarshalFunc) UnmarshalMolecule(buffer *codec.Buffer) error {
+	return f(buffer)
+}
+
+// Codec implements the grpc-go `encoding.Codec` interface for messages that implement
+// Marshaler and Unmarshaler, so that they can be sent and received without generated
+// structs.
+//
+// The zero value is ready to use and is named "molecule", so registering it with
+// `encoding.RegisterCodec` does not replace the default "proto" codec used by calls with
+// generated structs.  Select it for a single call with the
+// `grpc.CallContentSubtype("molecule")` call option, which uses the registered codec of
+// that name, or with `grpc.ForceCodec(grpcwire.Codec{})`, which does not need it to be
+// registered.  Either way the content-subtype of the call is "molecule", so the server
+// must register the Codec too, or use it with `grpc.ForceServerCodec`.  Set CodecName to
+// use a different name.
+type Codec struct {
+	// The CodecName is the name returned by Name.  It defaults to "molecule".
+	CodecName string
+}
+
+// A marshalState holds the OutputBuffer and ProtoStream used by a call to Marshal.
+type marshalState struct {
+	output *molecule.OutputBuffer
+	ps     *molecule.ProtoStream
+}
+
+// marshalStates pools the marshalStates, so that Marshal only allocates the bytes that it
+// returns.
+var marshalStates = sync.Pool{
+	New: func() interface{} {
+		output := molecule.NewOutputBuffer(nil)
+		return &marshalState{output: output, ps: molecule.NewProtoStream(output)}
+	},
+}
+
+// Marshal encodes v, which must implement Marshaler, into a new byte slice.
+func (c Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(Marshaler)
+	if !ok {
+		return nil, fmt.Errorf("Marshal: %T does not implement Marshaler", v)
+	}
+
+	state := marshalStates.Get().(*marshalState)
+	defer marshalStates.Put(state)
+	state.output.Reset()
+	state.ps.Reset(state.output)
+	// The previous call may have changed the options of the stream.
+	state.ps.EmitZeroValues = false
+	if err := m.MarshalMolecule(state.ps); err != nil {
+		return nil, fmt.Errorf("Marshal: %v", err)
+	}
+	// The output is reused by the next call, so the encoded message must be copied.
+	return append([]byte(nil), state.output.Bytes()...), nil
+}
+
+// Unmarshal decodes data into v, which must implement Unmarshaler.
+func (c Codec) Unmarshal(data []byte, v interface{}) error {
+	u, ok := v.(Unmarshaler)
+	if !ok {
+		return fmt.Errorf("Unmarshal: %T does not implement Unmarshaler", v)
+	}
+	if err := u.UnmarshalMolecule(codec.NewBuffer(data)); err != nil {
+		return fmt.Errorf("Unmarshal: %v", err)
+	}
+	return nil
+}
+
+// Name returns the name of the codec.
+func (c Codec) Name() string {
+	if c.CodecName == "" {
+		return "molecule"
+	}
+	return c.CodecName
+}
@@ -0,0 +1,185 @@
+// Package grpcwire implements the gRPC length-prefixed message framing on top of molecule,
+// for use in custom gRPC codecs and proxies.
+//
+// Every message in a gRPC stream is preceded by a 5 byte header: a 1 byte flag that is set
+// if the message is compressed, followed by the length of the message as a 4 byte
+// big-endian integer. A FrameReader splits a stream into frames and returns the payload of
+// each one as a codec.Buffer, and a FrameWriter writes frames, either from a byte slice or
+// by encoding the message directly with a ProtoStream.
+package grpcwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+const (
+	// HeaderSize is the size of the header that precedes every gRPC message.
+	HeaderSize = 5
+
+	// DefaultMaxFrameSize is the default limit on the size of the frames read by a
+	// FrameReader, which matches the default maximum receive message size of grpc-go.
+	DefaultMaxFrameSize = 4 * 1024 * 1024
+
+	// The compressedFlag is the value of the first byte of the header of a
+	// compressed message.
+	compressedFlag = 1
+)
+
+// The emptyHeader is written as a placeholder for the header of a frame, which is filled
+// in once the size of the frame is known.
+var emptyHeader [HeaderSize]byte
+
+// ErrFrameTooLarge is returned by FrameReader.Next for frames larger than MaxFrameSize.
+var ErrFrameTooLarge = errors.New("frame is larger than the maximum frame size")
+
+// A FrameReader reads gRPC length-prefixed messages from an io.Reader.
+//
+// FrameReader instances are *not* threadsafe.
+type FrameReader struct {
+	r      io.Reader
+	header [HeaderSize]byte
+
+	// The buf holds the payload of the current frame, and is reused for every
+	// frame.
+	buf []byte
+
+	// The buffer is the codec.Buffer over buf that is returned by Next.
+	buffer codec.Buffer
+
+	// The MaxFrameSize is the size of the largest frame that will be read.
+	// Larger frames cause Next to fail with ErrFrameTooLarge, without reading
+	// their payload.
+	MaxFrameSize int
+}
+
+// NewFrameReader creates a new FrameReader that reads from r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{
+		r:            r,
+		MaxFrameSize: DefaultMaxFrameSize,
+	}
+}
+
+// Reset resets the FrameReader to read from r, retaining its buffer for reuse.
+func (fr *FrameReader) Reset(r io.Reader) {
+	fr.r = r
+	fr.buffer.Reset(nil)
+}
+
+// Next reads the next frame and returns whether its payload is compressed, and a buffer
+// over the payload. The buffer, and any unsafe views over it, are only valid until the
+// next call to Next.
+//
+// Next returns io.EOF if the reader is at the end of the stream, and io.ErrUnexpectedEOF
+// if the stream ends part way through a frame.
+func (fr *FrameReader) Next() (compressed bool, buffer *codec.Buffer, err error) {
+	if _, err := io.ReadFull(fr.r, fr.header[:]); err != nil {
+		if err == io.EOF {
+			return false, nil, io.EOF
+		}
+		return false, nil, fmt.Errorf("Next: error reading header: %w", err)
+	}
+
+	switch fr.header[0] {
+	case 0:
+	case compressedFlag:
+		compressed = true
+	default:
+		return false, nil, fmt.Errorf("Next: invalid compressed flag: %d", fr.header[0])
+	}
+
+	size := binary.BigEndian.Uint32(fr.header[1:])
+	if uint64(size) > uint64(fr.MaxFrameSize) {
+		return false, nil, fmt.Errorf("Next: frame of size %d: %w", size, ErrFrameTooLarge)
+	}
+	if cap(fr.buf) < int(size) {
+		fr.buf = make([]byte, size)
+	}
+	fr.buf = fr.buf[:size]
+	if _, err := io.ReadFull(fr.r, fr.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, nil, fmt.Errorf("Next: error reading payload: %w", err)
+	}
+
+	fr.buffer.Reset(fr.buf)
+	return compressed, &fr.buffer, nil
+}
+
+// A FrameWriter writes gRPC length-prefixed messages to an io.Writer.  Each frame is
+// written with a single call to Write.
+//
+// FrameWriter instances are *not* threadsafe.
+type FrameWriter struct {
+	w io.Writer
+
+	// The output holds the frame being encoded by WriteMessage, and is reused
+	// for every frame.
+	output *molecule.OutputBuffer
+
+	// The ps is the ProtoStream that writes to output.
+	ps *molecule.ProtoStream
+}
+
+// NewFrameWriter creates a new FrameWriter that writes to w.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	output := molecule.NewOutputBuffer(nil)
+	return &FrameWriter{
+		w:      w,
+		output: output,
+		ps:     molecule.NewProtoStream(output),
+	}
+}
+
+// Reset resets the FrameWriter to write to w, retaining its buffer for reuse.
+func (fw *FrameWriter) Reset(w io.Writer) {
+	fw.w = w
+}
+
+// WriteFrame writes a frame holding payload, which must already be compressed if
+// compressed is set.
+func (fw *FrameWriter) WriteFrame(compressed bool, payload []byte) error {
+	if uint64(len(payload)) > math.MaxUint32 {
+		return fmt.Errorf("WriteFrame: payload of size %d is too large", len(payload))
+	}
+	fw.output.Reset()
+	fw.output.Write(emptyHeader[:])
+	fw.output.Write(payload)
+	return fw.flush(compressed, "WriteFrame")
+}
+
+// WriteMessage writes an uncompressed frame holding the message encoded by fn.  Nothing
+// is written if fn fails.
+func (fw *FrameWriter) WriteMessage(fn func(ps *molecule.ProtoStream) error) error {
+	fw.output.Reset()
+	fw.output.Write(emptyHeader[:])
+	if err := fn(fw.ps); err != nil {
+		return err
+	}
+	if uint64(fw.output.Len()-HeaderSize) > math.MaxUint32 {
+		return fmt.Errorf("WriteMessage: message of size %d is too large", fw.output.Len()-HeaderSize)
+	}
+	return fw.flush(false, "WriteMessage")
+}
+
+// flush fills in the header of the frame in output and writes it.
+func (fw *FrameWriter) flush(compressed bool, funcName string) error {
+	frame := fw.output.Bytes()
+	frame[0] = 0
+	if compressed {
+		frame[0] = compressedFlag
+	}
+	binary.BigEndian.PutUint32(frame[1:HeaderSize], uint32(len(frame)-HeaderSize))
+	if _, err := fw.w.Write(frame); err != nil {
+		return fmt.Errorf("%s: %w", funcName, err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Package infer guesses the schema of protobuf messages whose .proto files are not
+// available, from the encoded bytes of sample messages.
+//
+// The wire format only records one of a few wire types for each field, so the schema
+// can't be recovered exactly. An Inferrer scans each sample with molecule.Next, and for
+// every length-delimited field records whether its values parse as messages, as
+// printable strings, or as packed varints, recursing into the values that parse as
+// messages. Observations are merged across samples, so the more samples are added, the
+// better the guesses. Infer then picks the most likely type for every field, and notes
+// how confident it is and which alternatives remain plausible, and the resulting Message
+// can be written as a .proto file or converted into a descriptor.
+package infer
+
+import (
+	"fmt"
+	"math"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+const (
+	// DefaultMaxDepth is the default limit on the depth of nested messages that an
+	// Inferrer recurses into.
+	DefaultMaxDepth = 32
+
+	// The maxFieldNumber is the largest valid field number.
+	maxFieldNumber = 1<<29 - 1
+)
+
+// An Inferrer accumulates observations of sample messages of a single type.
+//
+// Inferrer instances are *not* threadsafe.
+type Inferrer struct {
+	root messageStats
+
+	// The MaxDepth is the depth of nested messages beyond which length-delimited
+	// fields are not parsed as messages.
+	MaxDepth int
+}
+
+// NewInferrer creates a new Inferrer with no observations.
+func NewInferrer() *Inferrer {
+	return &Inferrer{MaxDepth: DefaultMaxDepth}
+}
+
+// Add adds the observations from a single encoded sample message. An error is returned,
+// and nothing is recorded, if sample is not a valid message.
+func (inf *Inferrer) Add(sample []byte) error {
+	if !isMessage(sample) {
+		return fmt.Errorf("Add: sample is not a valid protobuf message")
+	}
+	if err := inf.root.observe(sample, 0, inf.MaxDepth); err != nil {
+		return fmt.Errorf("Add: %v", err)
+	}
+	return nil
+}
+
+// Samples returns the number of samples that have been added.
+func (inf *Inferrer) Samples() int {
+	return inf.root.count
+}
+
+// A messageStats holds the observations of every occurrence of a message.
+type messageStats struct {
+	// The count is the number of occurrences of the message.
+	count  int
+	fields map[int32]*fieldStats
+}
+
+// A fieldStats holds the observations of a field of a message.
+type fieldStats struct {
+	// The messages is the number of occurrences of the containing message that
+	// had the field, and repeated is set if any of them had it more than once.
+	messages int
+	repeated bool
+
+	// The number of values seen with each wire type.
+	varints         int
+	fixed32s        int
+	fixed64s        int
+	lengthDelimited int
+
+	// Observations of the values of varints.
+	maxVarint uint64
+
+	// The number of fixed width values that do not look like floating point
+	// numbers.
+	fixed32NotFloat  int
+	fixed64NotDouble int
+
+	// The number of length-delimited values that are empty, and the number of
+	// non-empty ones that parse as messages, that are printable strings, and
+	// that parse as packed varints.
+	empty         int
+	asMessage     int
+	asString      int
+	asPacked      int
+	maxPacked     uint64
+	nestedMessage *messageStats
+}
+
+// observe records the observations of the message in b, which must be a valid message.
+func (ms *messageStats) observe(b []byte, depth, maxDepth int) error {
+	ms.count++
+	if ms.fields == nil {
+		ms.fields = make(map[int32]*fieldStats)
+	}
+
+	var (
+		buffer      = codec.NewBuffer(b)
+		occurrences = make(map[int32]int)
+	)
+	for !buffer.EOF() {
+		// Next does not clear the fields of value that it does not set, so a new
+		// value is used for each field.
+		var value molecule.Value
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+		fs, ok := ms.fields[fieldNum]
+		if !ok {
+			fs = &fieldStats{}
+			ms.fields[fieldNum] = fs
+		}
+		occurrences[fieldNum]++
+
+		switch value.WireType {
+		case codec.WireVarint:
+			fs.varints++
+			if value.Number > fs.maxVarint {
+				fs.maxVarint = value.Number
+			}
+		case codec.WireFixed32:
+			fs.fixed32s++
+			if !looksLikeFloat(float64(math.Float32frombits(uint32(value.Number)))) {
+				fs.fixed32NotFloat++
+			}
+		case codec.WireFixed64:
+			fs.fixed64s++
+			if !looksLikeFloat(math.Float64frombits(value.Number)) {
+				fs.fixed64NotDouble++
+			}
+		case codec.WireBytes:
+			fs.lengthDelimited++
+			if err := fs.observeBytes(value.Bytes, depth, maxDepth); err != nil {
+				return err
+			}
+		}
+	}
+
+	for fieldNum, n := range occurrences {
+		fs := ms.fields[fieldNum]
+		fs.messages++
+		if n > 1 {
+			fs.repeated = true
+		}
+	}
+	return nil
+}
+
+// observeBytes records the observations of a length-delimited value.
+func (fs *fieldStats) observeBytes(b []byte, depth, maxDepth int) error {
+	if len(b) == 0 {
+		fs.empty++
+		return nil
+	}
+	if isString(b) {
+		fs.asString++
+	}
+	if max, ok := packedVarints(b); ok {
+		fs.asPacked++
+		if max > fs.maxPacked {
+			fs.maxPacked = max
+		}
+	}
+	if depth < maxDepth && isMessage(b) {
+		fs.asMessage++
+		if fs.nestedMessage == nil {
+			fs.nestedMessage = &messageStats{}
+		}
+		return fs.nestedMessage.observe(b, depth+1, maxDepth)
+	}
+	return nil
+}
+
+// isMessage returns whether b parses as a message with valid field numbers and no groups.
+func isMessage(b []byte) bool {
+	var (
+		buffer = codec.NewBuffer(b)
+		value  molecule.Value
+	)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil || fieldNum > maxFieldNumber {
+			return false
+		}
+	}
+	return true
+}
+
+// isString returns whether b is valid UTF-8 that consists of printable characters and
+// whitespace.
+func isString(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// packedVarints returns the largest value in b and whether b parses as packed varints.
+func packedVarints(b []byte) (uint64, bool) {
+	var (
+		buffer = codec.NewBuffer(b)
+		max    uint64
+	)
+	for !buffer.EOF() {
+		v, err := buffer.DecodeVarint()
+		if err != nil {
+			return 0, false
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return max, true
+}
+
+// looksLikeFloat returns whether f is in the range of values that floating point fields
+// typically hold, as opposed to integers that happen to be valid floating point numbers
+// when their bits are reinterpreted.
+func looksLikeFloat(f float64) bool {
+	if f == 0 {
+		return true
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+	a := math.Abs(f)
+	return a >= 1e-9 && a <= 1e15
+}
@@ -0,0 +1,118 @@
+package infer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/richardartoul/molecule/src/internal/protokind"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Proto returns a proto3 .proto file declaring m in the package pkg, with comments that
+// record the confidence and notes for every field.
+func (m *Message) Proto(pkg string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Inferred by molecule from %d sample messages. Names are placeholders and\n", m.Samples)
+	b.WriteString("// types are best guesses: see the comments on each field.\n")
+	b.WriteString("syntax = \"proto3\";\n\n")
+	if pkg != "" {
+		fmt.Fprintf(&b, "package %s;\n\n", pkg)
+	}
+	m.writeProto(&b, "")
+	return b.String()
+}
+
+func (m *Message) writeProto(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%smessage %s {\n", indent, m.Name)
+	for i, nested := range m.Nested {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		nested.writeProto(b, indent+"  ")
+	}
+	for i, f := range m.Fields {
+		if i > 0 || len(m.Nested) > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(b, "%s  // Seen in %d of %d samples, %s confidence.\n", indent, f.Present, m.Samples, f.Confidence)
+		for _, note := range f.Notes {
+			fmt.Fprintf(b, "%s  // %s.\n", indent, capitalize(note))
+		}
+
+		b.WriteString(indent + "  ")
+		if f.Repeated {
+			b.WriteString("repeated ")
+		}
+		if f.Message != nil {
+			b.WriteString(f.Message.Name)
+		} else {
+			b.WriteString(strings.ToLower(strings.TrimPrefix(f.Type.String(), "TYPE_")))
+		}
+		fmt.Fprintf(b, " %s = %d", f.Name, f.Number)
+		if f.Unpacked && protokind.IsPackable(protoreflect.Kind(f.Type)) {
+			b.WriteString(" [packed = false]")
+		}
+		b.WriteString(";\n")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// FileDescriptor returns a proto3 file descriptor with the given file name, declaring m in
+// the package pkg. It can be passed to protodesc.NewFile and used with dynamicpb to
+// decode the samples.
+func (m *Message) FileDescriptor(fileName, pkg string) *descriptorpb.FileDescriptorProto {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String(fileName),
+		Syntax: proto.String("proto3"),
+	}
+	scope := ""
+	if pkg != "" {
+		fd.Package = proto.String(pkg)
+		scope = "." + pkg
+	}
+	fd.MessageType = []*descriptorpb.DescriptorProto{m.descriptor(scope)}
+	return fd
+}
+
+// descriptor returns the descriptor of m, which is declared in the given fully qualified
+// scope.
+func (m *Message) descriptor(scope string) *descriptorpb.DescriptorProto {
+	var (
+		d = &descriptorpb.DescriptorProto{Name: proto.String(m.Name)}
+		// The fullName is the fully qualified name of m, such as .pkg.Root.
+		fullName = scope + "." + m.Name
+	)
+	for _, nested := range m.Nested {
+		d.NestedType = append(d.NestedType, nested.descriptor(fullName))
+	}
+	for _, f := range m.Fields {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(f.Name),
+			Number:   proto.Int32(f.Number),
+			Type:     f.Type.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(fmt.Sprintf("field%d", f.Number)),
+		}
+		if f.Repeated {
+			fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+		if f.Message != nil {
+			fd.TypeName = proto.String(fullName + "." + f.Message.Name)
+		}
+		if f.Unpacked && protokind.IsPackable(protoreflect.Kind(f.Type)) {
+			fd.Options = &descriptorpb.FieldOptions{Packed: proto.Bool(false)}
+		}
+		d.Field = append(d.Field, fd)
+	}
+	return d
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
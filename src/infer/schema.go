@@ -0,0 +1,236 @@
+package infer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Confidence is how confident an Inferrer is in the type that it picked for a field.
+type Confidence int
+
+const (
+	// LowConfidence means that the type is little more than a guess.
+	LowConfidence Confidence = iota
+	// MediumConfidence means that other types are plausible, but less likely.
+	MediumConfidence
+	// HighConfidence means that the observations are only consistent with the type,
+	// or with types that decode the same values.
+	HighConfidence
+)
+
+// String returns "low", "medium" or "high".
+func (c Confidence) String() string {
+	switch c {
+	case LowConfidence:
+		return "low"
+	case MediumConfidence:
+		return "medium"
+	case HighConfidence:
+		return "high"
+	default:
+		return fmt.Sprintf("Confidence(%d)", int(c))
+	}
+}
+
+// A Message is the inferred schema of a message.
+type Message struct {
+	// The Name is the name of the message.  Nested messages are named after the
+	// field that holds them, such as Field3.
+	Name string
+	// The Samples is the number of occurrences of the message that were observed.
+	Samples int
+	// The Fields are the fields of the message, sorted by number.
+	Fields []*Field
+	// The Nested are the messages declared within this message, which are the
+	// types of its message fields.
+	Nested []*Message
+}
+
+// A Field is the inferred schema of a field of a message.
+type Field struct {
+	// The Name is the name of the field, such as field_3.
+	Name   string
+	Number int32
+	// The Type is the inferred type of the field.  For message fields, Message is
+	// the inferred schema of the message.
+	Type     descriptorpb.FieldDescriptorProto_Type
+	Message  *Message
+	Repeated bool
+	// The Unpacked flag is set for repeated scalar fields whose values were
+	// encoded one per key, rather than packed.
+	Unpacked bool
+
+	// The Present is the number of occurrences of the containing message that had
+	// the field.
+	Present    int
+	Confidence Confidence
+	// The Notes describe the observations that the type is based on, and any
+	// other plausible types.
+	Notes []string
+}
+
+// Infer returns the inferred schema of the samples that have been added, as a message
+// with the given name.
+func (inf *Inferrer) Infer(name string) *Message {
+	return inf.root.infer(name)
+}
+
+func (ms *messageStats) infer(name string) *Message {
+	m := &Message{Name: name, Samples: ms.count}
+	for fieldNum, fs := range ms.fields {
+		f := fs.infer(fieldNum)
+		f.Present = fs.messages
+		m.Fields = append(m.Fields, f)
+		if f.Message != nil {
+			m.Nested = append(m.Nested, f.Message)
+		}
+	}
+	sort.Slice(m.Fields, func(i, j int) bool { return m.Fields[i].Number < m.Fields[j].Number })
+	sort.Slice(m.Nested, func(i, j int) bool { return m.Nested[i].Name < m.Nested[j].Name })
+	return m
+}
+
+func (fs *fieldStats) infer(fieldNum int32) *Field {
+	f := &Field{
+		Name:     fmt.Sprintf("field_%d", fieldNum),
+		Number:   fieldNum,
+		Repeated: fs.repeated,
+	}
+
+	var wireTypes []string
+	for _, wt := range []struct {
+		name  string
+		count int
+	}{
+		{"varint", fs.varints},
+		{"fixed32", fs.fixed32s},
+		{"fixed64", fs.fixed64s},
+		{"length-delimited", fs.lengthDelimited},
+	} {
+		if wt.count > 0 {
+			wireTypes = append(wireTypes, fmt.Sprintf("%s (%d)", wt.name, wt.count))
+		}
+	}
+	// Repeated varint fields may legitimately be seen both packed and unpacked.
+	mixedPacking := fs.varints > 0 && fs.lengthDelimited > 0 && fs.fixed32s == 0 && fs.fixed64s == 0 &&
+		fs.asPacked+fs.empty == fs.lengthDelimited
+	conflicting := len(wireTypes) > 1 && !mixedPacking
+	if conflicting {
+		f.Notes = append(f.Notes, "seen with conflicting wire types: "+strings.Join(wireTypes, ", "))
+	}
+
+	switch {
+	case mixedPacking:
+		max := fs.maxVarint
+		if fs.maxPacked > max {
+			max = fs.maxPacked
+		}
+		inferVarint(f, max)
+		f.Repeated = true
+		f.Notes = append(f.Notes, "seen both packed and unpacked")
+	case fs.lengthDelimited >= fs.varints && fs.lengthDelimited >= fs.fixed32s && fs.lengthDelimited >= fs.fixed64s:
+		fs.inferLengthDelimited(f, fieldNum)
+	case fs.varints >= fs.fixed32s && fs.varints >= fs.fixed64s:
+		inferVarint(f, fs.maxVarint)
+		f.Unpacked = f.Repeated
+	case fs.fixed32s >= fs.fixed64s:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_FIXED32
+		f.Confidence = MediumConfidence
+		if fs.fixed32NotFloat == 0 {
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_FLOAT
+			f.Notes = append(f.Notes, "every value is a plausible float; could be fixed32 or sfixed32")
+		} else {
+			f.Notes = append(f.Notes, "some values are not plausible floats; could be sfixed32")
+		}
+		f.Unpacked = f.Repeated
+	default:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_FIXED64
+		f.Confidence = MediumConfidence
+		if fs.fixed64NotDouble == 0 {
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+			f.Notes = append(f.Notes, "every value is a plausible double; could be fixed64 or sfixed64")
+		} else {
+			f.Notes = append(f.Notes, "some values are not plausible doubles; could be sfixed64")
+		}
+		f.Unpacked = f.Repeated
+	}
+
+	if conflicting && f.Confidence > LowConfidence {
+		f.Confidence--
+	}
+	return f
+}
+
+// inferVarint picks the type of a varint field whose largest value is max.
+func inferVarint(f *Field, max uint64) {
+	switch {
+	case max <= 1:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		f.Confidence = MediumConfidence
+		f.Notes = append(f.Notes, "only 0 and 1 seen; could be an integer or enum")
+	case max > math.MaxInt64:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		f.Confidence = MediumConfidence
+		f.Notes = append(f.Notes, "negative values seen as int64; could be uint64")
+	default:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		f.Confidence = MediumConfidence
+		f.Notes = append(f.Notes, "could be any integer type, an enum, or zigzag encoded (sint64)")
+	}
+}
+
+// inferLengthDelimited picks the type of a length-delimited field.
+func (fs *fieldStats) inferLengthDelimited(f *Field, fieldNum int32) {
+	nonEmpty := fs.lengthDelimited - fs.empty
+	confidence := HighConfidence
+	if nonEmpty < 3 {
+		confidence = MediumConfidence
+	}
+
+	switch {
+	case nonEmpty == 0:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		f.Confidence = LowConfidence
+		f.Notes = append(f.Notes, "only empty values seen; could be a string or message")
+
+	case fs.asMessage == nonEmpty && fs.asString < nonEmpty:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		f.Message = fs.nestedMessage.infer(fmt.Sprintf("Field%d", fieldNum))
+		f.Confidence = confidence
+		if fs.asString > 0 {
+			f.Notes = append(f.Notes, fmt.Sprintf("every value parses as a message, %d of %d are also printable strings", fs.asString, nonEmpty))
+		} else {
+			f.Notes = append(f.Notes, "every value parses as a message")
+		}
+
+	case fs.asString == nonEmpty:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		f.Confidence = confidence
+		if fs.asMessage == nonEmpty {
+			f.Confidence = MediumConfidence
+			f.Notes = append(f.Notes, "every value is a printable string, but also parses as a message")
+		} else {
+			f.Notes = append(f.Notes, "every value is a printable string")
+		}
+
+	case fs.asPacked == nonEmpty:
+		inferVarint(f, fs.maxPacked)
+		f.Repeated = true
+		f.Confidence = LowConfidence
+		f.Notes = append(f.Notes, "every value parses as packed varints; could be bytes or another packed type")
+
+	default:
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		f.Confidence = confidence
+		if fs.asMessage > 0 || fs.asString > 0 {
+			f.Confidence = MediumConfidence
+			f.Notes = append(f.Notes, fmt.Sprintf("%d of %d values parse as messages and %d are printable strings", fs.asMessage, nonEmpty, fs.asString))
+		} else {
+			f.Notes = append(f.Notes, "values are neither messages nor printable strings")
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Package protokind holds the rules that map the kinds of protobuf fields to their
+// encoding on the wire, so that the packages that decode fields using their descriptors
+// agree on them.
+//
+// The numbers of protoreflect.Kind, codec.FieldType and the field types of
+// descriptorpb are the same, so all of them can be converted to a protoreflect.Kind.
+package protokind
+
+import (
+	"github.com/richardartoul/molecule/src/codec"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// WireType returns the wire type used to encode a single value of kind.
+func WireType(kind protoreflect.Kind) codec.WireType {
+	switch kind {
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		return codec.WireFixed32
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		return codec.WireFixed64
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind:
+		return codec.WireBytes
+	case protoreflect.GroupKind:
+		return codec.WireStartGroup
+	default:
+		return codec.WireVarint
+	}
+}
+
+// IsPackable returns whether repeated fields of kind may use the packed encoding.
+func IsPackable(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	default:
+		return true
+	}
+}
+
+// ValidWireType returns whether a field described by fd may be encoded with wt.  Like
+// proto.Unmarshal, repeated scalar fields are accepted both packed and expanded, whatever
+// their descriptor says.
+func ValidWireType(fd protoreflect.FieldDescriptor, wt codec.WireType) bool {
+	if fd.IsList() && wt == codec.WireBytes && IsPackable(fd.Kind()) {
+		return true
+	}
+	return wt == WireType(fd.Kind())
+}
+
+// IsUnknownEnum returns whether v, a value of the field described by fd, is a value of a
+// closed enum that is not declared by the enum.  Closed enums only hold the values that
+// they declare, and parsers keep the other values in the unknown fields.  Whether an
+// enum is closed depends on the file that declares it, not on the file that uses it.
+func IsUnknownEnum(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+	if fd.Kind() != protoreflect.EnumKind {
+		return false
+	}
+	ed := fd.Enum()
+	return ed.IsClosed() && ed.Values().ByNumber(v.Enum()) == nil
+}
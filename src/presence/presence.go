@@ -0,0 +1,372 @@
+// Package presence tracks which fields of a message were present on the wire, using the
+// descriptor of the message, so that proto2 messages can be decoded with molecule.
+//
+// A Tracker records the fields that a MessageEach pass visits.  Afterwards it reports
+// which fields were set, supplies the declared [default = ...] values of proto2 fields
+// (and the zero values of proto3 fields) for the fields that were not, and checks that
+// every required field was set.  Default values are returned as molecule.Values, so
+// that they can be decoded with the same As* methods, and the same MessageEachFn, as the
+// values that were present.
+package presence
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/internal/protokind"
+	"github.com/richardartoul/molecule/src/protowire"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// The maxBitsetFieldNumber is the largest field number that is tracked in
+	// the bitset returned by Present.  Larger field numbers are tracked
+	// separately, so that a single large field number does not blow up the size
+	// of the bitset.
+	maxBitsetFieldNumber = 4096
+)
+
+// A Bitset is a set of field numbers.  Field number n is in the set if bit n%64 of
+// word n/64 is set.
+type Bitset []uint64
+
+// Has returns whether fieldNum is in the set.
+func (b Bitset) Has(fieldNum int32) bool {
+	i := int(fieldNum / 64)
+	return fieldNum >= 0 && i < len(b) && b[i]&(1<<uint(fieldNum%64)) != 0
+}
+
+func (b Bitset) set(i int32) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+func (b Bitset) unset(i int32) {
+	b[i/64] &^= 1 << uint(i%64)
+}
+
+func (b Bitset) clear() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// A field is a field declared by the descriptor of a Tracker.
+type field struct {
+	number int32
+	desc   protoreflect.FieldDescriptor
+	// The def is the default value of the field, encoded like a value read from
+	// the wire.
+	def molecule.Value
+	// The oneof is the index in Tracker.oneofs of the oneof that contains the
+	// field, or -1.
+	oneof int
+}
+
+// A Tracker records which fields of a message, described by a protoreflect.MessageDescriptor,
+// were present when the message was decoded.  It only tracks the fields declared by the
+// descriptor; other fields are ignored.
+//
+// A Tracker does not allocate once it has been created, and can be reused for many
+// messages of the same type by calling Reset.  Only the top-level fields of a message are
+// tracked: use a separate Tracker for each embedded message.
+type Tracker struct {
+	// The fields are the declared fields, sorted by number.
+	fields []field
+	// The byNumber maps the field numbers up to maxBitsetFieldNumber to the
+	// index of the field with that number in fields plus one, or to zero.
+	byNumber []int32
+	// The oneofs holds the indexes in fields of the members of each oneof.
+	oneofs [][]int
+	// The required holds the indexes in fields of the required fields.
+	required []int
+
+	// The present holds the field numbers up to maxBitsetFieldNumber that were
+	// present, and the presentLarge the indexes in fields of the larger ones.
+	present      Bitset
+	presentLarge Bitset
+}
+
+// NewTracker creates a Tracker for messages described by md.
+func NewTracker(md protoreflect.MessageDescriptor) *Tracker {
+	var (
+		t        = &Tracker{}
+		fields   = md.Fields()
+		maxDense int32
+		oneofs   = map[protoreflect.FullName]int{}
+	)
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		t.fields = append(t.fields, field{number: int32(fd.Number()), desc: fd, def: defaultValue(fd), oneof: -1})
+	}
+	sort.Slice(t.fields, func(i, j int) bool { return t.fields[i].number < t.fields[j].number })
+
+	for i := range t.fields {
+		f := &t.fields[i]
+		if f.number <= maxBitsetFieldNumber {
+			maxDense = f.number
+		}
+		if od := f.desc.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			j, ok := oneofs[od.FullName()]
+			if !ok {
+				j = len(t.oneofs)
+				oneofs[od.FullName()] = j
+				t.oneofs = append(t.oneofs, nil)
+			}
+			f.oneof = j
+			t.oneofs[j] = append(t.oneofs[j], i)
+		}
+		if f.desc.Cardinality() == protoreflect.Required {
+			t.required = append(t.required, i)
+		}
+	}
+
+	t.byNumber = make([]int32, maxDense+1)
+	for i, f := range t.fields {
+		if f.number <= maxBitsetFieldNumber {
+			t.byNumber[f.number] = int32(i) + 1
+		}
+	}
+	t.present = make(Bitset, maxDense/64+1)
+	t.presentLarge = make(Bitset, len(t.fields)/64+1)
+	return t
+}
+
+// defaultValue returns the default value of the field described by fd.
+func defaultValue(fd protoreflect.FieldDescriptor) molecule.Value {
+	if fd.Cardinality() == protoreflect.Repeated {
+		return molecule.Value{}
+	}
+	v := fd.Default()
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var n uint64
+		if v.Bool() {
+			n = 1
+		}
+		return molecule.Value{WireType: codec.WireVarint, Number: n}
+	case protoreflect.EnumKind:
+		return molecule.Value{WireType: codec.WireVarint, Number: uint64(int64(v.Enum()))}
+	case protoreflect.Int32Kind, protoreflect.Int64Kind:
+		return molecule.Value{WireType: codec.WireVarint, Number: uint64(v.Int())}
+	case protoreflect.Sint32Kind:
+		return molecule.Value{WireType: codec.WireVarint, Number: protowire.EncodeZigZag(int64(int32(v.Int())))}
+	case protoreflect.Sint64Kind:
+		return molecule.Value{WireType: codec.WireVarint, Number: protowire.EncodeZigZag(v.Int())}
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind:
+		return molecule.Value{WireType: codec.WireVarint, Number: v.Uint()}
+	case protoreflect.Sfixed32Kind:
+		return molecule.Value{WireType: codec.WireFixed32, Number: uint64(uint32(v.Int()))}
+	case protoreflect.Fixed32Kind:
+		return molecule.Value{WireType: codec.WireFixed32, Number: v.Uint()}
+	case protoreflect.FloatKind:
+		return molecule.Value{WireType: codec.WireFixed32, Number: uint64(math.Float32bits(float32(v.Float())))}
+	case protoreflect.Sfixed64Kind:
+		return molecule.Value{WireType: codec.WireFixed64, Number: uint64(v.Int())}
+	case protoreflect.Fixed64Kind:
+		return molecule.Value{WireType: codec.WireFixed64, Number: v.Uint()}
+	case protoreflect.DoubleKind:
+		return molecule.Value{WireType: codec.WireFixed64, Number: math.Float64bits(v.Float())}
+	case protoreflect.StringKind:
+		return molecule.Value{WireType: codec.WireBytes, Bytes: []byte(v.String())}
+	case protoreflect.BytesKind:
+		return molecule.Value{WireType: codec.WireBytes, Bytes: v.Bytes()}
+	default:
+		// An empty embedded message.  Groups are not supported by molecule.
+		return molecule.Value{WireType: codec.WireBytes}
+	}
+}
+
+// Reset forgets the fields that were recorded, so that the Tracker can be used for
+// another message.
+func (t *Tracker) Reset() {
+	t.present.clear()
+	t.presentLarge.clear()
+}
+
+// lookup returns the index in t.fields of the field with number fieldNum, or -1.
+func (t *Tracker) lookup(fieldNum int32) int {
+	if fieldNum >= 0 && int(fieldNum) < len(t.byNumber) {
+		return int(t.byNumber[fieldNum]) - 1
+	}
+	i := sort.Search(len(t.fields), func(i int) bool { return t.fields[i].number >= fieldNum })
+	if i < len(t.fields) && t.fields[i].number == fieldNum {
+		return i
+	}
+	return -1
+}
+
+func (t *Tracker) mark(i int, present bool) {
+	var (
+		bits = t.present
+		bit  = t.fields[i].number
+	)
+	if bit > maxBitsetFieldNumber {
+		bits, bit = t.presentLarge, int32(i)
+	}
+	if present {
+		bits.set(bit)
+	} else {
+		bits.unset(bit)
+	}
+}
+
+func (t *Tracker) has(i int) bool {
+	if f := t.fields[i]; f.number <= maxBitsetFieldNumber {
+		return t.present.Has(f.number)
+	}
+	return t.presentLarge.Has(int32(i))
+}
+
+// Visit records that the field fieldNum was present.  Like proto.Unmarshal, it clears
+// the other members of a oneof when one of them is visited.  Visit does not check the
+// value of the field, so unlike MessageEach it records unknown values of closed enums.
+func (t *Tracker) Visit(fieldNum int32) {
+	i := t.lookup(fieldNum)
+	if i < 0 {
+		return
+	}
+	if oneof := t.fields[i].oneof; oneof >= 0 {
+		for _, j := range t.oneofs[oneof] {
+			t.mark(j, false)
+		}
+	}
+	t.mark(i, true)
+}
+
+// MessageEach resets the Tracker and iterates over each top-level field in the message
+// stored in buffer, recording each one and then calling fn on it, like the MessageEach
+// function.  fn may be nil if only the presence of the fields is needed.  If fn stops
+// the iteration early, the fields that follow are not recorded.
+//
+// Fields that are decoded as unknown fields are not recorded, so they are neither
+// reported as present nor count towards CheckRequired: fields whose wire type does not
+// match their descriptor, like proto.Unmarshal, and values of closed enums that the enum
+// does not declare, like Populate.  fn is still called on them.
+func (t *Tracker) MessageEach(buffer *codec.Buffer, fn molecule.MessageEachFn) error {
+	t.Reset()
+	var value molecule.Value
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+		if t.records(fieldNum, value) {
+			t.Visit(fieldNum)
+		}
+		if fn == nil {
+			continue
+		}
+		shouldContinue, err := fn(fieldNum, value)
+		if err != nil || !shouldContinue {
+			return err
+		}
+	}
+	return nil
+}
+
+// records returns whether MessageEach records value, read from the field fieldNum.  Like
+// proto.Unmarshal, fields whose wire type does not match their descriptor are unknown
+// fields, and like Populate, so are the values of closed enums that the enum does not
+// declare.  Fields that are not declared by the descriptor of the Tracker are never
+// recorded, so they are not checked.
+func (t *Tracker) records(fieldNum int32, value molecule.Value) bool {
+	i := t.lookup(fieldNum)
+	if i < 0 {
+		return true
+	}
+	fd := t.fields[i].desc
+	if !protokind.ValidWireType(fd, value.WireType) {
+		return false
+	}
+	if fd.Kind() != protoreflect.EnumKind {
+		return true
+	}
+	if value.WireType != codec.WireBytes {
+		return !protokind.IsUnknownEnum(fd, enumValue(value.Number))
+	}
+
+	// A packed enum is present if any of its elements is declared by the enum.
+	var packed codec.Buffer
+	packed.Reset(value.Bytes)
+	for !packed.EOF() {
+		v, err := packed.DecodeVarint()
+		if err != nil {
+			return false
+		}
+		if !protokind.IsUnknownEnum(fd, enumValue(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// enumValue returns the enum value encoded as the varint v.
+func enumValue(v uint64) protoreflect.Value {
+	return protoreflect.ValueOfEnum(protoreflect.EnumNumber(int32(v)))
+}
+
+// Has returns whether the field fieldNum was present.  It always returns false for
+// fields that are not declared by the descriptor of the Tracker.
+func (t *Tracker) Has(fieldNum int32) bool {
+	i := t.lookup(fieldNum)
+	return i >= 0 && t.has(i)
+}
+
+// Present returns the set of fields that were present, keyed by field number.  Field
+// numbers larger than 4096 are not included in the set; use Has to look them up.  The
+// returned Bitset is only valid until the Tracker is Reset.
+func (t *Tracker) Present() Bitset {
+	return t.present
+}
+
+// Default returns the default value of the field fieldNum: the declared default value of
+// a proto2 field, or the zero value of its type.  The default value of an embedded
+// message is an empty message.  It returns false if fieldNum is not a singular field
+// declared by the descriptor of the Tracker, since repeated fields have no default.
+//
+// Strings and bytes are returned as views over memory owned by the Tracker, which must
+// not be modified.
+func (t *Tracker) Default(fieldNum int32) (molecule.Value, bool) {
+	i := t.lookup(fieldNum)
+	if i < 0 || t.fields[i].desc.Cardinality() == protoreflect.Repeated {
+		return molecule.Value{}, false
+	}
+	return t.fields[i].def, true
+}
+
+// DefaultEach calls fn with the default value of each singular field that was not
+// present, in field number order, so that the function passed to MessageEach can be used
+// to fill in the fields that are missing from a message.  Embedded messages, and the
+// members of oneofs, are not visited, since they have no value unless they are present.
+func (t *Tracker) DefaultEach(fn molecule.MessageEachFn) error {
+	for i, f := range t.fields {
+		if t.has(i) || f.oneof >= 0 || f.desc.Cardinality() == protoreflect.Repeated || f.desc.Message() != nil {
+			continue
+		}
+		shouldContinue, err := fn(f.number, f.def)
+		if err != nil || !shouldContinue {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckRequired returns an error that names the required fields that were not present,
+// if any.  Like the Tracker, it does not check the fields of embedded messages.
+func (t *Tracker) CheckRequired() error {
+	var missing []string
+	for _, i := range t.required {
+		if !t.has(i) {
+			missing = append(missing, string(t.fields[i].desc.Name()))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("CheckRequired: missing required fields: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
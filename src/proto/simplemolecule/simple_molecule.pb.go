@@ -0,0 +1,869 @@
+// Code generated by protoc-gen-go-molecule. DO NOT EDIT.
+// source: simple.proto
+
+package simplemolecule
+
+import (
+	molecule "github.com/richardartoul/molecule"
+	codec "github.com/richardartoul/molecule/src/codec"
+)
+
+// Field numbers for simple.Simple.
+const (
+	Simple_Double_FieldNumber              = 1
+	Simple_Float_FieldNumber               = 2
+	Simple_Int32_FieldNumber               = 3
+	Simple_Int64_FieldNumber               = 4
+	Simple_Uint32_FieldNumber              = 5
+	Simple_Uint64_FieldNumber              = 6
+	Simple_Sint32_FieldNumber              = 7
+	Simple_Sint64_FieldNumber              = 8
+	Simple_Fixed32_FieldNumber             = 9
+	Simple_Fixed64_FieldNumber             = 10
+	Simple_Sfixed32_FieldNumber            = 11
+	Simple_Sfixed64_FieldNumber            = 12
+	Simple_Bool_FieldNumber                = 13
+	Simple_String__FieldNumber             = 14
+	Simple_Bytes_FieldNumber               = 15
+	Simple_RepeatedInt64Packed_FieldNumber = 16
+)
+
+// SimpleVisitor contains the callbacks invoked by DecodeSimple for each field
+// of a simple.Simple message. Nil callbacks are skipped. Returning false from
+// a callback stops decoding.
+type SimpleVisitor struct {
+	// Double is called with the value of the double field.
+	Double func(v float64) (bool, error)
+	// Float is called with the value of the float field.
+	Float func(v float32) (bool, error)
+	// Int32 is called with the value of the int32 field.
+	Int32 func(v int32) (bool, error)
+	// Int64 is called with the value of the int64 field.
+	Int64 func(v int64) (bool, error)
+	// Uint32 is called with the value of the uint32 field.
+	Uint32 func(v uint32) (bool, error)
+	// Uint64 is called with the value of the uint64 field.
+	Uint64 func(v uint64) (bool, error)
+	// Sint32 is called with the value of the sint32 field.
+	Sint32 func(v int32) (bool, error)
+	// Sint64 is called with the value of the sint64 field.
+	Sint64 func(v int64) (bool, error)
+	// Fixed32 is called with the value of the fixed32 field.
+	Fixed32 func(v uint32) (bool, error)
+	// Fixed64 is called with the value of the fixed64 field.
+	Fixed64 func(v uint64) (bool, error)
+	// Sfixed32 is called with the value of the sfixed32 field.
+	Sfixed32 func(v int32) (bool, error)
+	// Sfixed64 is called with the value of the sfixed64 field.
+	Sfixed64 func(v int64) (bool, error)
+	// Bool is called with the value of the bool field.
+	Bool func(v bool) (bool, error)
+	// String_ is called with the value of the string field, which is an
+	// unsafe view over the decoded buffer.
+	String_ func(v string) (bool, error)
+	// Bytes is called with the value of the bytes field, which is an
+	// unsafe view over the decoded buffer.
+	Bytes func(v []byte) (bool, error)
+	// RepeatedInt64Packed is called with the value of the repeated_int64_packed field.
+	// It is called once for each element.
+	RepeatedInt64Packed func(v int64) (bool, error)
+	// Unknown is called for any field that is not part of simple.Simple,
+	// or that is encoded with an unexpected wire type.
+	Unknown func(fieldNum int32, value molecule.Value) (bool, error)
+}
+
+// DecodeSimple decodes the simple.Simple message stored in buffer, calling the
+// callbacks in v for each field. Repeated scalar fields are accepted in both packed and
+// expanded form.
+func DecodeSimple(buffer *codec.Buffer, v *SimpleVisitor) error {
+	var (
+		value  molecule.Value
+		packed codec.Buffer
+	)
+	// The packed fields are views over the bytes in buffer, which are not poisoned.
+	packed.PoisonOnReset(false)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+
+		switch uint64(fieldNum)<<3 | uint64(value.WireType) {
+		case Simple_Double_FieldNumber<<3 | uint64(codec.WireFixed64):
+			if v.Double == nil {
+				continue
+			}
+			x, err := value.AsDouble()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Double(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Float_FieldNumber<<3 | uint64(codec.WireFixed32):
+			if v.Float == nil {
+				continue
+			}
+			x, err := value.AsFloat()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Float(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Int32_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Int32 == nil {
+				continue
+			}
+			x, err := value.AsInt32()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Int32(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Int64_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Int64 == nil {
+				continue
+			}
+			x, err := value.AsInt64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Int64(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Uint32_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Uint32 == nil {
+				continue
+			}
+			x, err := value.AsUint32()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Uint32(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Uint64_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Uint64 == nil {
+				continue
+			}
+			x, err := value.AsUint64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Uint64(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Sint32_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Sint32 == nil {
+				continue
+			}
+			x, err := value.AsSint32()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Sint32(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Sint64_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Sint64 == nil {
+				continue
+			}
+			x, err := value.AsSint64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Sint64(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Fixed32_FieldNumber<<3 | uint64(codec.WireFixed32):
+			if v.Fixed32 == nil {
+				continue
+			}
+			x, err := value.AsFixed32()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Fixed32(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Fixed64_FieldNumber<<3 | uint64(codec.WireFixed64):
+			if v.Fixed64 == nil {
+				continue
+			}
+			x, err := value.AsFixed64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Fixed64(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Sfixed32_FieldNumber<<3 | uint64(codec.WireFixed32):
+			if v.Sfixed32 == nil {
+				continue
+			}
+			x, err := value.AsSFixed32()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Sfixed32(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Sfixed64_FieldNumber<<3 | uint64(codec.WireFixed64):
+			if v.Sfixed64 == nil {
+				continue
+			}
+			x, err := value.AsSFixed64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Sfixed64(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Bool_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Bool == nil {
+				continue
+			}
+			x, err := value.AsBool()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Bool(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_String__FieldNumber<<3 | uint64(codec.WireBytes):
+			if v.String_ == nil {
+				continue
+			}
+			x, err := value.AsStringUnsafe()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.String_(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_Bytes_FieldNumber<<3 | uint64(codec.WireBytes):
+			if v.Bytes == nil {
+				continue
+			}
+			x, err := value.AsBytesUnsafe()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Bytes(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_RepeatedInt64Packed_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.RepeatedInt64Packed == nil {
+				continue
+			}
+			x, err := value.AsInt64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.RepeatedInt64Packed(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Simple_RepeatedInt64Packed_FieldNumber<<3 | uint64(codec.WireBytes):
+			if v.RepeatedInt64Packed == nil {
+				continue
+			}
+			packed.Reset(value.Bytes)
+			for !packed.EOF() {
+				value.Number, err = packed.DecodeVarint()
+				if err != nil {
+					return err
+				}
+				x, err := value.AsInt64()
+				if err != nil {
+					return err
+				}
+				if shouldContinue, err := v.RepeatedInt64Packed(x); err != nil || !shouldContinue {
+					return err
+				}
+			}
+		default:
+			if v.Unknown == nil {
+				continue
+			}
+			if shouldContinue, err := v.Unknown(fieldNum, value); err != nil || !shouldContinue {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SimpleEncoder writes the fields of a simple.Simple message to a ProtoStream.
+type SimpleEncoder struct {
+	ps *molecule.ProtoStream
+}
+
+// NewSimpleEncoder returns a SimpleEncoder that writes to ps.
+func NewSimpleEncoder(ps *molecule.ProtoStream) SimpleEncoder {
+	return SimpleEncoder{ps: ps}
+}
+
+// Double writes the double field.
+func (e SimpleEncoder) Double(v float64) error {
+	return e.ps.Double(Simple_Double_FieldNumber, v)
+}
+
+// Float writes the float field.
+func (e SimpleEncoder) Float(v float32) error {
+	return e.ps.Float(Simple_Float_FieldNumber, v)
+}
+
+// Int32 writes the int32 field.
+func (e SimpleEncoder) Int32(v int32) error {
+	return e.ps.Int32(Simple_Int32_FieldNumber, v)
+}
+
+// Int64 writes the int64 field.
+func (e SimpleEncoder) Int64(v int64) error {
+	return e.ps.Int64(Simple_Int64_FieldNumber, v)
+}
+
+// Uint32 writes the uint32 field.
+func (e SimpleEncoder) Uint32(v uint32) error {
+	return e.ps.Uint32(Simple_Uint32_FieldNumber, v)
+}
+
+// Uint64 writes the uint64 field.
+func (e SimpleEncoder) Uint64(v uint64) error {
+	return e.ps.Uint64(Simple_Uint64_FieldNumber, v)
+}
+
+// Sint32 writes the sint32 field.
+func (e SimpleEncoder) Sint32(v int32) error {
+	return e.ps.Sint32(Simple_Sint32_FieldNumber, v)
+}
+
+// Sint64 writes the sint64 field.
+func (e SimpleEncoder) Sint64(v int64) error {
+	return e.ps.Sint64(Simple_Sint64_FieldNumber, v)
+}
+
+// Fixed32 writes the fixed32 field.
+func (e SimpleEncoder) Fixed32(v uint32) error {
+	return e.ps.Fixed32(Simple_Fixed32_FieldNumber, v)
+}
+
+// Fixed64 writes the fixed64 field.
+func (e SimpleEncoder) Fixed64(v uint64) error {
+	return e.ps.Fixed64(Simple_Fixed64_FieldNumber, v)
+}
+
+// Sfixed32 writes the sfixed32 field.
+func (e SimpleEncoder) Sfixed32(v int32) error {
+	return e.ps.Sfixed32(Simple_Sfixed32_FieldNumber, v)
+}
+
+// Sfixed64 writes the sfixed64 field.
+func (e SimpleEncoder) Sfixed64(v int64) error {
+	return e.ps.Sfixed64(Simple_Sfixed64_FieldNumber, v)
+}
+
+// Bool writes the bool field.
+func (e SimpleEncoder) Bool(v bool) error {
+	return e.ps.Bool(Simple_Bool_FieldNumber, v)
+}
+
+// String_ writes the string field.
+func (e SimpleEncoder) String_(v string) error {
+	return e.ps.String(Simple_String__FieldNumber, v)
+}
+
+// Bytes writes the bytes field.
+func (e SimpleEncoder) Bytes(v []byte) error {
+	return e.ps.Bytes(Simple_Bytes_FieldNumber, v)
+}
+
+// RepeatedInt64Packed writes the repeated_int64_packed field in packed form.
+func (e SimpleEncoder) RepeatedInt64Packed(v []int64) error {
+	return e.ps.Int64Packed(Simple_RepeatedInt64Packed_FieldNumber, v)
+}
+
+// SimpleView is a reusable struct that simple.Simple messages can be decoded into.
+// String and bytes fields are unsafe views over the decoded buffer, and Reset truncates
+// repeated fields and retains nested messages instead of releasing them, so once a SimpleView
+// has grown to fit the messages decoded into it, decoding does not allocate. Callers may
+// also preallocate repeated fields with the capacity they expect.
+type SimpleView struct {
+	Double              float64
+	Float               float32
+	Int32               int32
+	Int64               int64
+	Uint32              uint32
+	Uint64              uint64
+	Sint32              int32
+	Sint64              int64
+	Fixed32             uint32
+	Fixed64             uint64
+	Sfixed32            int32
+	Sfixed64            int64
+	Bool                bool
+	String_             string
+	Bytes               []byte
+	RepeatedInt64Packed []int64
+}
+
+// Reset clears m so that it can be reused, retaining any memory it has allocated.
+func (m *SimpleView) Reset() {
+	m.Double = 0
+	m.Float = 0
+	m.Int32 = 0
+	m.Int64 = 0
+	m.Uint32 = 0
+	m.Uint64 = 0
+	m.Sint32 = 0
+	m.Sint64 = 0
+	m.Fixed32 = 0
+	m.Fixed64 = 0
+	m.Sfixed32 = 0
+	m.Sfixed64 = 0
+	m.Bool = false
+	m.String_ = ""
+	m.Bytes = nil
+	m.RepeatedInt64Packed = m.RepeatedInt64Packed[:0]
+}
+
+// Decode resets m and decodes the simple.Simple message stored in buffer into it.
+// Repeated scalar fields are accepted in both packed and expanded form.
+func (m *SimpleView) Decode(buffer *codec.Buffer) error {
+	m.Reset()
+	return m.merge(buffer)
+}
+
+// merge decodes the message stored in buffer into m without resetting it first, which
+// implements the merge semantics of repeated occurrences of embedded messages.
+func (m *SimpleView) merge(buffer *codec.Buffer) error {
+	var (
+		value molecule.Value
+		inner codec.Buffer
+	)
+	// The embedded messages are views over the bytes in buffer, which are not poisoned.
+	inner.PoisonOnReset(false)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+
+		switch uint64(fieldNum)<<3 | uint64(value.WireType) {
+		case Simple_Double_FieldNumber<<3 | uint64(codec.WireFixed64):
+			if m.Double, err = value.AsDouble(); err != nil {
+				return err
+			}
+		case Simple_Float_FieldNumber<<3 | uint64(codec.WireFixed32):
+			if m.Float, err = value.AsFloat(); err != nil {
+				return err
+			}
+		case Simple_Int32_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Int32, err = value.AsInt32(); err != nil {
+				return err
+			}
+		case Simple_Int64_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Int64, err = value.AsInt64(); err != nil {
+				return err
+			}
+		case Simple_Uint32_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Uint32, err = value.AsUint32(); err != nil {
+				return err
+			}
+		case Simple_Uint64_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Uint64, err = value.AsUint64(); err != nil {
+				return err
+			}
+		case Simple_Sint32_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Sint32, err = value.AsSint32(); err != nil {
+				return err
+			}
+		case Simple_Sint64_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Sint64, err = value.AsSint64(); err != nil {
+				return err
+			}
+		case Simple_Fixed32_FieldNumber<<3 | uint64(codec.WireFixed32):
+			if m.Fixed32, err = value.AsFixed32(); err != nil {
+				return err
+			}
+		case Simple_Fixed64_FieldNumber<<3 | uint64(codec.WireFixed64):
+			if m.Fixed64, err = value.AsFixed64(); err != nil {
+				return err
+			}
+		case Simple_Sfixed32_FieldNumber<<3 | uint64(codec.WireFixed32):
+			if m.Sfixed32, err = value.AsSFixed32(); err != nil {
+				return err
+			}
+		case Simple_Sfixed64_FieldNumber<<3 | uint64(codec.WireFixed64):
+			if m.Sfixed64, err = value.AsSFixed64(); err != nil {
+				return err
+			}
+		case Simple_Bool_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Bool, err = value.AsBool(); err != nil {
+				return err
+			}
+		case Simple_String__FieldNumber<<3 | uint64(codec.WireBytes):
+			if m.String_, err = value.AsStringUnsafe(); err != nil {
+				return err
+			}
+		case Simple_Bytes_FieldNumber<<3 | uint64(codec.WireBytes):
+			if m.Bytes, err = value.AsBytesUnsafe(); err != nil {
+				return err
+			}
+		case Simple_RepeatedInt64Packed_FieldNumber<<3 | uint64(codec.WireVarint):
+			x, err := value.AsInt64()
+			if err != nil {
+				return err
+			}
+			m.RepeatedInt64Packed = append(m.RepeatedInt64Packed, x)
+		case Simple_RepeatedInt64Packed_FieldNumber<<3 | uint64(codec.WireBytes):
+			inner.Reset(value.Bytes)
+			for !inner.EOF() {
+				value.Number, err = inner.DecodeVarint()
+				if err != nil {
+					return err
+				}
+				x, err := value.AsInt64()
+				if err != nil {
+					return err
+				}
+				m.RepeatedInt64Packed = append(m.RepeatedInt64Packed, x)
+			}
+		}
+	}
+	return nil
+}
+
+// Field numbers for simple.Test.
+const (
+	Test_StringField_FieldNumber        = 1
+	Test_Int64Field_FieldNumber         = 2
+	Test_RepeatedInt64Field_FieldNumber = 3
+)
+
+// TestVisitor contains the callbacks invoked by DecodeTest for each field
+// of a simple.Test message. Nil callbacks are skipped. Returning false from
+// a callback stops decoding.
+type TestVisitor struct {
+	// StringField is called with the value of the string_field field, which is an
+	// unsafe view over the decoded buffer.
+	StringField func(v string) (bool, error)
+	// Int64Field is called with the value of the int64_field field.
+	Int64Field func(v int64) (bool, error)
+	// RepeatedInt64Field is called with the value of the repeated_int64_field field.
+	// It is called once for each element.
+	RepeatedInt64Field func(v int64) (bool, error)
+	// Unknown is called for any field that is not part of simple.Test,
+	// or that is encoded with an unexpected wire type.
+	Unknown func(fieldNum int32, value molecule.Value) (bool, error)
+}
+
+// DecodeTest decodes the simple.Test message stored in buffer, calling the
+// callbacks in v for each field. Repeated scalar fields are accepted in both packed and
+// expanded form.
+func DecodeTest(buffer *codec.Buffer, v *TestVisitor) error {
+	var (
+		value  molecule.Value
+		packed codec.Buffer
+	)
+	// The packed fields are views over the bytes in buffer, which are not poisoned.
+	packed.PoisonOnReset(false)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+
+		switch uint64(fieldNum)<<3 | uint64(value.WireType) {
+		case Test_StringField_FieldNumber<<3 | uint64(codec.WireBytes):
+			if v.StringField == nil {
+				continue
+			}
+			x, err := value.AsStringUnsafe()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.StringField(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Test_Int64Field_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.Int64Field == nil {
+				continue
+			}
+			x, err := value.AsInt64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.Int64Field(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Test_RepeatedInt64Field_FieldNumber<<3 | uint64(codec.WireVarint):
+			if v.RepeatedInt64Field == nil {
+				continue
+			}
+			x, err := value.AsInt64()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.RepeatedInt64Field(x); err != nil || !shouldContinue {
+				return err
+			}
+		case Test_RepeatedInt64Field_FieldNumber<<3 | uint64(codec.WireBytes):
+			if v.RepeatedInt64Field == nil {
+				continue
+			}
+			packed.Reset(value.Bytes)
+			for !packed.EOF() {
+				value.Number, err = packed.DecodeVarint()
+				if err != nil {
+					return err
+				}
+				x, err := value.AsInt64()
+				if err != nil {
+					return err
+				}
+				if shouldContinue, err := v.RepeatedInt64Field(x); err != nil || !shouldContinue {
+					return err
+				}
+			}
+		default:
+			if v.Unknown == nil {
+				continue
+			}
+			if shouldContinue, err := v.Unknown(fieldNum, value); err != nil || !shouldContinue {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TestEncoder writes the fields of a simple.Test message to a ProtoStream.
+type TestEncoder struct {
+	ps *molecule.ProtoStream
+}
+
+// NewTestEncoder returns a TestEncoder that writes to ps.
+func NewTestEncoder(ps *molecule.ProtoStream) TestEncoder {
+	return TestEncoder{ps: ps}
+}
+
+// StringField writes the string_field field.
+func (e TestEncoder) StringField(v string) error {
+	return e.ps.String(Test_StringField_FieldNumber, v)
+}
+
+// Int64Field writes the int64_field field.
+func (e TestEncoder) Int64Field(v int64) error {
+	return e.ps.Int64(Test_Int64Field_FieldNumber, v)
+}
+
+// RepeatedInt64Field writes the repeated_int64_field field in packed form.
+func (e TestEncoder) RepeatedInt64Field(v []int64) error {
+	return e.ps.Int64Packed(Test_RepeatedInt64Field_FieldNumber, v)
+}
+
+// TestView is a reusable struct that simple.Test messages can be decoded into.
+// String and bytes fields are unsafe views over the decoded buffer, and Reset truncates
+// repeated fields and retains nested messages instead of releasing them, so once a TestView
+// has grown to fit the messages decoded into it, decoding does not allocate. Callers may
+// also preallocate repeated fields with the capacity they expect.
+type TestView struct {
+	StringField        string
+	Int64Field         int64
+	RepeatedInt64Field []int64
+}
+
+// Reset clears m so that it can be reused, retaining any memory it has allocated.
+func (m *TestView) Reset() {
+	m.StringField = ""
+	m.Int64Field = 0
+	m.RepeatedInt64Field = m.RepeatedInt64Field[:0]
+}
+
+// Decode resets m and decodes the simple.Test message stored in buffer into it.
+// Repeated scalar fields are accepted in both packed and expanded form.
+func (m *TestView) Decode(buffer *codec.Buffer) error {
+	m.Reset()
+	return m.merge(buffer)
+}
+
+// merge decodes the message stored in buffer into m without resetting it first, which
+// implements the merge semantics of repeated occurrences of embedded messages.
+func (m *TestView) merge(buffer *codec.Buffer) error {
+	var (
+		value molecule.Value
+		inner codec.Buffer
+	)
+	// The embedded messages are views over the bytes in buffer, which are not poisoned.
+	inner.PoisonOnReset(false)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+
+		switch uint64(fieldNum)<<3 | uint64(value.WireType) {
+		case Test_StringField_FieldNumber<<3 | uint64(codec.WireBytes):
+			if m.StringField, err = value.AsStringUnsafe(); err != nil {
+				return err
+			}
+		case Test_Int64Field_FieldNumber<<3 | uint64(codec.WireVarint):
+			if m.Int64Field, err = value.AsInt64(); err != nil {
+				return err
+			}
+		case Test_RepeatedInt64Field_FieldNumber<<3 | uint64(codec.WireVarint):
+			x, err := value.AsInt64()
+			if err != nil {
+				return err
+			}
+			m.RepeatedInt64Field = append(m.RepeatedInt64Field, x)
+		case Test_RepeatedInt64Field_FieldNumber<<3 | uint64(codec.WireBytes):
+			inner.Reset(value.Bytes)
+			for !inner.EOF() {
+				value.Number, err = inner.DecodeVarint()
+				if err != nil {
+					return err
+				}
+				x, err := value.AsInt64()
+				if err != nil {
+					return err
+				}
+				m.RepeatedInt64Field = append(m.RepeatedInt64Field, x)
+			}
+		}
+	}
+	return nil
+}
+
+// Field numbers for simple.Nested.
+const (
+	Nested_NestedMessage_FieldNumber = 1
+)
+
+// NestedVisitor contains the callbacks invoked by DecodeNested for each field
+// of a simple.Nested message. Nil callbacks are skipped. Returning false from
+// a callback stops decoding.
+type NestedVisitor struct {
+	// NestedMessage is called with the encoded bytes of the nested_message field, which is
+	// an unsafe view over the decoded buffer.
+	NestedMessage func(v []byte) (bool, error)
+	// Unknown is called for any field that is not part of simple.Nested,
+	// or that is encoded with an unexpected wire type.
+	Unknown func(fieldNum int32, value molecule.Value) (bool, error)
+}
+
+// DecodeNested decodes the simple.Nested message stored in buffer, calling the
+// callbacks in v for each field. Repeated scalar fields are accepted in both packed and
+// expanded form.
+func DecodeNested(buffer *codec.Buffer, v *NestedVisitor) error {
+	var value molecule.Value
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+
+		switch uint64(fieldNum)<<3 | uint64(value.WireType) {
+		case Nested_NestedMessage_FieldNumber<<3 | uint64(codec.WireBytes):
+			if v.NestedMessage == nil {
+				continue
+			}
+			x, err := value.AsBytesUnsafe()
+			if err != nil {
+				return err
+			}
+			if shouldContinue, err := v.NestedMessage(x); err != nil || !shouldContinue {
+				return err
+			}
+		default:
+			if v.Unknown == nil {
+				continue
+			}
+			if shouldContinue, err := v.Unknown(fieldNum, value); err != nil || !shouldContinue {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NestedEncoder writes the fields of a simple.Nested message to a ProtoStream.
+type NestedEncoder struct {
+	ps *molecule.ProtoStream
+}
+
+// NewNestedEncoder returns a NestedEncoder that writes to ps.
+func NewNestedEncoder(ps *molecule.ProtoStream) NestedEncoder {
+	return NestedEncoder{ps: ps}
+}
+
+// NestedMessage writes the nested_message field as an embedded message, calling inner
+// to write its fields.
+func (e NestedEncoder) NestedMessage(inner func(*molecule.ProtoStream) error) error {
+	return e.ps.Embedded(Nested_NestedMessage_FieldNumber, inner)
+}
+
+// NestedView is a reusable struct that simple.Nested messages can be decoded into.
+// String and bytes fields are unsafe views over the decoded buffer, and Reset truncates
+// repeated fields and retains nested messages instead of releasing them, so once a NestedView
+// has grown to fit the messages decoded into it, decoding does not allocate. Callers may
+// also preallocate repeated fields with the capacity they expect.
+type NestedView struct {
+	// NestedMessage is nil if the nested_message field is not present.
+	NestedMessage *TestView
+
+	// nestedMessage retains NestedMessage across calls to Reset for reuse.
+	nestedMessage *TestView
+}
+
+// Reset clears m so that it can be reused, retaining any memory it has allocated.
+func (m *NestedView) Reset() {
+	if m.NestedMessage != nil {
+		m.nestedMessage = m.NestedMessage
+		m.NestedMessage = nil
+	}
+}
+
+// Decode resets m and decodes the simple.Nested message stored in buffer into it.
+// Repeated scalar fields are accepted in both packed and expanded form.
+func (m *NestedView) Decode(buffer *codec.Buffer) error {
+	m.Reset()
+	return m.merge(buffer)
+}
+
+// merge decodes the message stored in buffer into m without resetting it first, which
+// implements the merge semantics of repeated occurrences of embedded messages.
+func (m *NestedView) merge(buffer *codec.Buffer) error {
+	var (
+		value molecule.Value
+		inner codec.Buffer
+	)
+	// The embedded messages are views over the bytes in buffer, which are not poisoned.
+	inner.PoisonOnReset(false)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return err
+		}
+
+		switch uint64(fieldNum)<<3 | uint64(value.WireType) {
+		case Nested_NestedMessage_FieldNumber<<3 | uint64(codec.WireBytes):
+			if m.NestedMessage == nil {
+				if m.nestedMessage == nil {
+					m.nestedMessage = &TestView{}
+				} else {
+					m.nestedMessage.Reset()
+				}
+				m.NestedMessage = m.nestedMessage
+			}
+			inner.Reset(value.Bytes)
+			if err := m.NestedMessage.merge(&inner); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
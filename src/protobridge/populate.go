@@ -0,0 +1,291 @@
+// Package protobridge converts between molecule and the messages of
+// google.golang.org/protobuf, so that the two libraries can be mixed without marshaling a
+// message twice.
+//
+// Populate decodes a chosen subset of the fields scanned by molecule into a
+// protoreflect.Message, and Stream writes the fields of a protoreflect.Message to a
+// ProtoStream.  Groups are not supported, like in the rest of molecule.
+package protobridge
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/internal/protokind"
+	"github.com/richardartoul/molecule/src/protowire"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Populate decodes the message stored in buffer into m, merging it with the fields that
+// are already set, like proto.Merge.
+//
+// If fieldNumbers is empty every field is decoded, and fields that are not defined by the
+// descriptor of m are added to its unknown fields.  Otherwise only the listed fields are
+// decoded, and all other fields are skipped.  Nested messages are always decoded in full.
+//
+// Values are decoded like proto.Unmarshal decodes them: fields whose wire type does not
+// match their descriptor are added to the unknown fields instead of being rejected,
+// 32-bit integers that overflow are truncated, any non-zero varint is a true bool, and
+// strings in proto3 messages that are not valid UTF-8 are rejected.  Unlike
+// proto.Unmarshal, which treats every enum as open, closed enums, such as the enums
+// declared in proto2 files, only hold the values that they declare, as the protobuf
+// specification requires: other values are added to the unknown fields, including the
+// elements of packed fields and the entries of maps.
+//
+// Strings and bytes are copied, so m does not refer to the bytes in buffer.
+func Populate(buffer *codec.Buffer, m protoreflect.Message, fieldNumbers ...protoreflect.FieldNumber) error {
+	if err := populate(buffer, m, fieldNumbers); err != nil {
+		return fmt.Errorf("Populate: %v", err)
+	}
+	return nil
+}
+
+func populate(buffer *codec.Buffer, m protoreflect.Message, fieldNumbers []protoreflect.FieldNumber) error {
+	var (
+		fields = m.Descriptor().Fields()
+		value  molecule.Value
+	)
+	for !buffer.EOF() {
+		fieldNum, field, err := molecule.NextField(buffer, &value)
+		if err != nil {
+			return err
+		}
+		num := protoreflect.FieldNumber(fieldNum)
+		if len(fieldNumbers) > 0 && !contains(fieldNumbers, num) {
+			continue
+		}
+
+		fd := fields.ByNumber(num)
+		if fd == nil || !protokind.ValidWireType(fd, value.WireType) {
+			// Like proto.Unmarshal, fields whose wire type does not match their
+			// descriptor are treated as unknown fields.
+			m.SetUnknown(append(m.GetUnknown(), field...))
+			continue
+		}
+		if err := populateField(m, fd, value, field); err != nil {
+			return fmt.Errorf("error decoding field %s: %v", fd.FullName(), err)
+		}
+	}
+	return nil
+}
+
+// populateField decodes value, which was read from a field described by fd, into m.
+// raw is the encoded field, which is added to the unknown fields of m if it holds an
+// unknown value of a closed enum.
+func populateField(m protoreflect.Message, fd protoreflect.FieldDescriptor, value molecule.Value, raw []byte) error {
+	switch {
+	case fd.IsMap():
+		return populateMapEntry(m, fd, value, raw)
+
+	case fd.IsList():
+		list := m.Mutable(fd).List()
+		if fd.Kind() == protoreflect.MessageKind {
+			elem := list.NewElement()
+			if err := populateMessage(elem.Message(), value); err != nil {
+				return err
+			}
+			list.Append(elem)
+			return nil
+		}
+		if value.WireType == codec.WireBytes && protokind.IsPackable(fd.Kind()) {
+			packed, err := value.AsBytesUnsafe()
+			if err != nil {
+				return err
+			}
+			// Unknown values of closed enums are added to the unknown fields as if they
+			// had not been packed.
+			var unknown []byte
+			err = molecule.PackedRepeatedEach(codec.NewBuffer(packed), codec.FieldType(fd.Kind()), func(v molecule.Value) (bool, error) {
+				elem, err := decodeScalar(fd, v)
+				if err != nil {
+					return false, err
+				}
+				if protokind.IsUnknownEnum(fd, elem) {
+					unknown = protowire.AppendVarint(unknown, uint64(fd.Number())<<3|uint64(codec.WireVarint))
+					unknown = protowire.AppendVarint(unknown, v.Number)
+					return true, nil
+				}
+				list.Append(elem)
+				return true, nil
+			})
+			if len(unknown) > 0 {
+				m.SetUnknown(append(m.GetUnknown(), unknown...))
+			}
+			return err
+		}
+		elem, err := decodeScalar(fd, value)
+		if err != nil {
+			return err
+		}
+		if protokind.IsUnknownEnum(fd, elem) {
+			m.SetUnknown(append(m.GetUnknown(), raw...))
+			return nil
+		}
+		list.Append(elem)
+		return nil
+
+	case fd.Kind() == protoreflect.MessageKind:
+		return populateMessage(m.Mutable(fd).Message(), value)
+
+	default:
+		v, err := decodeScalar(fd, value)
+		if err != nil {
+			return err
+		}
+		if protokind.IsUnknownEnum(fd, v) {
+			m.SetUnknown(append(m.GetUnknown(), raw...))
+			return nil
+		}
+		m.Set(fd, v)
+		return nil
+	}
+}
+
+// populateMessage decodes the embedded message held by value into m.
+func populateMessage(m protoreflect.Message, value molecule.Value) error {
+	b, err := value.AsBytesUnsafe()
+	if err != nil {
+		return err
+	}
+	return populate(codec.NewBuffer(b), m, nil)
+}
+
+// populateMapEntry decodes the map entry held by value into the map field of m described
+// by fd.  Like populateField, it adds the entry to the unknown fields of m instead if its
+// value is an unknown value of a closed enum.
+func populateMapEntry(m protoreflect.Message, fd protoreflect.FieldDescriptor, value molecule.Value, raw []byte) error {
+	b, err := value.AsBytesUnsafe()
+	if err != nil {
+		return err
+	}
+
+	var (
+		mp      = m.Mutable(fd).Map()
+		keyFD   = fd.MapKey()
+		valueFD = fd.MapValue()
+		key     = keyFD.Default()
+		val     protoreflect.Value
+		entry   = codec.NewBuffer(b)
+		field   molecule.Value
+	)
+	if valueFD.Kind() == protoreflect.MessageKind {
+		val = mp.NewValue()
+	} else {
+		val = valueFD.Default()
+	}
+	for !entry.EOF() {
+		fieldNum, err := molecule.Next(entry, &field)
+		if err != nil {
+			return err
+		}
+		switch num := protoreflect.FieldNumber(fieldNum); {
+		case num == keyFD.Number() && field.WireType == protokind.WireType(keyFD.Kind()):
+			key, err = decodeScalar(keyFD, field)
+		case num == valueFD.Number() && field.WireType == protokind.WireType(valueFD.Kind()):
+			if valueFD.Kind() == protoreflect.MessageKind {
+				err = populateMessage(val.Message(), field)
+			} else {
+				val, err = decodeScalar(valueFD, field)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if protokind.IsUnknownEnum(valueFD, val) {
+		m.SetUnknown(append(m.GetUnknown(), raw...))
+		return nil
+	}
+	mp.Set(key.MapKey(), val)
+	return nil
+}
+
+// decodeScalar decodes a value of the field described by fd, which is not a message.
+func decodeScalar(fd protoreflect.FieldDescriptor, value molecule.Value) (protoreflect.Value, error) {
+	kind := fd.Kind()
+	if expected := protokind.WireType(kind); value.WireType != expected {
+		return protoreflect.Value{}, fmt.Errorf("wire type %d does not match kind %s, expected wire type %d", value.WireType, kind, expected)
+	}
+
+	var (
+		v   protoreflect.Value
+		err error
+	)
+	// Like proto.Unmarshal, 32-bit varints are truncated rather than rejected when they
+	// overflow, and any non-zero varint is a true bool.
+	switch kind {
+	case protoreflect.BoolKind:
+		v = protoreflect.ValueOfBool(value.Number != 0)
+	case protoreflect.EnumKind:
+		v = protoreflect.ValueOfEnum(protoreflect.EnumNumber(int32(value.Number)))
+	case protoreflect.Int32Kind:
+		v = protoreflect.ValueOfInt32(int32(value.Number))
+	case protoreflect.Sint32Kind:
+		v = protoreflect.ValueOfInt32(codec.DecodeZigZag32(value.Number))
+	case protoreflect.Sfixed32Kind:
+		var x int32
+		x, err = value.AsSFixed32()
+		v = protoreflect.ValueOfInt32(x)
+	case protoreflect.Uint32Kind:
+		v = protoreflect.ValueOfUint32(uint32(value.Number))
+	case protoreflect.Fixed32Kind:
+		var x uint32
+		x, err = value.AsFixed32()
+		v = protoreflect.ValueOfUint32(x)
+	case protoreflect.Int64Kind:
+		var x int64
+		x, err = value.AsInt64()
+		v = protoreflect.ValueOfInt64(x)
+	case protoreflect.Sint64Kind:
+		var x int64
+		x, err = value.AsSint64()
+		v = protoreflect.ValueOfInt64(x)
+	case protoreflect.Sfixed64Kind:
+		var x int64
+		x, err = value.AsSFixed64()
+		v = protoreflect.ValueOfInt64(x)
+	case protoreflect.Uint64Kind:
+		var x uint64
+		x, err = value.AsUint64()
+		v = protoreflect.ValueOfUint64(x)
+	case protoreflect.Fixed64Kind:
+		var x uint64
+		x, err = value.AsFixed64()
+		v = protoreflect.ValueOfUint64(x)
+	case protoreflect.FloatKind:
+		var x float32
+		x, err = value.AsFloat()
+		v = protoreflect.ValueOfFloat32(x)
+	case protoreflect.DoubleKind:
+		var x float64
+		x, err = value.AsDouble()
+		v = protoreflect.ValueOfFloat64(x)
+	case protoreflect.StringKind:
+		var x string
+		x, err = value.AsStringSafe()
+		if err == nil && fd.Syntax() == protoreflect.Proto3 && !utf8.ValidString(x) {
+			// Like proto.Unmarshal, reject proto3 strings that aren't valid UTF-8.
+			err = fmt.Errorf("invalid UTF-8 in string field %s", fd.FullName())
+		}
+		v = protoreflect.ValueOfString(x)
+	case protoreflect.BytesKind:
+		var x []byte
+		x, err = value.AsBytesSafe()
+		v = protoreflect.ValueOfBytes(x)
+	default:
+		err = fmt.Errorf("unsupported kind: %s", kind)
+	}
+	return v, err
+}
+
+func contains(fieldNumbers []protoreflect.FieldNumber, num protoreflect.FieldNumber) bool {
+	for _, n := range fieldNumbers {
+		if n == num {
+			return true
+		}
+	}
+	return false
+}
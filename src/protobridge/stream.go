@@ -0,0 +1,171 @@
+package protobridge
+
+import (
+	"fmt"
+
+	"github.com/richardartoul/molecule"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Stream writes the populated fields of m, followed by its unknown fields, to ps.  Like
+// proto.Marshal with the default options, the order of the fields and of map entries is
+// not specified.
+//
+// Repeated fields are written packed or expanded according to the descriptor of m.
+func Stream(ps *molecule.ProtoStream, m protoreflect.Message) error {
+	if err := streamMessage(ps, m); err != nil {
+		return fmt.Errorf("Stream: %v", err)
+	}
+	return nil
+}
+
+func streamMessage(ps *molecule.ProtoStream, m protoreflect.Message) error {
+	// Range only visits populated fields, so every value it visits must be written,
+	// even if it is a zero value of a field with explicit presence.
+	emitZeroValues := ps.EmitZeroValues
+	ps.EmitZeroValues = true
+	defer func() { ps.EmitZeroValues = emitZeroValues }()
+
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		err = streamField(ps, fd, v)
+		if err != nil {
+			err = fmt.Errorf("error encoding field %s: %v", fd.FullName(), err)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if unknown := m.GetUnknown(); len(unknown) > 0 {
+		if _, err := ps.Write(unknown); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func streamField(ps *molecule.ProtoStream, fd protoreflect.FieldDescriptor, v protoreflect.Value) error {
+	fieldNumber := int(fd.Number())
+	switch {
+	case fd.IsMap():
+		var err error
+		v.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			err = ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+				if err := streamValue(ps, fd.MapKey(), 1, key.Value()); err != nil {
+					return err
+				}
+				return streamValue(ps, fd.MapValue(), 2, value)
+			})
+			return err == nil
+		})
+		return err
+
+	case fd.IsList():
+		list := v.List()
+		if fd.IsPacked() {
+			return streamPacked(ps, fd, list)
+		}
+		for i := 0; i < list.Len(); i++ {
+			if err := streamValue(ps, fd, fieldNumber, list.Get(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return streamValue(ps, fd, fieldNumber, v)
+	}
+}
+
+// streamValue writes a single value of the kind described by fd.
+func streamValue(ps *molecule.ProtoStream, fd protoreflect.FieldDescriptor, fieldNumber int, v protoreflect.Value) error {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return ps.Bool(fieldNumber, v.Bool())
+	case protoreflect.EnumKind:
+		return ps.Int32(fieldNumber, int32(v.Enum()))
+	case protoreflect.Int32Kind:
+		return ps.Int32(fieldNumber, int32(v.Int()))
+	case protoreflect.Sint32Kind:
+		return ps.Sint32(fieldNumber, int32(v.Int()))
+	case protoreflect.Sfixed32Kind:
+		return ps.Sfixed32(fieldNumber, int32(v.Int()))
+	case protoreflect.Uint32Kind:
+		return ps.Uint32(fieldNumber, uint32(v.Uint()))
+	case protoreflect.Fixed32Kind:
+		return ps.Fixed32(fieldNumber, uint32(v.Uint()))
+	case protoreflect.Int64Kind:
+		return ps.Int64(fieldNumber, v.Int())
+	case protoreflect.Sint64Kind:
+		return ps.Sint64(fieldNumber, v.Int())
+	case protoreflect.Sfixed64Kind:
+		return ps.Sfixed64(fieldNumber, v.Int())
+	case protoreflect.Uint64Kind:
+		return ps.Uint64(fieldNumber, v.Uint())
+	case protoreflect.Fixed64Kind:
+		return ps.Fixed64(fieldNumber, v.Uint())
+	case protoreflect.FloatKind:
+		return ps.Float(fieldNumber, float32(v.Float()))
+	case protoreflect.DoubleKind:
+		return ps.Double(fieldNumber, v.Float())
+	case protoreflect.StringKind:
+		return ps.String(fieldNumber, v.String())
+	case protoreflect.BytesKind:
+		return ps.Bytes(fieldNumber, v.Bytes())
+	case protoreflect.MessageKind:
+		return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+			return streamMessage(ps, v.Message())
+		})
+	default:
+		return fmt.Errorf("unsupported kind: %s", fd.Kind())
+	}
+}
+
+// streamPacked writes list as a packed repeated field.
+func streamPacked(ps *molecule.ProtoStream, fd protoreflect.FieldDescriptor, list protoreflect.List) error {
+	fieldNumber := int(fd.Number())
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return ps.BoolPacked(fieldNumber, listValues(list, protoreflect.Value.Bool))
+	case protoreflect.EnumKind:
+		return ps.Int32Packed(fieldNumber, listValues(list, func(v protoreflect.Value) int32 { return int32(v.Enum()) }))
+	case protoreflect.Int32Kind:
+		return ps.Int32Packed(fieldNumber, listValues(list, func(v protoreflect.Value) int32 { return int32(v.Int()) }))
+	case protoreflect.Int64Kind:
+		return ps.Int64Packed(fieldNumber, listValues(list, protoreflect.Value.Int))
+	case protoreflect.Uint32Kind:
+		return ps.Uint32Packed(fieldNumber, listValues(list, func(v protoreflect.Value) uint32 { return uint32(v.Uint()) }))
+	case protoreflect.Uint64Kind:
+		return ps.Uint64Packed(fieldNumber, listValues(list, protoreflect.Value.Uint))
+	case protoreflect.Sint32Kind:
+		return ps.Sint32Packed(fieldNumber, listValues(list, func(v protoreflect.Value) int32 { return int32(v.Int()) }))
+	case protoreflect.Sint64Kind:
+		return ps.Sint64Packed(fieldNumber, listValues(list, protoreflect.Value.Int))
+	case protoreflect.Fixed32Kind:
+		return ps.Fixed32Packed(fieldNumber, listValues(list, func(v protoreflect.Value) uint32 { return uint32(v.Uint()) }))
+	case protoreflect.Sfixed32Kind:
+		return ps.Sfixed32Packed(fieldNumber, listValues(list, func(v protoreflect.Value) int32 { return int32(v.Int()) }))
+	case protoreflect.Fixed64Kind:
+		return ps.Fixed64Packed(fieldNumber, listValues(list, protoreflect.Value.Uint))
+	case protoreflect.Sfixed64Kind:
+		return ps.Sfixed64Packed(fieldNumber, listValues(list, protoreflect.Value.Int))
+	case protoreflect.FloatKind:
+		return ps.FloatPacked(fieldNumber, listValues(list, func(v protoreflect.Value) float32 { return float32(v.Float()) }))
+	case protoreflect.DoubleKind:
+		return ps.DoublePacked(fieldNumber, listValues(list, protoreflect.Value.Float))
+	default:
+		return fmt.Errorf("unsupported packed kind: %s", fd.Kind())
+	}
+}
+
+// listValues returns the elements of list, converted with convert.
+func listValues[T any](list protoreflect.List, convert func(protoreflect.Value) T) []T {
+	values := make([]T, list.Len())
+	for i := range values {
+		values[i] = convert(list.Get(i))
+	}
+	return values
+}
@@ -9,6 +9,8 @@
 // use the "google.golang.org/protobuf/proto" package instead.
 package protowire
 
+import "math/bits"
+
 // This file has been modified from the original:
 //
 // * remove items not needed for Molecule (methods, constants)
@@ -107,6 +109,14 @@ func AppendVarint(b []byte, v uint64) []byte {
 	return b
 }
 
+// SizeVarint returns the encoded size of a varint.
+// The size is guaranteed to be within 1 and 10, inclusive.
+func SizeVarint(v uint64) int {
+	// This computes 1 + (bits.Len64(v)-1)/7.
+	// 9/64 is a good enough approximation of 1/7
+	return int(9*uint32(bits.Len64(v))+64) / 64
+}
+
 // AppendFixed32 appends v to b as a little-endian uint32.
 func AppendFixed32(b []byte, v uint32) []byte {
 	return append(b,
@@ -0,0 +1,154 @@
+package wkt
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+// Field numbers of google.protobuf.Any.
+const (
+	typeURLFieldNumber  = 1
+	anyValueFieldNumber = 2
+)
+
+// DecodeAny decodes the google.protobuf.Any message stored in buffer and returns its type
+// URL and the encoded message that it holds. Both are unsafe views over the bytes in
+// buffer.
+func DecodeAny(buffer *codec.Buffer) (typeURL string, value []byte, err error) {
+	var field molecule.Value
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &field)
+		if err != nil {
+			return "", nil, fmt.Errorf("DecodeAny: %v", err)
+		}
+		switch fieldNum {
+		case typeURLFieldNumber:
+			if err = checkWireType(fieldNum, field, codec.WireBytes); err == nil {
+				typeURL, err = field.AsStringUnsafe()
+			}
+		case anyValueFieldNumber:
+			if err = checkWireType(fieldNum, field, codec.WireBytes); err == nil {
+				value, err = field.AsBytesUnsafe()
+			}
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("DecodeAny: %v", err)
+		}
+	}
+	return typeURL, value, nil
+}
+
+// EncodeAny writes an embedded google.protobuf.Any message holding the given type URL and
+// encoded message.
+func EncodeAny(ps *molecule.ProtoStream, fieldNumber int, typeURL string, value []byte) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		if err := ps.String(typeURLFieldNumber, typeURL); err != nil {
+			return err
+		}
+		return ps.Bytes(anyValueFieldNumber, value)
+	})
+}
+
+// AnyTypeURL returns the type URL of the google.protobuf.Any message held by value, which
+// must be a length-delimited field. The type URL is an unsafe view over the bytes of value.
+// Like DecodeAny, it returns the last type URL if the field occurs more than once, so the
+// whole message is scanned.
+func AnyTypeURL(value molecule.Value) (string, error) {
+	b, err := value.AsBytesUnsafe()
+	if err != nil {
+		return "", fmt.Errorf("AnyTypeURL: %v", err)
+	}
+	var (
+		buffer  codec.Buffer
+		field   molecule.Value
+		typeURL string
+	)
+	buffer.Reset(b)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(&buffer, &field)
+		if err != nil {
+			return "", fmt.Errorf("AnyTypeURL: %v", err)
+		}
+		if fieldNum == typeURLFieldNumber {
+			if err := checkWireType(fieldNum, field, codec.WireBytes); err != nil {
+				return "", fmt.Errorf("AnyTypeURL: %v", err)
+			}
+			if typeURL, err = field.AsStringUnsafe(); err != nil {
+				return "", fmt.Errorf("AnyTypeURL: %v", err)
+			}
+		}
+	}
+	return typeURL, nil
+}
+
+// An AnyHandler decodes the message held by a google.protobuf.Any. The buffer is only valid
+// until the handler returns.
+type AnyHandler func(buffer *codec.Buffer) error
+
+// An AnyRegistry routes google.protobuf.Any messages to the handler registered for their
+// type URL.
+//
+// Handlers must all be registered before the registry is used, after which Dispatch and
+// DispatchValue may be called concurrently. Neither allocates.
+type AnyRegistry struct {
+	handlers map[string]AnyHandler
+	buffers  sync.Pool
+}
+
+// NewAnyRegistry creates a new, empty AnyRegistry.
+func NewAnyRegistry() *AnyRegistry {
+	return &AnyRegistry{
+		handlers: make(map[string]AnyHandler),
+		buffers: sync.Pool{
+			New: func() interface{} {
+				// The buffers hold views over the messages passed to Dispatch, which
+				// are not poisoned.
+				buffer := codec.NewBuffer(nil)
+				buffer.PoisonOnReset(false)
+				return buffer
+			},
+		},
+	}
+}
+
+// Register registers handler for messages with the given type URL, for example
+// "type.googleapis.com/google.protobuf.Duration", replacing any existing handler.
+func (r *AnyRegistry) Register(typeURL string, handler AnyHandler) {
+	r.handlers[typeURL] = handler
+}
+
+// Dispatch decodes the google.protobuf.Any message stored in buffer and calls the handler
+// registered for its type URL with a buffer over the message that it holds. It returns
+// false if no handler is registered for the type URL.
+func (r *AnyRegistry) Dispatch(buffer *codec.Buffer) (bool, error) {
+	typeURL, value, err := DecodeAny(buffer)
+	if err != nil {
+		return false, fmt.Errorf("Dispatch: %v", err)
+	}
+	handler, ok := r.handlers[typeURL]
+	if !ok {
+		return false, nil
+	}
+
+	inner := r.buffers.Get().(*codec.Buffer)
+	inner.Reset(value)
+	err = handler(inner)
+	inner.Reset(nil)
+	r.buffers.Put(inner)
+	return true, err
+}
+
+// DispatchValue is like Dispatch, but for the google.protobuf.Any message held by value,
+// which must be a length-delimited field.
+func (r *AnyRegistry) DispatchValue(value molecule.Value) (bool, error) {
+	b, err := value.AsBytesUnsafe()
+	if err != nil {
+		return false, fmt.Errorf("DispatchValue: %v", err)
+	}
+	var buffer codec.Buffer
+	buffer.Reset(b)
+	return r.Dispatch(&buffer)
+}
@@ -0,0 +1,15 @@
+// Package wkt provides helpers for decoding and encoding the protobuf well-known types,
+// such as google.protobuf.Timestamp and google.protobuf.Int64Value, with molecule.
+//
+// The Decode* functions decode the embedded message stored in buffer, which is typically
+// created from the bytes of a field with Value.AsBytesUnsafe, without allocating. The
+// Encode* functions write an embedded message with the given field number to a
+// ProtoStream.
+//
+// The wrapper types, such as google.protobuf.Int64Value, are used to distinguish between a
+// field that is absent and one that is set to its zero value. The presence of the wrapper
+// message itself carries that information, so the Decode*Value functions should be called
+// when the field holding the wrapper is present, and they return the zero value if the
+// wrapped value is absent. Likewise, the Encode*Value functions always write the wrapper
+// message, even for zero values.
+package wkt
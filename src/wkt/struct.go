@@ -0,0 +1,338 @@
+package wkt
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+// Field numbers of google.protobuf.Struct, its map entries, google.protobuf.Value and
+// google.protobuf.ListValue.
+const (
+	structFieldsFieldNumber = 1
+
+	entryKeyFieldNumber   = 1
+	entryValueFieldNumber = 2
+
+	nullValueFieldNumber   = 1
+	numberValueFieldNumber = 2
+	stringValueFieldNumber = 3
+	boolValueFieldNumber   = 4
+	structValueFieldNumber = 5
+	listValueFieldNumber   = 6
+
+	listValuesFieldNumber = 1
+)
+
+// Kind is the kind of a google.protobuf.Value, which corresponds to the field of its kind
+// oneof that is set.
+type Kind int
+
+const (
+	// NoKind means that none of the fields of the value are set.
+	NoKind Kind = iota
+	// NullKind means that null_value is set.
+	NullKind
+	// NumberKind means that number_value is set.
+	NumberKind
+	// StringKind means that string_value is set.
+	StringKind
+	// BoolKind means that bool_value is set.
+	BoolKind
+	// StructKind means that struct_value is set.
+	StructKind
+	// ListKind means that list_value is set.
+	ListKind
+)
+
+// Value is a decoded google.protobuf.Value. Only the field that corresponds to Kind is set.
+type Value struct {
+	Kind   Kind
+	Number float64
+	// String is an unsafe view over the bytes in the buffer that the value was decoded from.
+	String string
+	Bool   bool
+	// Message contains the encoded google.protobuf.Struct for StructKind, which can be
+	// iterated with StructEach, or the encoded google.protobuf.ListValue for ListKind, which
+	// can be iterated with ListValueEach. It is an unsafe view over the bytes in the buffer
+	// that the value was decoded from.
+	Message []byte
+}
+
+// DecodeValue decodes the google.protobuf.Value message stored in buffer.
+func DecodeValue(buffer *codec.Buffer) (Value, error) {
+	var (
+		v     Value
+		field molecule.Value
+	)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &field)
+		if err != nil {
+			return Value{}, fmt.Errorf("DecodeValue: %v", err)
+		}
+		// Like any oneof, the last field that is set wins.
+		switch fieldNum {
+		case nullValueFieldNumber:
+			v = Value{Kind: NullKind}
+			err = checkWireType(fieldNum, field, codec.WireVarint)
+		case numberValueFieldNumber:
+			v = Value{Kind: NumberKind, Number: math.Float64frombits(field.Number)}
+			err = checkWireType(fieldNum, field, codec.WireFixed64)
+		case stringValueFieldNumber:
+			v = Value{Kind: StringKind}
+			if err = checkWireType(fieldNum, field, codec.WireBytes); err == nil {
+				v.String, err = field.AsStringUnsafe()
+			}
+		case boolValueFieldNumber:
+			v = Value{Kind: BoolKind}
+			if err = checkWireType(fieldNum, field, codec.WireVarint); err == nil {
+				v.Bool, err = field.AsBool()
+			}
+		case structValueFieldNumber:
+			v = Value{Kind: StructKind}
+			if err = checkWireType(fieldNum, field, codec.WireBytes); err == nil {
+				v.Message, err = field.AsBytesUnsafe()
+			}
+		case listValueFieldNumber:
+			v = Value{Kind: ListKind}
+			if err = checkWireType(fieldNum, field, codec.WireBytes); err == nil {
+				v.Message, err = field.AsBytesUnsafe()
+			}
+		}
+		if err != nil {
+			return Value{}, fmt.Errorf("DecodeValue: %v", err)
+		}
+	}
+	return v, nil
+}
+
+// StructEachFn is a function that will be called for each field in a google.protobuf.Struct
+// passed to StructEach.
+type StructEachFn func(key string, value Value) (bool, error)
+
+// StructEach iterates over each field in the google.protobuf.Struct message stored in buffer
+// and calls fn on each one. The order of the fields is the order in which they were encoded,
+// and keys are unsafe views over the bytes in buffer.
+func StructEach(buffer *codec.Buffer, fn StructEachFn) error {
+	var (
+		field molecule.Value
+		entry codec.Buffer
+		inner codec.Buffer
+	)
+	// The entries and values are views over the bytes in buffer, which are not poisoned.
+	entry.PoisonOnReset(false)
+	inner.PoisonOnReset(false)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &field)
+		if err != nil {
+			return fmt.Errorf("StructEach: %v", err)
+		}
+		if fieldNum != structFieldsFieldNumber {
+			continue
+		}
+		if err := checkWireType(fieldNum, field, codec.WireBytes); err != nil {
+			return fmt.Errorf("StructEach: %v", err)
+		}
+		entryBytes, err := field.AsBytesUnsafe()
+		if err != nil {
+			return fmt.Errorf("StructEach: %v", err)
+		}
+
+		var (
+			key   string
+			value Value
+		)
+		entry.Reset(entryBytes)
+		for !entry.EOF() {
+			fieldNum, err := molecule.Next(&entry, &field)
+			if err != nil {
+				return fmt.Errorf("StructEach: error reading map entry: %v", err)
+			}
+			switch fieldNum {
+			case entryKeyFieldNumber:
+				if err = checkWireType(fieldNum, field, codec.WireBytes); err == nil {
+					key, err = field.AsStringUnsafe()
+				}
+			case entryValueFieldNumber:
+				var valueBytes []byte
+				if err = checkWireType(fieldNum, field, codec.WireBytes); err == nil {
+					valueBytes, err = field.AsBytesUnsafe()
+				}
+				if err == nil {
+					inner.Reset(valueBytes)
+					value, err = DecodeValue(&inner)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("StructEach: error reading map entry: %v", err)
+			}
+		}
+
+		if shouldContinue, err := fn(key, value); err != nil || !shouldContinue {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListValueEachFn is a function that will be called for each value in a
+// google.protobuf.ListValue passed to ListValueEach.
+type ListValueEachFn func(value Value) (bool, error)
+
+// ListValueEach iterates over each value in the google.protobuf.ListValue message stored in
+// buffer and calls fn on each one.
+func ListValueEach(buffer *codec.Buffer, fn ListValueEachFn) error {
+	var (
+		field molecule.Value
+		inner codec.Buffer
+	)
+	// The values are views over the bytes in buffer, which are not poisoned.
+	inner.PoisonOnReset(false)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &field)
+		if err != nil {
+			return fmt.Errorf("ListValueEach: %v", err)
+		}
+		if fieldNum != listValuesFieldNumber {
+			continue
+		}
+		if err := checkWireType(fieldNum, field, codec.WireBytes); err != nil {
+			return fmt.Errorf("ListValueEach: %v", err)
+		}
+		valueBytes, err := field.AsBytesUnsafe()
+		if err != nil {
+			return fmt.Errorf("ListValueEach: %v", err)
+		}
+		inner.Reset(valueBytes)
+		value, err := DecodeValue(&inner)
+		if err != nil {
+			return fmt.Errorf("ListValueEach: %v", err)
+		}
+
+		if shouldContinue, err := fn(value); err != nil || !shouldContinue {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeValue writes v as an embedded google.protobuf.Value message. For StructKind and
+// ListKind, v.Message must contain the encoded google.protobuf.Struct or
+// google.protobuf.ListValue, for example as returned by DecodeValue. Use EncodeValueStruct
+// and EncodeValueList to build them instead.
+func EncodeValue(ps *molecule.ProtoStream, fieldNumber int, v Value) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		// The kind is a oneof, so its fields have explicit presence and must be written
+		// even if they are zero.
+		emitZeroValues := ps.EmitZeroValues
+		ps.EmitZeroValues = true
+		defer func() { ps.EmitZeroValues = emitZeroValues }()
+
+		switch v.Kind {
+		case NoKind:
+			return nil
+		case NullKind:
+			return ps.Int32(nullValueFieldNumber, 0)
+		case NumberKind:
+			return ps.Double(numberValueFieldNumber, v.Number)
+		case StringKind:
+			return ps.String(stringValueFieldNumber, v.String)
+		case BoolKind:
+			return ps.Bool(boolValueFieldNumber, v.Bool)
+		case StructKind:
+			return ps.Bytes(structValueFieldNumber, v.Message)
+		case ListKind:
+			return ps.Bytes(listValueFieldNumber, v.Message)
+		default:
+			return fmt.Errorf("EncodeValue: unknown kind: %d", v.Kind)
+		}
+	})
+}
+
+// EncodeValueStruct writes an embedded google.protobuf.Value message holding a
+// google.protobuf.Struct, calling inner to write the fields of the struct with the
+// EncodeStructField* functions.
+func EncodeValueStruct(ps *molecule.ProtoStream, fieldNumber int, inner func(*molecule.ProtoStream) error) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Embedded(structValueFieldNumber, inner)
+	})
+}
+
+// EncodeValueList writes an embedded google.protobuf.Value message holding a
+// google.protobuf.ListValue, calling inner to write the values of the list with the
+// EncodeListElement* functions.
+func EncodeValueList(ps *molecule.ProtoStream, fieldNumber int, inner func(*molecule.ProtoStream) error) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Embedded(listValueFieldNumber, inner)
+	})
+}
+
+// EncodeStruct writes an embedded google.protobuf.Struct message, calling inner to write
+// its fields with the EncodeStructField* functions.
+func EncodeStruct(ps *molecule.ProtoStream, fieldNumber int, inner func(*molecule.ProtoStream) error) error {
+	return ps.Embedded(fieldNumber, inner)
+}
+
+// EncodeStructField writes a field of a google.protobuf.Struct with the given key and value.
+func EncodeStructField(ps *molecule.ProtoStream, key string, v Value) error {
+	return encodeStructEntry(ps, key, func(ps *molecule.ProtoStream) error {
+		return EncodeValue(ps, entryValueFieldNumber, v)
+	})
+}
+
+// EncodeStructFieldStruct writes a field of a google.protobuf.Struct with the given key
+// whose value is a nested google.protobuf.Struct, calling inner to write its fields.
+func EncodeStructFieldStruct(ps *molecule.ProtoStream, key string, inner func(*molecule.ProtoStream) error) error {
+	return encodeStructEntry(ps, key, func(ps *molecule.ProtoStream) error {
+		return EncodeValueStruct(ps, entryValueFieldNumber, inner)
+	})
+}
+
+// EncodeStructFieldList writes a field of a google.protobuf.Struct with the given key whose
+// value is a google.protobuf.ListValue, calling inner to write its values.
+func EncodeStructFieldList(ps *molecule.ProtoStream, key string, inner func(*molecule.ProtoStream) error) error {
+	return encodeStructEntry(ps, key, func(ps *molecule.ProtoStream) error {
+		return EncodeValueList(ps, entryValueFieldNumber, inner)
+	})
+}
+
+// EncodeListValue writes an embedded google.protobuf.ListValue message, calling inner to
+// write its values with the EncodeListElement* functions.
+func EncodeListValue(ps *molecule.ProtoStream, fieldNumber int, inner func(*molecule.ProtoStream) error) error {
+	return ps.Embedded(fieldNumber, inner)
+}
+
+// EncodeListElement writes a value of a google.protobuf.ListValue.
+func EncodeListElement(ps *molecule.ProtoStream, v Value) error {
+	return EncodeValue(ps, listValuesFieldNumber, v)
+}
+
+// EncodeListElementStruct writes a value of a google.protobuf.ListValue that is a
+// google.protobuf.Struct, calling inner to write its fields.
+func EncodeListElementStruct(ps *molecule.ProtoStream, inner func(*molecule.ProtoStream) error) error {
+	return EncodeValueStruct(ps, listValuesFieldNumber, inner)
+}
+
+// EncodeListElementList writes a value of a google.protobuf.ListValue that is a nested
+// google.protobuf.ListValue, calling inner to write its values.
+func EncodeListElementList(ps *molecule.ProtoStream, inner func(*molecule.ProtoStream) error) error {
+	return EncodeValueList(ps, listValuesFieldNumber, inner)
+}
+
+// encodeStructEntry writes a map entry of a google.protobuf.Struct, calling value to write
+// the value of the entry.
+func encodeStructEntry(ps *molecule.ProtoStream, key string, value func(*molecule.ProtoStream) error) error {
+	return ps.Embedded(structFieldsFieldNumber, func(ps *molecule.ProtoStream) error {
+		// Map entries always include their key, even if it is empty.
+		emitZeroValues := ps.EmitZeroValues
+		ps.EmitZeroValues = true
+		err := ps.String(entryKeyFieldNumber, key)
+		ps.EmitZeroValues = emitZeroValues
+		if err != nil {
+			return err
+		}
+		return value(ps)
+	})
+}
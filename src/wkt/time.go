@@ -0,0 +1,102 @@
+package wkt
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+// Field numbers shared by google.protobuf.Timestamp and google.protobuf.Duration.
+const (
+	secondsFieldNumber = 1
+	nanosFieldNumber   = 2
+)
+
+// DecodeTimestamp decodes the google.protobuf.Timestamp message stored in buffer and
+// returns it as a time.Time in UTC.
+func DecodeTimestamp(buffer *codec.Buffer) (time.Time, error) {
+	seconds, nanos, err := decodeSecondsAndNanos(buffer)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("DecodeTimestamp: %v", err)
+	}
+	if nanos < 0 || nanos >= int32(time.Second) {
+		return time.Time{}, fmt.Errorf("DecodeTimestamp: nanos out of range: %d", nanos)
+	}
+	return time.Unix(seconds, int64(nanos)).UTC(), nil
+}
+
+// DecodeDuration decodes the google.protobuf.Duration message stored in buffer and
+// returns it as a time.Duration. An error is returned if the seconds and nanos have
+// different signs, or if the duration does not fit in a time.Duration, which is limited to
+// roughly 290 years.
+func DecodeDuration(buffer *codec.Buffer) (time.Duration, error) {
+	seconds, nanos, err := decodeSecondsAndNanos(buffer)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeDuration: %v", err)
+	}
+	if nanos <= -int32(time.Second) || nanos >= int32(time.Second) {
+		return 0, fmt.Errorf("DecodeDuration: nanos out of range: %d", nanos)
+	}
+	if (seconds < 0 && nanos > 0) || (seconds > 0 && nanos < 0) {
+		return 0, fmt.Errorf("DecodeDuration: seconds and nanos have different signs: %d and %d", seconds, nanos)
+	}
+	if seconds > math.MaxInt64/int64(time.Second) || seconds < math.MinInt64/int64(time.Second) {
+		return 0, fmt.Errorf("DecodeDuration: %d seconds overflows time.Duration", seconds)
+	}
+	d := time.Duration(seconds) * time.Second
+	if (nanos > 0 && d > math.MaxInt64-time.Duration(nanos)) || (nanos < 0 && d < math.MinInt64-time.Duration(nanos)) {
+		return 0, fmt.Errorf("DecodeDuration: %d seconds and %d nanos overflows time.Duration", seconds, nanos)
+	}
+	return d + time.Duration(nanos), nil
+}
+
+// EncodeTimestamp writes t as an embedded google.protobuf.Timestamp message.
+func EncodeTimestamp(ps *molecule.ProtoStream, fieldNumber int, t time.Time) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		if err := ps.Int64(secondsFieldNumber, t.Unix()); err != nil {
+			return err
+		}
+		return ps.Int32(nanosFieldNumber, int32(t.Nanosecond()))
+	})
+}
+
+// EncodeDuration writes d as an embedded google.protobuf.Duration message.
+func EncodeDuration(ps *molecule.ProtoStream, fieldNumber int, d time.Duration) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		// Integer division truncates towards zero, so the seconds and nanos always have
+		// the same sign, as required.
+		if err := ps.Int64(secondsFieldNumber, int64(d/time.Second)); err != nil {
+			return err
+		}
+		return ps.Int32(nanosFieldNumber, int32(d%time.Second))
+	})
+}
+
+// decodeSecondsAndNanos decodes the fields of a google.protobuf.Timestamp or
+// google.protobuf.Duration message.
+func decodeSecondsAndNanos(buffer *codec.Buffer) (seconds int64, nanos int32, err error) {
+	var value molecule.Value
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return 0, 0, err
+		}
+		switch fieldNum {
+		case secondsFieldNumber:
+			if err = checkWireType(fieldNum, value, codec.WireVarint); err == nil {
+				seconds, err = value.AsInt64()
+			}
+		case nanosFieldNumber:
+			if err = checkWireType(fieldNum, value, codec.WireVarint); err == nil {
+				nanos, err = value.AsInt32()
+			}
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return seconds, nanos, nil
+}
@@ -0,0 +1,186 @@
+package wkt
+
+import (
+	"fmt"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+// valueFieldNumber is the field number of the value in all of the wrapper types.
+const valueFieldNumber = 1
+
+// DecodeDoubleValue decodes the google.protobuf.DoubleValue message stored in buffer.
+func DecodeDoubleValue(buffer *codec.Buffer) (float64, error) {
+	value, err := decodeWrapper(buffer, codec.WireFixed64)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeDoubleValue: %v", err)
+	}
+	return value.AsDouble()
+}
+
+// EncodeDoubleValue writes v as an embedded google.protobuf.DoubleValue message.
+func EncodeDoubleValue(ps *molecule.ProtoStream, fieldNumber int, v float64) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Double(valueFieldNumber, v)
+	})
+}
+
+// DecodeFloatValue decodes the google.protobuf.FloatValue message stored in buffer.
+func DecodeFloatValue(buffer *codec.Buffer) (float32, error) {
+	value, err := decodeWrapper(buffer, codec.WireFixed32)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeFloatValue: %v", err)
+	}
+	return value.AsFloat()
+}
+
+// EncodeFloatValue writes v as an embedded google.protobuf.FloatValue message.
+func EncodeFloatValue(ps *molecule.ProtoStream, fieldNumber int, v float32) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Float(valueFieldNumber, v)
+	})
+}
+
+// DecodeInt64Value decodes the google.protobuf.Int64Value message stored in buffer.
+func DecodeInt64Value(buffer *codec.Buffer) (int64, error) {
+	value, err := decodeWrapper(buffer, codec.WireVarint)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeInt64Value: %v", err)
+	}
+	return value.AsInt64()
+}
+
+// EncodeInt64Value writes v as an embedded google.protobuf.Int64Value message.
+func EncodeInt64Value(ps *molecule.ProtoStream, fieldNumber int, v int64) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Int64(valueFieldNumber, v)
+	})
+}
+
+// DecodeUInt64Value decodes the google.protobuf.UInt64Value message stored in buffer.
+func DecodeUInt64Value(buffer *codec.Buffer) (uint64, error) {
+	value, err := decodeWrapper(buffer, codec.WireVarint)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeUInt64Value: %v", err)
+	}
+	return value.AsUint64()
+}
+
+// EncodeUInt64Value writes v as an embedded google.protobuf.UInt64Value message.
+func EncodeUInt64Value(ps *molecule.ProtoStream, fieldNumber int, v uint64) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Uint64(valueFieldNumber, v)
+	})
+}
+
+// DecodeInt32Value decodes the google.protobuf.Int32Value message stored in buffer.
+func DecodeInt32Value(buffer *codec.Buffer) (int32, error) {
+	value, err := decodeWrapper(buffer, codec.WireVarint)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeInt32Value: %v", err)
+	}
+	return value.AsInt32()
+}
+
+// EncodeInt32Value writes v as an embedded google.protobuf.Int32Value message.
+func EncodeInt32Value(ps *molecule.ProtoStream, fieldNumber int, v int32) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Int32(valueFieldNumber, v)
+	})
+}
+
+// DecodeUInt32Value decodes the google.protobuf.UInt32Value message stored in buffer.
+func DecodeUInt32Value(buffer *codec.Buffer) (uint32, error) {
+	value, err := decodeWrapper(buffer, codec.WireVarint)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeUInt32Value: %v", err)
+	}
+	return value.AsUint32()
+}
+
+// EncodeUInt32Value writes v as an embedded google.protobuf.UInt32Value message.
+func EncodeUInt32Value(ps *molecule.ProtoStream, fieldNumber int, v uint32) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Uint32(valueFieldNumber, v)
+	})
+}
+
+// DecodeBoolValue decodes the google.protobuf.BoolValue message stored in buffer.
+func DecodeBoolValue(buffer *codec.Buffer) (bool, error) {
+	value, err := decodeWrapper(buffer, codec.WireVarint)
+	if err != nil {
+		return false, fmt.Errorf("DecodeBoolValue: %v", err)
+	}
+	return value.AsBool()
+}
+
+// EncodeBoolValue writes v as an embedded google.protobuf.BoolValue message.
+func EncodeBoolValue(ps *molecule.ProtoStream, fieldNumber int, v bool) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Bool(valueFieldNumber, v)
+	})
+}
+
+// DecodeStringValue decodes the google.protobuf.StringValue message stored in buffer.
+// The returned string is an unsafe view over the bytes in buffer.
+func DecodeStringValue(buffer *codec.Buffer) (string, error) {
+	value, err := decodeWrapper(buffer, codec.WireBytes)
+	if err != nil {
+		return "", fmt.Errorf("DecodeStringValue: %v", err)
+	}
+	return value.AsStringUnsafe()
+}
+
+// EncodeStringValue writes v as an embedded google.protobuf.StringValue message.
+func EncodeStringValue(ps *molecule.ProtoStream, fieldNumber int, v string) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.String(valueFieldNumber, v)
+	})
+}
+
+// DecodeBytesValue decodes the google.protobuf.BytesValue message stored in buffer.
+// The returned slice is an unsafe view over the bytes in buffer.
+func DecodeBytesValue(buffer *codec.Buffer) ([]byte, error) {
+	value, err := decodeWrapper(buffer, codec.WireBytes)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeBytesValue: %v", err)
+	}
+	return value.AsBytesUnsafe()
+}
+
+// EncodeBytesValue writes v as an embedded google.protobuf.BytesValue message.
+func EncodeBytesValue(ps *molecule.ProtoStream, fieldNumber int, v []byte) error {
+	return ps.Embedded(fieldNumber, func(ps *molecule.ProtoStream) error {
+		return ps.Bytes(valueFieldNumber, v)
+	})
+}
+
+// decodeWrapper returns the value of the last occurrence of the value field in the wrapper
+// message stored in buffer, or the zero Value if there is none. The value field must be
+// encoded with the given wire type.
+func decodeWrapper(buffer *codec.Buffer, wireType codec.WireType) (molecule.Value, error) {
+	var value, field molecule.Value
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &field)
+		if err != nil {
+			return molecule.Value{}, err
+		}
+		if fieldNum == valueFieldNumber {
+			if err := checkWireType(fieldNum, field, wireType); err != nil {
+				return molecule.Value{}, err
+			}
+			value = field
+		}
+	}
+	return value, nil
+}
+
+// checkWireType returns an error if the field fieldNum, whose value is value, is not
+// encoded with the expected wire type.
+func checkWireType(fieldNum int32, value molecule.Value, expected codec.WireType) error {
+	if value.WireType != expected {
+		return fmt.Errorf("field %d has wire type %d, expected wire type %d", fieldNum, value.WireType, expected)
+	}
+	return nil
+}
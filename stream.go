@@ -2,9 +2,11 @@ package molecule
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"math"
 
+	"github.com/richardartoul/molecule/src/codec"
 	"github.com/richardartoul/molecule/src/protowire"
 )
 
@@ -13,10 +15,16 @@ const (
 	// values, which must first be written to a buffer to determine their
 	// length.  This is not used if BufferFactory is set.
 	defaultBufferSize int = 1024 * 8
+
+	// The maxPackedElementSize is the largest encoded size of a single element
+	// of a packed field, which is a max-size varint.  It is also the largest
+	// size of a key or length prefix.
+	maxPackedElementSize int = 10
 )
 
 // A ProtoStream supports writing protobuf data in a streaming fashion.  Its methods
-// will write their output to the wrapped `io.Writer`.  Zero values are not included.
+// will write their output to the wrapped `io.Writer`.  Zero values are not included,
+// unless EmitZeroValues is set.
 //
 // ProtoStream instances are *not* threadsafe and *not* re-entrant.
 type ProtoStream struct {
@@ -25,14 +33,11 @@ type ProtoStream struct {
 	outputWriter io.Writer
 
 	// The scratchBuffer is a buffer used and re-used for generating output.
-	// Each method should begin by resetting this buffer.
+	// Each method should begin by resetting this buffer.  Packed encodings
+	// flush it in chunks, so it never grows beyond defaultBufferSize
+	// regardless of the number of values.
 	scratchBuffer []byte
 
-	// The scratchArray is a second, very small array used for packed
-	// encodings.  It is large enough to fit two max-size varints (10 bytes
-	// each) without reallocation
-	scratchArray [20]byte
-
 	// The childStream is a ProtoStream used to implement `Embedded`, and
 	// reused for multiple calls.
 	childStream *ProtoStream
@@ -44,6 +49,16 @@ type ProtoStream struct {
 	// override this function to provide pre-initialized buffers of a larger
 	// size, or from a buffer pool, for example.
 	BufferFactory func() []byte
+
+	// The EmitZeroValues flag causes zero values, such as `Int32(f, 0)`,
+	// `String(f, "")` and `Bool(f, false)`, to be written to the stream instead
+	// of being skipped.  This is required for fields with explicit presence,
+	// such as proto2 optional and required fields and proto3 `optional` fields,
+	// and for the elements of repeated fields that are not packed.  It is
+	// inherited by the ProtoStream that is passed to the inner function of
+	// `Embedded`.  Empty slices passed to the *Packed methods are never
+	// written, since repeated fields do not have presence.
+	EmitZeroValues bool
 }
 
 // NewProtoStream creates a new ProtoStream writing to the given Writer.  If the
@@ -67,7 +82,7 @@ func (ps *ProtoStream) Reset(outputWriter io.Writer) {
 
 // Double writes a value of proto type double to the stream.
 func (ps *ProtoStream) Double(fieldNumber int, value float64) error {
-	if value == 0.0 {
+	if value == 0.0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -79,20 +94,19 @@ func (ps *ProtoStream) Double(fieldNumber int, value float64) error {
 // DoublePacked writes a slice of values of proto type double to the stream,
 // in packed form.
 func (ps *ProtoStream) DoublePacked(fieldNumber int, values []float64) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
-	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendFixed64(ps.scratchBuffer, math.Float64bits(value))
-	}
+	return writePacked(ps, fieldNumber, values, len(values)*8, 8, appendDouble)
+}
 
-	return ps.writeScratchAsPacked(fieldNumber)
+// DoubleExpanded writes a slice of values of proto type double to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) DoubleExpanded(fieldNumber int, values []float64) error {
+	return writeExpanded(ps, fieldNumber, protowire.Fixed64Type, values, 8, appendDouble)
 }
 
 // Float writes a value of proto type double to the stream.
 func (ps *ProtoStream) Float(fieldNumber int, value float32) error {
-	if value == 0.0 {
+	if value == 0.0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -104,19 +118,19 @@ func (ps *ProtoStream) Float(fieldNumber int, value float32) error {
 // FloatPacked writes a slice of values of proto type float to the stream,
 // in packed form.
 func (ps *ProtoStream) FloatPacked(fieldNumber int, values []float32) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
-	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendFixed32(ps.scratchBuffer, math.Float32bits(value))
-	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, len(values)*4, 4, appendFloat)
+}
+
+// FloatExpanded writes a slice of values of proto type float to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) FloatExpanded(fieldNumber int, values []float32) error {
+	return writeExpanded(ps, fieldNumber, protowire.Fixed32Type, values, 4, appendFloat)
 }
 
 // Int32 writes a value of proto type int32 to the stream.
 func (ps *ProtoStream) Int32(fieldNumber int, value int32) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -128,19 +142,23 @@ func (ps *ProtoStream) Int32(fieldNumber int, value int32) error {
 // Int32Packed writes a slice of values of proto type int32 to the stream,
 // in packed form.
 func (ps *ProtoStream) Int32Packed(fieldNumber int, values []int32) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
+	size := 0
 	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, uint64(value))
+		size += protowire.SizeVarint(uint64(value))
 	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, size, maxPackedElementSize, appendInt32)
+}
+
+// Int32Expanded writes a slice of values of proto type int32 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Int32Expanded(fieldNumber int, values []int32) error {
+	return writeExpanded(ps, fieldNumber, protowire.VarintType, values, maxPackedElementSize, appendInt32)
 }
 
 // Int64 writes a value of proto type int64 to the stream.
 func (ps *ProtoStream) Int64(fieldNumber int, value int64) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -152,19 +170,23 @@ func (ps *ProtoStream) Int64(fieldNumber int, value int64) error {
 // Int64Packed writes a slice of values of proto type int64 to the stream,
 // in packed form.
 func (ps *ProtoStream) Int64Packed(fieldNumber int, values []int64) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
+	size := 0
 	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, uint64(value))
+		size += protowire.SizeVarint(uint64(value))
 	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, size, maxPackedElementSize, appendInt64)
+}
+
+// Int64Expanded writes a slice of values of proto type int64 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Int64Expanded(fieldNumber int, values []int64) error {
+	return writeExpanded(ps, fieldNumber, protowire.VarintType, values, maxPackedElementSize, appendInt64)
 }
 
 // Uint32 writes a value of proto type uint32 to the stream.
 func (ps *ProtoStream) Uint32(fieldNumber int, value uint32) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -176,19 +198,23 @@ func (ps *ProtoStream) Uint32(fieldNumber int, value uint32) error {
 // Uint32Packed writes a slice of values of proto type uint32 to the stream,
 // in packed form.
 func (ps *ProtoStream) Uint32Packed(fieldNumber int, values []uint32) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
+	size := 0
 	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, uint64(value))
+		size += protowire.SizeVarint(uint64(value))
 	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, size, maxPackedElementSize, appendUint32)
+}
+
+// Uint32Expanded writes a slice of values of proto type uint32 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Uint32Expanded(fieldNumber int, values []uint32) error {
+	return writeExpanded(ps, fieldNumber, protowire.VarintType, values, maxPackedElementSize, appendUint32)
 }
 
 // Uint64 writes a value of proto type uint64 to the stream.
 func (ps *ProtoStream) Uint64(fieldNumber int, value uint64) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -200,19 +226,23 @@ func (ps *ProtoStream) Uint64(fieldNumber int, value uint64) error {
 // Uint64Packed writes a slice of values of proto type uint64 to the stream,
 // in packed form.
 func (ps *ProtoStream) Uint64Packed(fieldNumber int, values []uint64) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
+	size := 0
 	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, value)
+		size += protowire.SizeVarint(value)
 	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, size, maxPackedElementSize, protowire.AppendVarint)
+}
+
+// Uint64Expanded writes a slice of values of proto type uint64 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Uint64Expanded(fieldNumber int, values []uint64) error {
+	return writeExpanded(ps, fieldNumber, protowire.VarintType, values, maxPackedElementSize, protowire.AppendVarint)
 }
 
 // Sint32 writes a value of proto type sint32 to the stream.
 func (ps *ProtoStream) Sint32(fieldNumber int, value int32) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -224,19 +254,23 @@ func (ps *ProtoStream) Sint32(fieldNumber int, value int32) error {
 // Sint32Packed writes a slice of values of proto type sint32 to the stream,
 // in packed form.
 func (ps *ProtoStream) Sint32Packed(fieldNumber int, values []int32) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
+	size := 0
 	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, protowire.EncodeZigZag(int64(value)))
+		size += protowire.SizeVarint(protowire.EncodeZigZag(int64(value)))
 	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, size, maxPackedElementSize, appendSint32)
+}
+
+// Sint32Expanded writes a slice of values of proto type sint32 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Sint32Expanded(fieldNumber int, values []int32) error {
+	return writeExpanded(ps, fieldNumber, protowire.VarintType, values, maxPackedElementSize, appendSint32)
 }
 
 // Sint64 writes a value of proto type sint64 to the stream.
 func (ps *ProtoStream) Sint64(fieldNumber int, value int64) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -248,19 +282,23 @@ func (ps *ProtoStream) Sint64(fieldNumber int, value int64) error {
 // Sint64Packed writes a slice of values of proto type sint64 to the stream,
 // in packed form.
 func (ps *ProtoStream) Sint64Packed(fieldNumber int, values []int64) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
+	size := 0
 	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, protowire.EncodeZigZag(value))
+		size += protowire.SizeVarint(protowire.EncodeZigZag(value))
 	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, size, maxPackedElementSize, appendSint64)
+}
+
+// Sint64Expanded writes a slice of values of proto type sint64 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Sint64Expanded(fieldNumber int, values []int64) error {
+	return writeExpanded(ps, fieldNumber, protowire.VarintType, values, maxPackedElementSize, appendSint64)
 }
 
 // Fixed32 writes a value of proto type fixed32 to the stream.
 func (ps *ProtoStream) Fixed32(fieldNumber int, value uint32) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -272,19 +310,19 @@ func (ps *ProtoStream) Fixed32(fieldNumber int, value uint32) error {
 // Fixed32Packed writes a slice of values of proto type fixed32 to the stream,
 // in packed form.
 func (ps *ProtoStream) Fixed32Packed(fieldNumber int, values []uint32) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
-	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendFixed32(ps.scratchBuffer, value)
-	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, len(values)*4, 4, protowire.AppendFixed32)
+}
+
+// Fixed32Expanded writes a slice of values of proto type fixed32 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Fixed32Expanded(fieldNumber int, values []uint32) error {
+	return writeExpanded(ps, fieldNumber, protowire.Fixed32Type, values, 4, protowire.AppendFixed32)
 }
 
 // Fixed64 writes a value of proto type fixed64 to the stream.
 func (ps *ProtoStream) Fixed64(fieldNumber int, value uint64) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -296,19 +334,19 @@ func (ps *ProtoStream) Fixed64(fieldNumber int, value uint64) error {
 // Fixed64Packed writes a slice of values of proto type fixed64 to the stream,
 // in packed form.
 func (ps *ProtoStream) Fixed64Packed(fieldNumber int, values []uint64) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
-	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendFixed64(ps.scratchBuffer, value)
-	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, len(values)*8, 8, protowire.AppendFixed64)
+}
+
+// Fixed64Expanded writes a slice of values of proto type fixed64 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Fixed64Expanded(fieldNumber int, values []uint64) error {
+	return writeExpanded(ps, fieldNumber, protowire.Fixed64Type, values, 8, protowire.AppendFixed64)
 }
 
 // Sfixed32 writes a value of proto type sfixed32 to the stream.
 func (ps *ProtoStream) Sfixed32(fieldNumber int, value int32) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -320,19 +358,19 @@ func (ps *ProtoStream) Sfixed32(fieldNumber int, value int32) error {
 // Sfixed32Packed writes a slice of values of proto type sfixed32 to the stream,
 // in packed form.
 func (ps *ProtoStream) Sfixed32Packed(fieldNumber int, values []int32) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
-	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendFixed32(ps.scratchBuffer, uint32(value))
-	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, len(values)*4, 4, appendSfixed32)
+}
+
+// Sfixed32Expanded writes a slice of values of proto type sfixed32 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Sfixed32Expanded(fieldNumber int, values []int32) error {
+	return writeExpanded(ps, fieldNumber, protowire.Fixed32Type, values, 4, appendSfixed32)
 }
 
 // Sfixed64 writes a value of proto type sfixed64 to the stream.
 func (ps *ProtoStream) Sfixed64(fieldNumber int, value int64) error {
-	if value == 0 {
+	if value == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -344,19 +382,19 @@ func (ps *ProtoStream) Sfixed64(fieldNumber int, value int64) error {
 // Sfixed64Packed writes a slice of values of proto type sfixed64 to the stream,
 // in packed form.
 func (ps *ProtoStream) Sfixed64Packed(fieldNumber int, values []int64) error {
-	if len(values) == 0 {
-		return nil
-	}
-	ps.scratchBuffer = ps.scratchBuffer[:0]
-	for _, value := range values {
-		ps.scratchBuffer = protowire.AppendFixed64(ps.scratchBuffer, uint64(value))
-	}
-	return ps.writeScratchAsPacked(fieldNumber)
+	return writePacked(ps, fieldNumber, values, len(values)*8, 8, appendSfixed64)
+}
+
+// Sfixed64Expanded writes a slice of values of proto type sfixed64 to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) Sfixed64Expanded(fieldNumber int, values []int64) error {
+	return writeExpanded(ps, fieldNumber, protowire.Fixed64Type, values, 8, appendSfixed64)
 }
 
 // Bool writes a value of proto type bool to the stream.
 func (ps *ProtoStream) Bool(fieldNumber int, value bool) error {
-	if value == false {
+	if value == false && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -369,9 +407,23 @@ func (ps *ProtoStream) Bool(fieldNumber int, value bool) error {
 	return ps.writeScratch()
 }
 
+// BoolPacked writes a slice of values of proto type bool to the stream,
+// in packed form.
+func (ps *ProtoStream) BoolPacked(fieldNumber int, values []bool) error {
+	// Bools are always encoded as a single byte varint.
+	return writePacked(ps, fieldNumber, values, len(values), 1, appendBool)
+}
+
+// BoolExpanded writes a slice of values of proto type bool to the stream,
+// in expanded (non-packed) form, with a key for each value.  Zero values are
+// always written, since they are elements of the repeated field.
+func (ps *ProtoStream) BoolExpanded(fieldNumber int, values []bool) error {
+	return writeExpanded(ps, fieldNumber, protowire.VarintType, values, 1, appendBool)
+}
+
 // String writes a string to the stream.
 func (ps *ProtoStream) String(fieldNumber int, value string) error {
-	if len(value) == 0 {
+	if len(value) == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -387,7 +439,7 @@ func (ps *ProtoStream) String(fieldNumber int, value string) error {
 
 // Bytes writes the given bytes to the stream.
 func (ps *ProtoStream) Bytes(fieldNumber int, value []byte) error {
-	if len(value) == 0 {
+	if len(value) == 0 && !ps.EmitZeroValues {
 		return nil
 	}
 	ps.scratchBuffer = ps.scratchBuffer[:0]
@@ -407,7 +459,15 @@ func (ps *ProtoStream) Bytes(fieldNumber int, value []byte) error {
 //
 // NOTE: if the inner function creates an empty message (such as for a struct
 // at its zero value), that empty message will still be added to the stream.
+//
+// If the stream writes to an `*OutputBuffer`, the given function is called
+// with this ProtoStream instead, and the embedded message is encoded directly
+// into the output without being copied.  See `OutputBuffer` for details.
 func (ps *ProtoStream) Embedded(fieldNumber int, inner func(*ProtoStream) error) error {
+	if out, ok := ps.outputWriter.(*OutputBuffer); ok {
+		return out.embedded(ps, fieldNumber, inner)
+	}
+
 	// Create a new child, writing to a buffer, if one does not already exist.
 	if ps.childStream == nil {
 		ps.childBuffer = bytes.NewBuffer(ps.BufferFactory())
@@ -416,6 +476,7 @@ func (ps *ProtoStream) Embedded(fieldNumber int, inner func(*ProtoStream) error)
 
 	// Write the embedded value using the child, leaving the result in ps.childBuffer.
 	ps.childBuffer.Reset()
+	ps.childStream.EmitZeroValues = ps.EmitZeroValues
 	err := inner(ps.childStream)
 	if err != nil {
 		return err
@@ -435,8 +496,76 @@ func (ps *ProtoStream) Embedded(fieldNumber int, inner func(*ProtoStream) error)
 	return ps.writeAll(ps.childBuffer.Bytes())
 }
 
+// Group writes a group, which is a deprecated proto2 alternative to embedded
+// messages.  It writes the start group key, calls the given function with
+// this ProtoStream to write the fields of the group, and then writes the end
+// group key.  Since groups are delimited rather than length-prefixed, no
+// buffering is required.
+func (ps *ProtoStream) Group(fieldNumber int, inner func(*ProtoStream) error) error {
+	ps.scratchBuffer = ps.scratchBuffer[:0]
+	ps.encodeKeyToScratch(fieldNumber, protowire.StartGroupType)
+	if err := ps.writeScratch(); err != nil {
+		return err
+	}
+
+	if err := inner(ps); err != nil {
+		return err
+	}
+
+	ps.scratchBuffer = ps.scratchBuffer[:0]
+	ps.encodeKeyToScratch(fieldNumber, protowire.EndGroupType)
+	return ps.writeScratch()
+}
+
+// Value writes a value decoded by MessageEach or Next back to the stream with
+// the given field number, using the wire type it was decoded with.  Unlike the
+// typed methods, zero values are always written, since a decoded value was
+// present in its message.  Varints are re-encoded in their minimal form, so
+// use NextField and Write to copy a field's original encoding verbatim.
+func (ps *ProtoStream) Value(fieldNumber int, value Value) error {
+	ps.scratchBuffer = ps.scratchBuffer[:0]
+	switch value.WireType {
+	case codec.WireVarint:
+		ps.encodeKeyToScratch(fieldNumber, protowire.VarintType)
+		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, value.Number)
+	case codec.WireFixed32:
+		ps.encodeKeyToScratch(fieldNumber, protowire.Fixed32Type)
+		ps.scratchBuffer = protowire.AppendFixed32(ps.scratchBuffer, uint32(value.Number))
+	case codec.WireFixed64:
+		ps.encodeKeyToScratch(fieldNumber, protowire.Fixed64Type)
+		ps.scratchBuffer = protowire.AppendFixed64(ps.scratchBuffer, value.Number)
+	case codec.WireBytes:
+		ps.encodeKeyToScratch(fieldNumber, protowire.BytesType)
+		ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, uint64(len(value.Bytes)))
+		if err := ps.writeScratch(); err != nil {
+			return err
+		}
+		return ps.writeAll(value.Bytes)
+	default:
+		return fmt.Errorf("Value: unsupported wireType: %d", value.WireType)
+	}
+	return ps.writeScratch()
+}
+
+// RawField writes a key with the given field number and wire type, followed by
+// the given bytes verbatim.  The bytes must be the complete encoding of a
+// value of that wire type, including the length prefix for codec.WireBytes.
+// It is the callers responsibility to make sure this wont yield a corrupt
+// protobuf stream.
+func (ps *ProtoStream) RawField(fieldNumber int, wireType codec.WireType, value []byte) error {
+	ps.scratchBuffer = ps.scratchBuffer[:0]
+	ps.encodeKeyToScratch(fieldNumber, protowire.Type(wireType))
+	if err := ps.writeScratch(); err != nil {
+		return err
+	}
+	return ps.writeAll(value)
+}
+
 // Write writes raw []byte to the underlying writer. It is the callers
 // responsibility to make sure this wont yield a corrupt protobuf stream.
+//
+// The bytes are copied verbatim, so Write can be used to pass through fields
+// whose original encoding was obtained with NextField.
 func (ps *ProtoStream) Write(raw []byte) (int, error) {
 	return ps.outputWriter.Write(raw)
 }
@@ -446,33 +575,115 @@ func (ps *ProtoStream) writeScratch() error {
 	return ps.writeAll(ps.scratchBuffer)
 }
 
-// writeScratchAsPacked writes the scratch buffer to outputWriter, prefixed with
-// the given key and the length of the scratch buffer.  This is used for packed
-// encodings.
-func (ps *ProtoStream) writeScratchAsPacked(fieldNumber int) error {
-	// The scratch buffer is full of the packed data, but we need to write
-	// the key and size, so we use scratchArray.  We could use a stack allocation
-	// here, but as of writing the go compiler is not smart enough to figure out
-	// that the value does not escape.
-	keysize := ps.scratchArray[:0]
-	keysize = protowire.AppendVarint(keysize, uint64((fieldNumber<<3)|int(protowire.BytesType)))
-	keysize = protowire.AppendVarint(keysize, uint64(len(ps.scratchBuffer)))
+// flushScratch writes out the scratch buffer and resets it.
+func (ps *ProtoStream) flushScratch() error {
+	err := ps.writeScratch()
+	ps.scratchBuffer = ps.scratchBuffer[:0]
+	return err
+}
 
-	// Write the key and length prefix.
-	err := ps.writeAll(keysize)
-	if err != nil {
-		return err
+// writePacked writes values to the stream in packed form, appending each one with
+// appendValue.  size is the encoded size of all of the values, which is written before
+// them, and maxSize is the largest encoded size of a single value.
+func writePacked[T any](ps *ProtoStream, fieldNumber int, values []T, size int, maxSize int, appendValue func([]byte, T) []byte) error {
+	if len(values) == 0 {
+		return nil
 	}
+	ps.scratchBuffer = ps.scratchBuffer[:0]
+	ps.encodePackedHeaderToScratch(fieldNumber, size)
+	return writeChunked(ps, values, 0, maxSize, appendValue)
+}
 
-	// Write out the embedded message.
-	err = ps.writeScratch()
-	if err != nil {
-		return err
+// writeExpanded writes values to the stream in expanded (non-packed) form, appending
+// each one with appendValue after a key with the given wire type.  maxSize is the
+// largest encoded size of a single value, without its key.
+func writeExpanded[T any](ps *ProtoStream, fieldNumber int, wireType protowire.Type, values []T, maxSize int, appendValue func([]byte, T) []byte) error {
+	ps.scratchBuffer = ps.scratchBuffer[:0]
+	key := uint64(fieldNumber)<<3 + uint64(wireType)
+	return writeChunked(ps, values, key, maxSize+maxPackedElementSize, appendValue)
+}
+
+// writeChunked streams values through the scratch buffer, which may already hold the
+// header of a packed field, flushing it after every chunk of packedChunkLen values.
+// If key is not zero it is written before each value.  elementSize is the largest
+// encoded size of a single value, including its key.
+func writeChunked[T any](ps *ProtoStream, values []T, key uint64, elementSize int, appendValue func([]byte, T) []byte) error {
+	for len(values) > 0 {
+		chunk := values[:packedChunkLen(len(values), elementSize)]
+		for _, value := range chunk {
+			if key != 0 {
+				ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, key)
+			}
+			ps.scratchBuffer = appendValue(ps.scratchBuffer, value)
+		}
+		if err := ps.flushScratch(); err != nil {
+			return err
+		}
+		values = values[len(chunk):]
 	}
-
 	return nil
 }
 
+// packedChunkLen returns how many of the remaining n values of a repeated
+// field, each of which encodes to at most elementSize bytes, can be encoded
+// into the scratch buffer before it must be flushed.  Packed and expanded
+// encodings stream their values through the scratch buffer in chunks of this
+// size, so that it never grows beyond defaultBufferSize.
+func packedChunkLen(n int, elementSize int) int {
+	// Leave room for the key and length prefix, which share the first chunk.
+	if limit := (defaultBufferSize - 2*maxPackedElementSize) / elementSize; n > limit {
+		return limit
+	}
+	return n
+}
+
+// The append* functions encode a single value of a repeated field for writePacked
+// and writeExpanded.  Types that protowire can encode directly use its functions.
+
+func appendDouble(b []byte, value float64) []byte {
+	return protowire.AppendFixed64(b, math.Float64bits(value))
+}
+
+func appendFloat(b []byte, value float32) []byte {
+	return protowire.AppendFixed32(b, math.Float32bits(value))
+}
+
+func appendInt32(b []byte, value int32) []byte {
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendInt64(b []byte, value int64) []byte {
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendUint32(b []byte, value uint32) []byte {
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendSint32(b []byte, value int32) []byte {
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(int64(value)))
+}
+
+func appendSint64(b []byte, value int64) []byte {
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(value))
+}
+
+func appendSfixed32(b []byte, value int32) []byte {
+	return protowire.AppendFixed32(b, uint32(value))
+}
+
+func appendSfixed64(b []byte, value int64) []byte {
+	return protowire.AppendFixed64(b, uint64(value))
+}
+
+func appendBool(b []byte, value bool) []byte {
+	var bit uint64
+	if value {
+		bit = 1
+	}
+	return protowire.AppendVarint(b, bit)
+}
+
 // writeAll writes an entire buffer to output.
 func (ps *ProtoStream) writeAll(buf []byte) error {
 	for len(buf) > 0 {
@@ -498,6 +709,14 @@ func (ps *ProtoStream) writeAllString(value string) error {
 	return nil
 }
 
+// encodePackedHeaderToScratch encodes the key and length prefix of a packed
+// field into ps.scratch.  The size of the packed values is always computed up
+// front, so that they can be streamed without first buffering all of them.
+func (ps *ProtoStream) encodePackedHeaderToScratch(fieldNumber int, size int) {
+	ps.encodeKeyToScratch(fieldNumber, protowire.BytesType)
+	ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, uint64(size))
+}
+
 // encodeKeyToScratch encodes a protobuf key into ps.scratch.
 func (ps *ProtoStream) encodeKeyToScratch(fieldNumber int, wireType protowire.Type) {
 	ps.scratchBuffer = protowire.AppendVarint(ps.scratchBuffer, uint64(fieldNumber)<<3+uint64(wireType))
@@ -0,0 +1,231 @@
+package moleculetest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/grpcwire"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// The tests in this file guard the hot paths of molecule against allocations creeping
+// in, which the benchmarks would only reveal to someone reading their output.
+
+func TestRealisticPayloadWalkers(t *testing.T) {
+	for _, payload := range benchPayloads() {
+		var (
+			expected walkResult
+			w        moleculeWalker
+		)
+		require.NoError(t, protowireWalk(payload.encoded, payload.schema, &expected), payload.name)
+		require.NoError(t, w.walk(payload.encoded, payload.schema), payload.name)
+		require.Equal(t, expected, w.result, payload.name)
+		require.NotZero(t, expected.fields, payload.name)
+
+		if codec.Debug {
+			continue
+		}
+		allocs := testing.AllocsPerRun(10, func() {
+			if err := w.walk(payload.encoded, payload.schema); err != nil {
+				panic(err)
+			}
+		})
+		require.Equal(t, float64(0), allocs, payload.name)
+	}
+}
+
+func TestHotPathsDoNotAllocate(t *testing.T) {
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+
+	m := &simple.Simple{
+		Double:              1.5,
+		Float:               2.5,
+		Int32:               -3,
+		Int64:               1 << 40,
+		Uint32:              5,
+		Uint64:              1 << 50,
+		Sint32:              -7,
+		Sint64:              -8,
+		Fixed32:             9,
+		Fixed64:             10,
+		Sfixed32:            -11,
+		Sfixed64:            -12,
+		Bool:                true,
+		String_:             "hello",
+		Bytes:               []byte("world"),
+		RepeatedInt64Packed: []int64{1, 1 << 20, -1},
+	}
+	marshaled, err := proto.Marshal(m)
+	require.NoError(t, err)
+
+	t.Run("MessageEach", func(t *testing.T) {
+		var (
+			buffer = codec.NewBuffer(marshaled)
+			n      int
+		)
+		allocs := testing.AllocsPerRun(100, func() {
+			buffer.Reset(marshaled)
+			err := molecule.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+				n++
+				return true, nil
+			})
+			if err != nil {
+				panic(err)
+			}
+		})
+		require.Equal(t, float64(0), allocs)
+		require.NotZero(t, n)
+	})
+
+	t.Run("Next", func(t *testing.T) {
+		var (
+			buffer = codec.NewBuffer(marshaled)
+			value  molecule.Value
+			sum    int32
+		)
+		allocs := testing.AllocsPerRun(100, func() {
+			buffer.Reset(marshaled)
+			for !buffer.EOF() {
+				fieldNum, err := molecule.Next(buffer, &value)
+				if err != nil {
+					panic(err)
+				}
+				sum += fieldNum
+			}
+		})
+		require.Equal(t, float64(0), allocs)
+		require.NotZero(t, sum)
+	})
+
+	t.Run("PackedRepeatedEach", func(t *testing.T) {
+		var varints, fixed32s, fixed64s []byte
+		for i := 0; i < 100; i++ {
+			varints = protowire.AppendVarint(varints, uint64(i*i*i))
+			fixed32s = protowire.AppendFixed32(fixed32s, uint32(i))
+			fixed64s = protowire.AppendFixed64(fixed64s, uint64(i))
+		}
+		for _, test := range []struct {
+			fieldType codec.FieldType
+			packed    []byte
+		}{
+			{codec.FieldType_INT64, varints},
+			{codec.FieldType_SINT32, varints},
+			{codec.FieldType_BOOL, varints},
+			{codec.FieldType_FIXED32, fixed32s},
+			{codec.FieldType_FLOAT, fixed32s},
+			{codec.FieldType_FIXED64, fixed64s},
+			{codec.FieldType_DOUBLE, fixed64s},
+		} {
+			var (
+				buffer = codec.NewBuffer(test.packed)
+				n      int
+			)
+			allocs := testing.AllocsPerRun(100, func() {
+				buffer.Reset(test.packed)
+				err := molecule.PackedRepeatedEach(buffer, test.fieldType, func(value molecule.Value) (bool, error) {
+					n++
+					return true, nil
+				})
+				if err != nil {
+					panic(err)
+				}
+			})
+			require.Equal(t, float64(0), allocs, test.fieldType)
+			require.Equal(t, 100*101, n, test.fieldType)
+		}
+	})
+
+	t.Run("ProtoStream", func(t *testing.T) {
+		var (
+			output    = bytes.NewBuffer(nil)
+			ps        = molecule.NewProtoStream(output)
+			float64s  = []float64{1, 2, 3}
+			float32s  = []float32{1, 2, 3}
+			int32s    = []int32{-1, 0, 1}
+			int64s    = []int64{-1, 0, 1 << 40}
+			uint32s   = []uint32{0, 1, 1 << 31}
+			uint64s   = []uint64{0, 1, 1 << 63}
+			bools     = []bool{true, false, true}
+			str       = "hello"
+			byteSlice = []byte("world")
+			embedded  = func(ps *molecule.ProtoStream) error { return ps.String(1, str) }
+		)
+		write := func() error {
+			for _, err := range []error{
+				ps.Double(1, 1.5), ps.DoublePacked(2, float64s), ps.DoubleExpanded(3, float64s),
+				ps.Float(4, 2.5), ps.FloatPacked(5, float32s), ps.FloatExpanded(6, float32s),
+				ps.Int32(7, -3), ps.Int32Packed(8, int32s), ps.Int32Expanded(9, int32s),
+				ps.Int64(10, -4), ps.Int64Packed(11, int64s), ps.Int64Expanded(12, int64s),
+				ps.Uint32(13, 5), ps.Uint32Packed(14, uint32s), ps.Uint32Expanded(15, uint32s),
+				ps.Uint64(16, 6), ps.Uint64Packed(17, uint64s), ps.Uint64Expanded(18, uint64s),
+				ps.Sint32(19, -7), ps.Sint32Packed(20, int32s), ps.Sint32Expanded(21, int32s),
+				ps.Sint64(22, -8), ps.Sint64Packed(23, int64s), ps.Sint64Expanded(24, int64s),
+				ps.Fixed32(25, 9), ps.Fixed32Packed(26, uint32s), ps.Fixed32Expanded(27, uint32s),
+				ps.Fixed64(28, 10), ps.Fixed64Packed(29, uint64s), ps.Fixed64Expanded(30, uint64s),
+				ps.Sfixed32(31, -11), ps.Sfixed32Packed(32, int32s), ps.Sfixed32Expanded(33, int32s),
+				ps.Sfixed64(34, -12), ps.Sfixed64Packed(35, int64s), ps.Sfixed64Expanded(36, int64s),
+				ps.Bool(37, true), ps.BoolPacked(38, bools), ps.BoolExpanded(39, bools),
+				ps.String(40, str), ps.Bytes(41, byteSlice), ps.Embedded(42, embedded),
+			} {
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			output.Reset()
+			if err := write(); err != nil {
+				panic(err)
+			}
+		})
+		require.Equal(t, float64(0), allocs)
+
+		// Check that everything was actually written.
+		var n int
+		require.NoError(t, molecule.MessageEach(codec.NewBuffer(output.Bytes()), func(fieldNum int32, value molecule.Value) (bool, error) {
+			n++
+			return true, nil
+		}))
+		require.Equal(t, 13*5+3, n)
+	})
+
+	t.Run("GRPCWireCodec", func(t *testing.T) {
+		var (
+			c         grpcwire.Codec
+			marshaler = grpcwire.MarshalFunc(func(ps *molecule.ProtoStream) error {
+				if err := ps.String(1, "hello"); err != nil {
+					return err
+				}
+				return ps.Embedded(2, func(ps *molecule.ProtoStream) error {
+					return ps.Int64(1, 1<<40)
+				})
+			})
+			data []byte
+		)
+		// Only the returned bytes are allocated.
+		allocs := testing.AllocsPerRun(100, func() {
+			var err error
+			data, err = c.Marshal(marshaler)
+			if err != nil {
+				panic(err)
+			}
+		})
+		require.Equal(t, float64(1), allocs)
+
+		var n int
+		require.NoError(t, molecule.MessageEach(codec.NewBuffer(data), func(fieldNum int32, value molecule.Value) (bool, error) {
+			n++
+			return true, nil
+		}))
+		require.Equal(t, 2, n)
+	})
+}
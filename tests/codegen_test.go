@@ -0,0 +1,524 @@
+package moleculetest
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/codegen"
+	simple "github.com/richardartoul/molecule/src/proto"
+	"github.com/richardartoul/molecule/src/proto/simplemolecule"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// codegenParameter is the plugin parameter used by the gen-proto Makefile target. The
+// molecule code lives in its own package because the examples in the molecule package
+// import the generated protobuf structs.
+const codegenParameter = "Msimple.proto=github.com/richardartoul/molecule/src/proto/simplemolecule;simplemolecule," +
+	"module=github.com/richardartoul/molecule"
+
+// Test that the checked-in generated code is up to date with the generator.
+func TestCodegenUpToDate(t *testing.T) {
+	fd := protodesc.ToFileDescriptorProto(simple.File_simple_proto)
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		Parameter:      proto.String(codegenParameter),
+		FileToGenerate: []string{fd.GetName()},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	require.NoError(t, err)
+	for _, f := range gen.Files {
+		if f.Generate {
+			codegen.GenerateFile(gen, f)
+		}
+	}
+
+	resp := gen.Response()
+	require.Nil(t, resp.Error)
+	require.Len(t, resp.File, 1)
+	require.Equal(t, "src/proto/simplemolecule/simple"+codegen.FileSuffix, resp.File[0].GetName())
+
+	expected, err := os.ReadFile("../" + resp.File[0].GetName())
+	require.NoError(t, err)
+	require.Equal(t, string(expected), resp.File[0].GetContent(), "generated code is stale, run make gen-proto")
+}
+
+// Test that encoders for fields with explicit presence, and for repeated fields that
+// are not packed, write zero values, and that the encoding of repeated fields follows
+// their packed option.
+func TestCodegenEmitZeroValues(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("presence.proto"),
+		Package: proto.String("presence"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/presence")},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Presence"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{
+					Name:           proto.String("optional_int32"),
+					JsonName:       proto.String("optionalInt32"),
+					Number:         proto.Int32(1),
+					Label:          descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:           descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+					OneofIndex:     proto.Int32(0),
+					Proto3Optional: proto.Bool(true),
+				},
+				{
+					Name:     proto.String("implicit_int32"),
+					JsonName: proto.String("implicitInt32"),
+					Number:   proto.Int32(2),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+				},
+				{
+					Name:     proto.String("repeated_string"),
+					JsonName: proto.String("repeatedString"),
+					Number:   proto.Int32(3),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				},
+				{
+					Name:     proto.String("unpacked_int32"),
+					JsonName: proto.String("unpackedInt32"),
+					Number:   proto.Int32(4),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+					Options:  &descriptorpb.FieldOptions{Packed: proto.Bool(false)},
+				},
+			},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_optional_int32")}},
+		}},
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{fd.GetName()},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	require.NoError(t, err)
+	codegen.GenerateFile(gen, gen.FilesByPath[fd.GetName()])
+	resp := gen.Response()
+	require.Nil(t, resp.Error)
+	require.Len(t, resp.File, 1)
+
+	// methodBody returns the body of the named generated encoder method.
+	content := resp.File[0].GetContent()
+	methodBody := func(method string) string {
+		start := strings.Index(content, "func (e PresenceEncoder) "+method+"(")
+		require.True(t, start >= 0, "missing method %s", method)
+		end := strings.Index(content[start:], "\n}\n")
+		return content[start : start+end]
+	}
+	require.Contains(t, methodBody("OptionalInt32"), "e.ps.EmitZeroValues = true")
+	require.Contains(t, methodBody("RepeatedString"), "e.ps.EmitZeroValues = true")
+	require.NotContains(t, methodBody("ImplicitInt32"), "EmitZeroValues")
+	// Repeated fields that are not packed use the expanded encoding.
+	require.Contains(t, methodBody("UnpackedInt32"), "e.ps.Int32Expanded(")
+}
+
+func TestCodegenDecode(t *testing.T) {
+	var (
+		seed      = time.Now().UnixNano()
+		fuzzer    = fuzz.NewWithSeed(seed)
+		numFuzzes = 10000
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+	// Limit slice size to prevent tests from taking too long.
+	fuzzer.NumElements(0, 100)
+
+	var (
+		decoded = &simple.Simple{}
+		visitor = &simplemolecule.SimpleVisitor{
+			Double:   func(v float64) (bool, error) { decoded.Double = v; return true, nil },
+			Float:    func(v float32) (bool, error) { decoded.Float = v; return true, nil },
+			Int32:    func(v int32) (bool, error) { decoded.Int32 = v; return true, nil },
+			Int64:    func(v int64) (bool, error) { decoded.Int64 = v; return true, nil },
+			Uint32:   func(v uint32) (bool, error) { decoded.Uint32 = v; return true, nil },
+			Uint64:   func(v uint64) (bool, error) { decoded.Uint64 = v; return true, nil },
+			Sint32:   func(v int32) (bool, error) { decoded.Sint32 = v; return true, nil },
+			Sint64:   func(v int64) (bool, error) { decoded.Sint64 = v; return true, nil },
+			Fixed32:  func(v uint32) (bool, error) { decoded.Fixed32 = v; return true, nil },
+			Fixed64:  func(v uint64) (bool, error) { decoded.Fixed64 = v; return true, nil },
+			Sfixed32: func(v int32) (bool, error) { decoded.Sfixed32 = v; return true, nil },
+			Sfixed64: func(v int64) (bool, error) { decoded.Sfixed64 = v; return true, nil },
+			Bool:     func(v bool) (bool, error) { decoded.Bool = v; return true, nil },
+			String_:  func(v string) (bool, error) { decoded.String_ = v; return true, nil },
+			Bytes:    func(v []byte) (bool, error) { decoded.Bytes = v; return true, nil },
+			RepeatedInt64Packed: func(v int64) (bool, error) {
+				decoded.RepeatedInt64Packed = append(decoded.RepeatedInt64Packed, v)
+				return true, nil
+			},
+			Unknown: func(fieldNum int32, value molecule.Value) (bool, error) {
+				t.Errorf("unknown field number: %d", fieldNum)
+				return true, nil
+			},
+		}
+	)
+	for i := 0; i < numFuzzes; i++ {
+		m := &simple.Simple{}
+		fuzzer.Fuzz(&m)
+		if m == nil {
+			continue
+		}
+
+		marshaled, err := proto.Marshal(m)
+		require.NoError(t, err)
+
+		decoded.Reset()
+		require.NoError(t, simplemolecule.DecodeSimple(codec.NewBuffer(marshaled), visitor))
+		require.True(t, proto.Equal(m, decoded))
+	}
+}
+
+func TestCodegenDecodeStop(t *testing.T) {
+	marshaled, err := proto.Marshal(&simple.Test{StringField: "a", Int64Field: 1, RepeatedInt64Field: []int64{1, 2, 3}})
+	require.NoError(t, err)
+
+	var values []int64
+	err = simplemolecule.DecodeTest(codec.NewBuffer(marshaled), &simplemolecule.TestVisitor{
+		RepeatedInt64Field: func(v int64) (bool, error) {
+			values = append(values, v)
+			return len(values) < 2, nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2}, values)
+}
+
+func TestCodegenEncode(t *testing.T) {
+	var (
+		output = bytes.NewBuffer(nil)
+		ps     = molecule.NewProtoStream(output)
+		enc    = simplemolecule.NewNestedEncoder(ps)
+	)
+	err := enc.NestedMessage(func(ps *molecule.ProtoStream) error {
+		test := simplemolecule.NewTestEncoder(ps)
+		if err := test.StringField("hello"); err != nil {
+			return err
+		}
+		if err := test.Int64Field(5); err != nil {
+			return err
+		}
+		return test.RepeatedInt64Field([]int64{1, 2, 3})
+	})
+	require.NoError(t, err)
+
+	var res simple.Nested
+	require.NoError(t, proto.Unmarshal(output.Bytes(), &res))
+	require.Equal(t, "hello", res.NestedMessage.StringField)
+	require.Equal(t, int64(5), res.NestedMessage.Int64Field)
+	require.Equal(t, []int64{1, 2, 3}, res.NestedMessage.RepeatedInt64Field)
+
+	// Decode it back with the generated visitors.
+	var str string
+	err = simplemolecule.DecodeNested(codec.NewBuffer(output.Bytes()), &simplemolecule.NestedVisitor{
+		NestedMessage: func(v []byte) (bool, error) {
+			return false, simplemolecule.DecodeTest(codec.NewBuffer(v), &simplemolecule.TestVisitor{
+				StringField: func(v string) (bool, error) {
+					str = v
+					return true, nil
+				},
+			})
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hello", str)
+}
+
+func TestCodegenView(t *testing.T) {
+	var (
+		seed      = time.Now().UnixNano()
+		fuzzer    = fuzz.NewWithSeed(seed)
+		numFuzzes = 10000
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+	// Limit slice size to prevent tests from taking too long.
+	fuzzer.NumElements(0, 100)
+
+	// Reuse the same view for every message to ensure that Reset clears it properly.
+	view := &simplemolecule.SimpleView{}
+	for i := 0; i < numFuzzes; i++ {
+		m := &simple.Simple{}
+		fuzzer.Fuzz(&m)
+		if m == nil {
+			continue
+		}
+
+		marshaled, err := proto.Marshal(m)
+		require.NoError(t, err)
+		require.NoError(t, view.Decode(codec.NewBuffer(marshaled)))
+
+		decoded := &simple.Simple{
+			Double:              view.Double,
+			Float:               view.Float,
+			Int32:               view.Int32,
+			Int64:               view.Int64,
+			Uint32:              view.Uint32,
+			Uint64:              view.Uint64,
+			Sint32:              view.Sint32,
+			Sint64:              view.Sint64,
+			Fixed32:             view.Fixed32,
+			Fixed64:             view.Fixed64,
+			Sfixed32:            view.Sfixed32,
+			Sfixed64:            view.Sfixed64,
+			Bool:                view.Bool,
+			String_:             view.String_,
+			Bytes:               view.Bytes,
+			RepeatedInt64Packed: view.RepeatedInt64Packed,
+		}
+		require.True(t, proto.Equal(m, decoded))
+	}
+}
+
+func TestCodegenViewNested(t *testing.T) {
+	first, err := proto.Marshal(&simple.Nested{NestedMessage: &simple.Test{
+		StringField:        "first",
+		RepeatedInt64Field: []int64{1, 2, 3},
+	}})
+	require.NoError(t, err)
+	second, err := proto.Marshal(&simple.Nested{})
+	require.NoError(t, err)
+
+	view := &simplemolecule.NestedView{}
+	require.NoError(t, view.Decode(codec.NewBuffer(first)))
+	require.NotNil(t, view.NestedMessage)
+	require.Equal(t, "first", view.NestedMessage.StringField)
+	require.Equal(t, []int64{1, 2, 3}, view.NestedMessage.RepeatedInt64Field)
+	nested := view.NestedMessage
+
+	require.NoError(t, view.Decode(codec.NewBuffer(second)))
+	require.Nil(t, view.NestedMessage)
+
+	// The nested view should be reused, and reset, when the field is present again.
+	require.NoError(t, view.Decode(codec.NewBuffer(first)))
+	require.True(t, nested == view.NestedMessage)
+	require.Equal(t, []int64{1, 2, 3}, view.NestedMessage.RepeatedInt64Field)
+
+	// Decoding into a warmed up view should not allocate.
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+	buffer := codec.NewBuffer(first)
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer.Reset(first)
+		if err := view.Decode(buffer); err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+}
+
+// Test that map fields are exposed as the encoded bytes of their entries, and that the
+// map entry messages do not get generated types.
+func TestCodegenMapFields(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("maps.proto"),
+		Package: proto.String("maps"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/maps")},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Maps"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("labels"),
+				JsonName: proto.String("labels"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".maps.Maps.LabelsEntry"),
+			}},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("LabelsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:     proto.String("key"),
+						JsonName: proto.String("key"),
+						Number:   proto.Int32(1),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					},
+					{
+						Name:     proto.String("value"),
+						JsonName: proto.String("value"),
+						Number:   proto.Int32(2),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					},
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+		}},
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{fd.GetName()},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	require.NoError(t, err)
+	codegen.GenerateFile(gen, gen.FilesByPath[fd.GetName()])
+	resp := gen.Response()
+	require.Nil(t, resp.Error)
+	require.Len(t, resp.File, 1)
+
+	content := resp.File[0].GetContent()
+	require.NotContains(t, content, "Maps_LabelsEntry")
+	require.Contains(t, content, "Labels [][]byte")
+	require.Contains(t, content, "m.Labels = append(m.Labels, x)")
+	require.Contains(t, content, "return e.ps.Embedded(Maps_Labels_FieldNumber, inner)")
+}
+
+// Test that fields whose names collide with the generated identifiers are renamed.
+func TestCodegenNameCollisions(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("collisions.proto"),
+		Package: proto.String("collisions"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/collisions")},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Collisions"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{
+					Name:     proto.String("decode"),
+					JsonName: proto.String("decode"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+				},
+				{
+					Name:     proto.String("decode_"),
+					JsonName: proto.String("decode_"),
+					Number:   proto.Int32(2),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+				},
+				{
+					Name:     proto.String("unknown"),
+					JsonName: proto.String("unknown"),
+					Number:   proto.Int32(3),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				},
+				{
+					Name:     proto.String("reset"),
+					JsonName: proto.String("reset"),
+					Number:   proto.Int32(4),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum(),
+				},
+				{
+					Name:     proto.String("merge"),
+					JsonName: proto.String("merge"),
+					Number:   proto.Int32(5),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".collisions.Collisions"),
+				},
+			},
+		}},
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{fd.GetName()},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	require.NoError(t, err)
+	codegen.GenerateFile(gen, gen.FilesByPath[fd.GetName()])
+	resp := gen.Response()
+	require.Nil(t, resp.Error)
+	require.Len(t, resp.File, 1)
+
+	content := resp.File[0].GetContent()
+	// decode takes Decode__, since the Go name of decode_ is Decode_.
+	require.Contains(t, content, "Decode__ func(v int64) (bool, error)")
+	require.Contains(t, content, "Decode_ func(v int64) (bool, error)")
+	require.Contains(t, content, "Unknown_ func(v string) (bool, error)")
+	require.Contains(t, content, "Reset_ func(v bool) (bool, error)")
+	require.Contains(t, content, "Merge_ *CollisionsView")
+	require.Contains(t, content, "merge_ *CollisionsView")
+	require.Contains(t, content, "Collisions_Decode___FieldNumber = 1")
+
+	// No type declares the same member twice.
+	f, err := parser.ParseFile(token.NewFileSet(), resp.File[0].GetName(), content, 0)
+	require.NoError(t, err)
+	members := make(map[string]map[string]bool)
+	declare := func(typ, member string) {
+		if members[typ] == nil {
+			members[typ] = make(map[string]bool)
+		}
+		require.False(t, members[typ][member], "%s.%s is declared twice", typ, member)
+		members[typ][member] = true
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					for _, field := range st.Fields.List {
+						for _, n := range field.Names {
+							declare(ts.Name.Name, n.Name)
+						}
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				continue
+			}
+			recv := decl.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			declare(recv.(*ast.Ident).Name, decl.Name.Name)
+		}
+	}
+}
+
+// Test that generation fails for files with group fields, instead of dropping them.
+func TestCodegenGroupsUnsupported(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("groups.proto"),
+		Package: proto.String("groups"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/groups")},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Groups"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("result"),
+				JsonName: proto.String("result"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_GROUP.Enum(),
+				TypeName: proto.String(".groups.Groups.Result"),
+			}},
+			NestedType: []*descriptorpb.DescriptorProto{{Name: proto.String("Result")}},
+		}},
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{fd.GetName()},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	require.NoError(t, err)
+	require.Nil(t, codegen.GenerateFile(gen, gen.FilesByPath[fd.GetName()]))
+	resp := gen.Response()
+	require.NotNil(t, resp.Error)
+	require.Contains(t, resp.GetError(), "groups.Groups.result")
+	require.Empty(t, resp.File)
+}
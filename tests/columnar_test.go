@@ -0,0 +1,249 @@
+package moleculetest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/columnar"
+	simple "github.com/richardartoul/molecule/src/proto"
+	"github.com/richardartoul/molecule/src/protowire"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestColumnarSimple(t *testing.T) {
+	var (
+		seed        = time.Now().UnixNano()
+		fuzzer      = fuzz.NewWithSeed(seed)
+		numMessages = 1000
+		expected    []*simple.Simple
+		messages    [][]byte
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+	fuzzer.NilChance(0).NumElements(0, 10)
+	for i := 0; i < numMessages; i++ {
+		m := &simple.Simple{}
+		fuzzer.Fuzz(m)
+		marshaled, err := proto.Marshal(m)
+		require.NoError(t, err)
+		expected = append(expected, m)
+		messages = append(messages, marshaled)
+	}
+
+	var (
+		double  = &columnar.Column{Path: []int32{1}, Type: codec.FieldType_DOUBLE}
+		float   = &columnar.Column{Path: []int32{2}, Type: codec.FieldType_FLOAT}
+		int32C  = &columnar.Column{Path: []int32{3}, Type: codec.FieldType_INT32}
+		int64C  = &columnar.Column{Path: []int32{4}, Type: codec.FieldType_INT64}
+		uint64C = &columnar.Column{Path: []int32{6}, Type: codec.FieldType_UINT64}
+		sint32  = &columnar.Column{Path: []int32{7}, Type: codec.FieldType_SINT32}
+		fixed32 = &columnar.Column{Path: []int32{9}, Type: codec.FieldType_FIXED32}
+		sfixed  = &columnar.Column{Path: []int32{12}, Type: codec.FieldType_SFIXED64}
+		boolC   = &columnar.Column{Path: []int32{13}, Type: codec.FieldType_BOOL}
+		str     = &columnar.Column{Path: []int32{14}, Type: codec.FieldType_STRING}
+		byts    = &columnar.Column{Path: []int32{15}, Type: codec.FieldType_BYTES}
+	)
+	d, err := columnar.NewDecoder([]*columnar.Column{double, float, int32C, int64C, uint64C, sint32, fixed32, sfixed, boolC, str, byts})
+	require.NoError(t, err)
+	require.NoError(t, d.Append(messages))
+
+	for i, m := range expected {
+		require.Equal(t, m.Double != 0, double.IsValid(i))
+		require.Equal(t, m.Double, double.Float64s[i])
+		require.Equal(t, float64(m.Float), float.Float64s[i])
+		require.Equal(t, int64(m.Int32), int32C.Int64s[i])
+		require.Equal(t, m.Int64 != 0, int64C.IsValid(i))
+		require.Equal(t, m.Int64, int64C.Int64s[i])
+		require.Equal(t, m.Uint64, uint64C.Uint64s[i])
+		require.Equal(t, int64(m.Sint32), sint32.Int64s[i])
+		require.Equal(t, uint64(m.Fixed32), fixed32.Uint64s[i])
+		require.Equal(t, m.Sfixed64, sfixed.Int64s[i])
+		require.Equal(t, m.Bool, boolC.IsValid(i))
+		require.Equal(t, m.Bool, boolC.Bools[i])
+		require.Equal(t, m.String_ != "", str.IsValid(i))
+		require.Equal(t, m.String_, string(str.Data[str.Offsets[i]:str.Offsets[i+1]]))
+		require.Equal(t, string(m.Bytes), string(byts.Data[byts.Offsets[i]:byts.Offsets[i+1]]))
+	}
+	for _, c := range []*columnar.Column{double, boolC, str} {
+		require.Equal(t, numMessages, c.Len)
+		var nulls int
+		for i := 0; i < c.Len; i++ {
+			if !c.IsValid(i) {
+				nulls++
+			}
+		}
+		require.Equal(t, nulls, c.NullCount)
+	}
+}
+
+func TestColumnarNested(t *testing.T) {
+	var (
+		messages = []*simple.Nested{
+			{NestedMessage: &simple.Test{StringField: "a", Int64Field: 1}},
+			{},
+			{NestedMessage: &simple.Test{Int64Field: 3}},
+		}
+		marshaled [][]byte
+	)
+	for _, m := range messages {
+		b, err := proto.Marshal(m)
+		require.NoError(t, err)
+		marshaled = append(marshaled, b)
+	}
+	// Concatenating messages merges them, and the last occurrence of each field wins.
+	marshaled = append(marshaled, append(append([]byte(nil), marshaled[0]...), marshaled[2]...))
+
+	var (
+		str    = &columnar.Column{Path: []int32{1, 1}, Type: codec.FieldType_STRING}
+		int64C = &columnar.Column{Path: []int32{1, 2}, Type: codec.FieldType_INT64}
+		nested = &columnar.Column{Path: []int32{1}, Type: codec.FieldType_MESSAGE}
+	)
+	d, err := columnar.NewDecoder([]*columnar.Column{str, int64C, nested})
+	require.NoError(t, err)
+	require.NoError(t, d.Append(marshaled))
+
+	require.Equal(t, []int32{0, 1, 1, 1, 2}, str.Offsets)
+	require.Equal(t, "aa", string(str.Data))
+	require.Equal(t, []int64{1, 0, 3, 3}, int64C.Int64s)
+	require.Equal(t, []byte{0b1101}, int64C.Validity)
+	require.Equal(t, 1, int64C.NullCount)
+	require.Equal(t, []bool{true, false, true, true}, []bool{nested.IsValid(0), nested.IsValid(1), nested.IsValid(2), nested.IsValid(3)})
+
+	// Errors don't append a row.
+	require.Error(t, d.AppendMessage([]byte{1<<3 | 2, 2, 2 << 3, 0xff}))
+	require.Equal(t, 4, int64C.Len)
+	require.Equal(t, 4, str.Len)
+
+	// Mismatched wire types are rejected.
+	require.Error(t, d.AppendMessage([]byte{1<<3 | 2, 2, 1 << 3, 1}))
+
+	d.Reset()
+	require.Equal(t, 0, str.Len)
+	require.Empty(t, str.Data)
+
+	_, err = columnar.NewDecoder([]*columnar.Column{{Type: codec.FieldType_INT64}})
+	require.Error(t, err)
+	_, err = columnar.NewDecoder([]*columnar.Column{{Path: []int32{1}}})
+	require.Error(t, err)
+}
+
+// Test that a value that can not be converted to the type of its column does not append
+// a row to any of the columns.
+func TestColumnarConversionErrorsAreAtomic(t *testing.T) {
+	var (
+		int64C = &columnar.Column{Path: []int32{4}, Type: codec.FieldType_INT64}
+		str    = &columnar.Column{Path: []int32{14}, Type: codec.FieldType_STRING}
+		int32C = &columnar.Column{Path: []int32{3}, Type: codec.FieldType_INT32}
+	)
+	d, err := columnar.NewDecoder([]*columnar.Column{int64C, str, int32C})
+	require.NoError(t, err)
+
+	valid, err := proto.Marshal(&simple.Simple{Int64: 1, String_: "a", Int32: 2})
+	require.NoError(t, err)
+	require.NoError(t, d.AppendMessage(valid))
+
+	// The int32 field holds a varint that overflows an int32, after valid values of the
+	// other columns.
+	invalid, err := proto.Marshal(&simple.Simple{Int64: 3, String_: "b"})
+	require.NoError(t, err)
+	invalid = protowire.AppendVarint(invalid, 3<<3|uint64(codec.WireVarint))
+	invalid = protowire.AppendVarint(invalid, 1<<40)
+	require.Error(t, d.AppendMessage(invalid))
+
+	for _, c := range []*columnar.Column{int64C, str, int32C} {
+		require.Equal(t, 1, c.Len)
+		require.Equal(t, 0, c.NullCount)
+		require.Equal(t, []byte{1}, c.Validity)
+	}
+	require.Equal(t, []int64{1}, int64C.Int64s)
+	require.Equal(t, []int32{0, 1}, str.Offsets)
+	require.Equal(t, "a", string(str.Data))
+	require.Equal(t, []int64{2}, int32C.Int64s)
+
+	// The values of the failed message do not leak into the next row.
+	require.NoError(t, d.AppendMessage(nil))
+	for _, c := range []*columnar.Column{int64C, str, int32C} {
+		require.Equal(t, 2, c.Len)
+		require.False(t, c.IsValid(1))
+	}
+	require.Equal(t, []int64{1, 0}, int64C.Int64s)
+	require.Equal(t, []int64{2, 0}, int32C.Int64s)
+}
+
+// Test that appending fails instead of overflowing the offsets of variable-width columns.
+func TestColumnarOffsetsOverflow(t *testing.T) {
+	var (
+		int64C = &columnar.Column{Path: []int32{4}, Type: codec.FieldType_INT64}
+		str    = &columnar.Column{Path: []int32{14}, Type: codec.FieldType_STRING}
+	)
+	d, err := columnar.NewDecoder([]*columnar.Column{int64C, str})
+	require.NoError(t, err)
+
+	// Start from a row whose string takes up all but one of the bytes that the offsets
+	// can address. The memory is never written, so it is not actually used.
+	str.Data = make([]byte, math.MaxInt32-1, math.MaxInt32)
+	str.Offsets = []int32{0, math.MaxInt32 - 1}
+	str.Validity = []byte{1}
+	str.Len = 1
+	int64C.Int64s = []int64{0}
+	int64C.Validity = []byte{1}
+	int64C.Len = 1
+
+	tooLarge, err := proto.Marshal(&simple.Simple{Int64: 1, String_: "ab"})
+	require.NoError(t, err)
+	require.Error(t, d.AppendMessage(tooLarge))
+	for _, c := range []*columnar.Column{int64C, str} {
+		require.Equal(t, 1, c.Len)
+	}
+	require.Equal(t, []int64{0}, int64C.Int64s)
+	require.Equal(t, []int32{0, math.MaxInt32 - 1}, str.Offsets)
+	require.Equal(t, math.MaxInt32-1, len(str.Data))
+
+	fits, err := proto.Marshal(&simple.Simple{Int64: 2, String_: "a"})
+	require.NoError(t, err)
+	require.NoError(t, d.AppendMessage(fits))
+	require.Equal(t, []int64{0, 2}, int64C.Int64s)
+	require.Equal(t, []int32{0, math.MaxInt32 - 1, math.MaxInt32}, str.Offsets)
+
+	// Rows without the field still fit once the data is full.
+	require.NoError(t, d.AppendMessage(nil))
+	require.Error(t, d.AppendMessage(fits))
+	require.Equal(t, 3, str.Len)
+	require.Equal(t, []int32{0, math.MaxInt32 - 1, math.MaxInt32, math.MaxInt32}, str.Offsets)
+}
+
+func TestColumnarDoesNotAllocate(t *testing.T) {
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+
+	var messages [][]byte
+	for i := 0; i < 100; i++ {
+		b, err := proto.Marshal(&simple.Nested{NestedMessage: &simple.Test{StringField: "hello", Int64Field: int64(i)}})
+		require.NoError(t, err)
+		messages = append(messages, b)
+	}
+
+	var (
+		str    = &columnar.Column{Path: []int32{1, 1}, Type: codec.FieldType_STRING}
+		int64C = &columnar.Column{Path: []int32{1, 2}, Type: codec.FieldType_INT64}
+	)
+	d, err := columnar.NewDecoder([]*columnar.Column{str, int64C})
+	require.NoError(t, err)
+	require.NoError(t, d.Append(messages))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		d.Reset()
+		if err := d.Append(messages); err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+}
@@ -0,0 +1,210 @@
+package moleculetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/richardartoul/molecule/src/conformance"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// A conformanceCase is a test case in testdata/conformance, named after the test of the
+// official conformance suite that it mirrors.
+type conformanceCase struct {
+	Name        string `json:"name"`
+	MessageType string `json:"message_type"`
+	Payload     string `json:"payload"`
+	// KnownFailure explains why the testee is expected to disagree with proto.Unmarshal.
+	KnownFailure string `json:"known_failure"`
+	// Expected is the encoded message that the testee is expected to output, and
+	// ExpectedJSON the JSON, when they differ from the message that proto.Unmarshal
+	// decodes from the payload: proto.Unmarshal treats closed proto2 enums as open,
+	// while the testee keeps the values that they do not declare in the unknown fields.
+	Expected     string `json:"expected"`
+	ExpectedJSON string `json:"expected_json"`
+}
+
+// A conformanceResult is a decoded conformance.ConformanceResponse.
+type conformanceResult struct {
+	field   protowire.Number
+	payload []byte
+}
+
+// The fields of conformance.ConformanceResponse.
+const (
+	resultParseError      = 1
+	resultProtobufPayload = 3
+	resultJSONPayload     = 4
+	resultSkipped         = 5
+	resultSerializeError  = 6
+)
+
+// encodeConformanceRequest encodes a conformance.ConformanceRequest with protowire,
+// independently of molecule.
+func encodeConformanceRequest(inputFormat conformance.WireFormat, payload []byte, outputFormat conformance.WireFormat, messageType string) []byte {
+	var b []byte
+	switch inputFormat {
+	case conformance.WireFormatProtobuf:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+	case conformance.WireFormatJSON:
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+	}
+	b = protowire.AppendBytes(b, payload)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(outputFormat))
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	return protowire.AppendString(b, messageType)
+}
+
+// serveConformance sends requests to a testee and returns its responses.
+func serveConformance(t *testing.T, testee *conformance.Testee, requests ...[]byte) []conformanceResult {
+	var input, output bytes.Buffer
+	for _, req := range requests {
+		input.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(req))))
+		input.Write(req)
+	}
+	require.NoError(t, testee.Serve(&input, &output))
+
+	var results []conformanceResult
+	for b := output.Bytes(); len(b) > 0; {
+		require.True(t, len(b) >= 4)
+		size := binary.LittleEndian.Uint32(b)
+		response := b[4 : 4+size]
+		b = b[4+size:]
+
+		num, typ, n := protowire.ConsumeTag(response)
+		require.True(t, n > 0)
+		require.Equal(t, protowire.BytesType, typ)
+		payload, m := protowire.ConsumeBytes(response[n:])
+		require.True(t, m > 0)
+		require.Equal(t, len(response), n+m, "response has more than one field")
+		results = append(results, conformanceResult{field: num, payload: payload})
+	}
+	require.Equal(t, len(requests), len(results))
+	return results
+}
+
+func TestConformanceCases(t *testing.T) {
+	b, err := os.ReadFile("testdata/conformance/binary_cases.json")
+	require.NoError(t, err)
+	var cases []conformanceCase
+	require.NoError(t, json.Unmarshal(b, &cases))
+	require.NotEmpty(t, cases)
+
+	var requests [][]byte
+	for _, c := range cases {
+		payload, err := hex.DecodeString(c.Payload)
+		require.NoError(t, err, c.Name)
+		for _, format := range []conformance.WireFormat{conformance.WireFormatProtobuf, conformance.WireFormatJSON} {
+			requests = append(requests, encodeConformanceRequest(conformance.WireFormatProtobuf, payload, format, c.MessageType))
+		}
+	}
+	results := serveConformance(t, conformance.NewTestee(nil), requests...)
+
+	for i, c := range cases {
+		var (
+			payload, _ = hex.DecodeString(c.Payload)
+			mt, err    = protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(c.MessageType))
+		)
+		require.NoError(t, err, c.Name)
+		expected := mt.New().Interface()
+		expectedErr := proto.Unmarshal(payload, expected)
+
+		protobufResult, jsonResult := results[2*i], results[2*i+1]
+		if c.Expected != "" {
+			require.Equal(t, protowire.Number(resultProtobufPayload), protobufResult.field, "%s: %s", c.Name, protobufResult.payload)
+			require.Equal(t, c.Expected, hex.EncodeToString(protobufResult.payload), c.Name)
+			require.Equal(t, protowire.Number(resultJSONPayload), jsonResult.field, "%s: %s", c.Name, jsonResult.payload)
+			require.JSONEq(t, c.ExpectedJSON, string(jsonResult.payload), c.Name)
+			continue
+		}
+		if c.KnownFailure != "" {
+			// Keep the list of known failures accurate.
+			require.NoError(t, expectedErr, c.Name)
+			require.Equal(t, protowire.Number(resultParseError), protobufResult.field, c.Name)
+			continue
+		}
+		if expectedErr != nil {
+			require.Equal(t, protowire.Number(resultParseError), protobufResult.field, "%s: expected a parse error like %v", c.Name, expectedErr)
+			require.Equal(t, protowire.Number(resultParseError), jsonResult.field, c.Name)
+			continue
+		}
+
+		require.Equal(t, protowire.Number(resultProtobufPayload), protobufResult.field, "%s: %s", c.Name, protobufResult.payload)
+		actual := mt.New().Interface()
+		require.NoError(t, proto.Unmarshal(protobufResult.payload, actual), c.Name)
+		require.True(t, proto.Equal(expected, actual), "%s: expected %v, got %v", c.Name, expected, actual)
+
+		// JSON does not include unknown fields, so compare the messages parsed from it.
+		expectedJSON, err := protojson.Marshal(expected)
+		if err != nil {
+			require.Equal(t, protowire.Number(resultSerializeError), jsonResult.field, c.Name)
+			continue
+		}
+		require.Equal(t, protowire.Number(resultJSONPayload), jsonResult.field, "%s: %s", c.Name, jsonResult.payload)
+		expected, actual = mt.New().Interface(), mt.New().Interface()
+		require.NoError(t, protojson.Unmarshal(expectedJSON, expected), c.Name)
+		require.NoError(t, protojson.Unmarshal(jsonResult.payload, actual), c.Name)
+		require.True(t, proto.Equal(expected, actual), "%s: expected %v, got %v", c.Name, expected, actual)
+	}
+}
+
+func TestConformanceSkipped(t *testing.T) {
+	results := serveConformance(t, conformance.NewTestee(nil),
+		encodeConformanceRequest(conformance.WireFormatProtobuf, nil, conformance.WireFormatProtobuf, "conformance.FailureSet"),
+		encodeConformanceRequest(conformance.WireFormatJSON, []byte("{}"), conformance.WireFormatProtobuf, "simple.Simple"),
+		encodeConformanceRequest(conformance.WireFormatProtobuf, nil, conformance.WireFormatProtobuf, "unknown.Message"),
+		encodeConformanceRequest(conformance.WireFormatProtobuf, nil, conformance.WireFormatTextFormat, "simple.Simple"),
+	)
+	// The failure set is empty.
+	require.Equal(t, conformanceResult{field: resultProtobufPayload, payload: []byte{}}, results[0])
+	for _, result := range results[1:] {
+		require.Equal(t, protowire.Number(resultSkipped), result.field)
+	}
+}
+
+func TestConformanceDescriptorSet(t *testing.T) {
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(simple.File_simple_proto),
+	}}
+	b, err := proto.Marshal(set)
+	require.NoError(t, err)
+	files, err := conformance.LoadDescriptorSet(b)
+	require.NoError(t, err)
+
+	m := &simple.Simple{Int32: 5, String_: "hello", RepeatedInt64Packed: []int64{1, 2}}
+	payload, err := proto.Marshal(m)
+	require.NoError(t, err)
+	results := serveConformance(t, conformance.NewTestee(files),
+		encodeConformanceRequest(conformance.WireFormatProtobuf, payload, conformance.WireFormatProtobuf, "simple.Simple"),
+		encodeConformanceRequest(conformance.WireFormatProtobuf, payload, conformance.WireFormatJSON, "simple.Simple"),
+	)
+
+	require.Equal(t, protowire.Number(resultProtobufPayload), results[0].field)
+	var actual simple.Simple
+	require.NoError(t, proto.Unmarshal(results[0].payload, &actual))
+	require.True(t, proto.Equal(m, &actual))
+
+	// The testee resolves the message with its own registry, not the generated type.
+	require.Equal(t, protowire.Number(resultJSONPayload), results[1].field)
+	fromJSON := dynamicpb.NewMessage(simple.File_simple_proto.Messages().ByName("Simple"))
+	require.NoError(t, protojson.Unmarshal(results[1].payload, fromJSON))
+	require.Equal(t, int32(5), int32(fromJSON.Get(fromJSON.Descriptor().Fields().ByName("int32")).Int()))
+
+	_, err = conformance.LoadDescriptorSet([]byte{0xff})
+	require.Error(t, err)
+}
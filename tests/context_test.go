@@ -0,0 +1,125 @@
+package moleculetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageEachContext(t *testing.T) {
+	var (
+		output = bytes.NewBuffer(nil)
+		ps     = molecule.NewProtoStream(output)
+	)
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, ps.Int64(1, int64(i+1)))
+	}
+	marshaled := output.Bytes()
+
+	// Without cancellation every field is visited.
+	var count int
+	err := molecule.MessageEachContext(context.Background(), codec.NewBuffer(marshaled), func(fieldNum int32, value molecule.Value) (bool, error) {
+		count++
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10000, count)
+
+	// A context that is already done stops iteration before the first field.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = molecule.MessageEachContext(ctx, codec.NewBuffer(marshaled), func(fieldNum int32, value molecule.Value) (bool, error) {
+		return false, errors.New("unexpected call")
+	})
+	var decodeErr *molecule.DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, 0, decodeErr.Offset)
+	require.True(t, errors.Is(err, context.Canceled))
+
+	// Cancelling part way through stops iteration shortly afterwards.
+	ctx, cancel = context.WithCancel(context.Background())
+	count = 0
+	err = molecule.MessageEachContext(ctx, codec.NewBuffer(marshaled), func(fieldNum int32, value molecule.Value) (bool, error) {
+		count++
+		if count == 1000 {
+			cancel()
+		}
+		return true, nil
+	})
+	require.True(t, errors.Is(err, context.Canceled))
+	require.True(t, errors.As(err, &decodeErr))
+	require.Less(t, count, 2000)
+	require.Greater(t, decodeErr.Offset, 0)
+	require.Less(t, decodeErr.Offset, len(marshaled))
+
+	// Decode errors are DecodeErrors too, and callback errors are returned unchanged.
+	err = molecule.MessageEachContext(context.Background(), codec.NewBuffer([]byte{1 << 3, 1, 2 << 3}), func(fieldNum int32, value molecule.Value) (bool, error) {
+		return true, nil
+	})
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, 3, decodeErr.Offset)
+	failed := errors.New("failed")
+	err = molecule.MessageEachContext(context.Background(), codec.NewBuffer(marshaled), func(fieldNum int32, value molecule.Value) (bool, error) {
+		return false, failed
+	})
+	require.Equal(t, failed, err)
+}
+
+func TestPackedRepeatedEachContext(t *testing.T) {
+	var (
+		output = bytes.NewBuffer(nil)
+		ps     = molecule.NewProtoStream(output)
+		values = make([]int64, 100000)
+	)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	require.NoError(t, ps.Int64Packed(1, values))
+	var packed []byte
+	err := molecule.MessageEach(codec.NewBuffer(output.Bytes()), func(fieldNum int32, value molecule.Value) (bool, error) {
+		packed = value.Bytes
+		return false, nil
+	})
+	require.NoError(t, err)
+
+	var decoded []int64
+	err = molecule.PackedRepeatedEachContext(context.Background(), codec.NewBuffer(packed), codec.FieldType_INT64, func(value molecule.Value) (bool, error) {
+		v, err := value.AsInt64()
+		decoded = append(decoded, v)
+		return true, err
+	})
+	require.NoError(t, err)
+	require.Equal(t, values, decoded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int
+	err = molecule.PackedRepeatedEachContext(ctx, codec.NewBuffer(packed), codec.FieldType_INT64, func(value molecule.Value) (bool, error) {
+		count++
+		if count == 1000 {
+			cancel()
+		}
+		return true, nil
+	})
+	var decodeErr *molecule.DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Less(t, count, 2000)
+	require.Greater(t, decodeErr.Offset, 0)
+
+	// Callback errors are returned unchanged, and decode errors are DecodeErrors.
+	failed := errors.New("failed")
+	err = molecule.PackedRepeatedEachContext(context.Background(), codec.NewBuffer(packed), codec.FieldType_INT64, func(value molecule.Value) (bool, error) {
+		return false, failed
+	})
+	require.Equal(t, failed, err)
+	err = molecule.PackedRepeatedEachContext(context.Background(), codec.NewBuffer([]byte{0x80}), codec.FieldType_INT64, func(value molecule.Value) (bool, error) {
+		return true, nil
+	})
+	require.True(t, errors.As(err, &decodeErr))
+}
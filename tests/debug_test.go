@@ -0,0 +1,174 @@
+//go:build moleculedebug
+
+package moleculetest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/columnar"
+	simple "github.com/richardartoul/molecule/src/proto"
+	"github.com/richardartoul/molecule/src/proto/simplemolecule"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// decodeStringField returns the value of the string field of the simple.Test message
+// stored in buffer.
+func decodeStringField(t *testing.T, buffer *codec.Buffer) molecule.Value {
+	var strVal molecule.Value
+	err := molecule.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+		if fieldNum == 1 {
+			strVal = value
+		}
+		return true, nil
+	})
+	require.NoError(t, err)
+	return strVal
+}
+
+// Test that unsafe views are invalidated when the buffer they alias is reused.
+func TestDebugStaleViewSameArray(t *testing.T) {
+	require.True(t, codec.Debug)
+
+	marshaled, err := proto.Marshal(&simple.Test{StringField: "hello"})
+	require.NoError(t, err)
+
+	buffer := codec.NewBuffer(marshaled)
+	strVal := decodeStringField(t, buffer)
+	str, err := strVal.AsStringUnsafe()
+	require.NoError(t, err)
+	require.Equal(t, "hello", str)
+
+	// Reusing the same backing array can not be poisoned, but the views must be rejected.
+	buffer.Reset(marshaled)
+	_, err = strVal.AsStringUnsafe()
+	require.Equal(t, codec.ErrStaleView, err)
+	_, err = strVal.AsBytesUnsafe()
+	require.Equal(t, codec.ErrStaleView, err)
+
+	// Safe copies are still allowed, and views from the new generation are valid.
+	_, err = strVal.AsStringSafe()
+	require.NoError(t, err)
+	strVal = decodeStringField(t, buffer)
+	str, err = strVal.AsStringUnsafe()
+	require.NoError(t, err)
+	require.Equal(t, "hello", str)
+}
+
+// Test that resetting a buffer poisons its previous input by default.
+func TestDebugPoison(t *testing.T) {
+	first, err := proto.Marshal(&simple.Test{StringField: "first"})
+	require.NoError(t, err)
+	second, err := proto.Marshal(&simple.Test{StringField: "second"})
+	require.NoError(t, err)
+
+	buffer := codec.NewBuffer(first)
+	strVal := decodeStringField(t, buffer)
+
+	buffer.Reset(second)
+	require.Equal(t, bytes.Repeat([]byte{0xdb}, len("first")), strVal.Bytes)
+	_, err = strVal.AsStringUnsafe()
+	require.Equal(t, codec.ErrStaleView, err)
+
+	strVal = decodeStringField(t, buffer)
+	str, err := strVal.AsStringUnsafe()
+	require.NoError(t, err)
+	require.Equal(t, "second", str)
+}
+
+// Test that Reset does not modify the previous input of buffers that opted out of
+// poisoning, since it may still be read by the caller, or belong to another buffer.
+func TestDebugPoisonOptOut(t *testing.T) {
+	marshaled, err := proto.Marshal(&simple.Simple{String_: "first", Bytes: []byte("second")})
+	require.NoError(t, err)
+	original := append([]byte(nil), marshaled...)
+
+	var views [][]byte
+	buffer := codec.NewBuffer(marshaled)
+	buffer.PoisonOnReset(false)
+	err = molecule.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+		views = append(views, value.Bytes)
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, views, 2)
+
+	var inner codec.Buffer
+	inner.PoisonOnReset(false)
+	inner.Reset(views[0])
+	inner.Reset(views[1])
+	inner.Reset(nil)
+	buffer.Reset(nil)
+	require.Equal(t, original, marshaled)
+	require.Equal(t, "first", string(views[0]))
+	require.Equal(t, "second", string(views[1]))
+
+	// The views are still rejected, even though their bytes are intact.
+	require.Equal(t, codec.ErrStaleView, codec.CheckView(views[0]))
+}
+
+// Test that views handed out by several buffers decoding the same input are only stale
+// once all of the buffers have been Reset.
+func TestDebugSharedInput(t *testing.T) {
+	marshaled, err := proto.Marshal(&simple.Test{StringField: "hello"})
+	require.NoError(t, err)
+
+	// The input is shared, so the buffers must opt out of poisoning it.
+	var (
+		first  = codec.NewBuffer(marshaled)
+		second = codec.NewBuffer(marshaled)
+	)
+	first.PoisonOnReset(false)
+	second.PoisonOnReset(false)
+	strVal := decodeStringField(t, first)
+	require.Equal(t, strVal.Bytes, decodeStringField(t, second).Bytes)
+
+	first.Reset(nil)
+	_, err = strVal.AsStringUnsafe()
+	require.NoError(t, err)
+
+	second.Reset(nil)
+	_, err = strVal.AsStringUnsafe()
+	require.Equal(t, codec.ErrStaleView, err)
+	require.Equal(t, "hello", string(strVal.Bytes))
+
+	// Slices that were never handed out by a buffer are not tracked.
+	require.NoError(t, codec.CheckView([]byte("hello")))
+}
+
+// Test that the buffers that molecule uses internally do not poison the inputs of the
+// caller, which they only borrow.
+func TestDebugInternalBuffersDoNotPoison(t *testing.T) {
+	// Two occurrences of the same packed field make the generated decoder reuse its buffer.
+	packed, err := proto.Marshal(&simple.Simple{RepeatedInt64Packed: []int64{1, 2}})
+	require.NoError(t, err)
+	marshaled := append(append([]byte(nil), packed...), packed...)
+	original := append([]byte(nil), marshaled...)
+
+	var sum int64
+	err = simplemolecule.DecodeSimple(codec.NewBuffer(marshaled), &simplemolecule.SimpleVisitor{
+		RepeatedInt64Packed: func(v int64) (bool, error) {
+			sum += v
+			return true, nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(6), sum)
+	require.Equal(t, original, marshaled)
+
+	// The columnar decoder reuses its buffers across messages.
+	nested, err := proto.Marshal(&simple.Nested{NestedMessage: &simple.Test{StringField: "hello"}})
+	require.NoError(t, err)
+	messages := [][]byte{nested, append([]byte(nil), nested...)}
+	str := &columnar.Column{Path: []int32{1, 1}, Type: codec.FieldType_STRING}
+	d, err := columnar.NewDecoder([]*columnar.Column{str})
+	require.NoError(t, err)
+	require.NoError(t, d.Append(messages))
+	require.NoError(t, d.Append(messages))
+	require.Equal(t, messages[1], messages[0])
+	require.Equal(t, "hellohellohellohello", string(str.Data))
+}
@@ -0,0 +1,157 @@
+package moleculetest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/encode"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// appendSimple encodes m with the encode package.
+func appendSimple(b []byte, m *simple.Simple) []byte {
+	b = encode.AppendDouble(b, fieldDouble, m.Double)
+	b = encode.AppendFloat(b, fieldFloat, m.Float)
+	b = encode.AppendInt32(b, fieldInt32, m.Int32)
+	b = encode.AppendInt64(b, fieldInt64, m.Int64)
+	b = encode.AppendUint32(b, fieldUint32, m.Uint32)
+	b = encode.AppendUint64(b, fieldUint64, m.Uint64)
+	b = encode.AppendSint32(b, fieldSint32, m.Sint32)
+	b = encode.AppendSint64(b, fieldSint64, m.Sint64)
+	b = encode.AppendFixed32(b, fieldFixed32, m.Fixed32)
+	b = encode.AppendFixed64(b, fieldFixed64, m.Fixed64)
+	b = encode.AppendSfixed32(b, fieldSfixed32, m.Sfixed32)
+	b = encode.AppendSfixed64(b, fieldSfixed64, m.Sfixed64)
+	b = encode.AppendBool(b, fieldBool, m.Bool)
+	b = encode.AppendString(b, fieldString, m.String_)
+	b = encode.AppendBytes(b, fieldBytes, m.Bytes)
+	return encode.AppendInt64Packed(b, fieldRepeatedInt64Packed, m.RepeatedInt64Packed)
+}
+
+// Test that messages built with the encode package decode properly using the
+// generated protobuf code, and are identical to those built with ProtoStream.
+func TestEncodeFuzzing(t *testing.T) {
+	var (
+		seed      = time.Now().UnixNano()
+		fuzzer    = fuzz.NewWithSeed(seed)
+		numFuzzes = 10000
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+	// Limit slice size to prevent tests from taking too long.
+	fuzzer.NumElements(0, 100)
+
+	var (
+		b      []byte
+		output = bytes.NewBuffer(nil)
+		ps     = molecule.NewProtoStream(output)
+	)
+	for i := 0; i < numFuzzes; i++ {
+		m := &simple.Simple{}
+		fuzzer.Fuzz(&m)
+		if m == nil {
+			continue
+		}
+
+		b = appendSimple(b[:0], m)
+		var decoded simple.Simple
+		require.NoError(t, proto.Unmarshal(b, &decoded))
+		require.True(t, proto.Equal(m, &decoded))
+
+		output.Reset()
+		require.NoError(t, ps.Double(fieldDouble, m.Double))
+		require.NoError(t, ps.Float(fieldFloat, m.Float))
+		require.NoError(t, ps.Int32(fieldInt32, m.Int32))
+		require.NoError(t, ps.Int64(fieldInt64, m.Int64))
+		require.NoError(t, ps.Uint32(fieldUint32, m.Uint32))
+		require.NoError(t, ps.Uint64(fieldUint64, m.Uint64))
+		require.NoError(t, ps.Sint32(fieldSint32, m.Sint32))
+		require.NoError(t, ps.Sint64(fieldSint64, m.Sint64))
+		require.NoError(t, ps.Fixed32(fieldFixed32, m.Fixed32))
+		require.NoError(t, ps.Fixed64(fieldFixed64, m.Fixed64))
+		require.NoError(t, ps.Sfixed32(fieldSfixed32, m.Sfixed32))
+		require.NoError(t, ps.Sfixed64(fieldSfixed64, m.Sfixed64))
+		require.NoError(t, ps.Bool(fieldBool, m.Bool))
+		require.NoError(t, ps.String(fieldString, m.String_))
+		require.NoError(t, ps.Bytes(fieldBytes, m.Bytes))
+		require.NoError(t, ps.Int64Packed(fieldRepeatedInt64Packed, m.RepeatedInt64Packed))
+		require.Equal(t, output.Bytes(), b)
+	}
+}
+
+func TestEncodePacked(t *testing.T) {
+	var (
+		output = bytes.NewBuffer(nil)
+		ps     = molecule.NewProtoStream(output)
+		b      []byte
+	)
+	require.NoError(t, ps.DoublePacked(1, []float64{3.14, -1}))
+	require.NoError(t, ps.FloatPacked(2, []float32{3.14, -1}))
+	require.NoError(t, ps.Int32Packed(3, []int32{-12, 12, 0}))
+	require.NoError(t, ps.Int64Packed(4, []int64{-12, 12, 1 << 40}))
+	require.NoError(t, ps.Uint32Packed(5, []uint32{1, 300, 1 << 31}))
+	require.NoError(t, ps.Uint64Packed(6, []uint64{1, 300, 1 << 63}))
+	require.NoError(t, ps.Sint32Packed(7, []int32{-12, 12, -1 << 31}))
+	require.NoError(t, ps.Sint64Packed(8, []int64{-12, 12, -1 << 63}))
+	require.NoError(t, ps.Fixed32Packed(9, []uint32{12, 13}))
+	require.NoError(t, ps.Fixed64Packed(10, []uint64{12, 13}))
+	require.NoError(t, ps.Sfixed32Packed(11, []int32{12, -13}))
+	require.NoError(t, ps.Sfixed64Packed(12, []int64{12, -13}))
+	require.NoError(t, ps.BoolPacked(13, []bool{true, false, true}))
+
+	b = encode.AppendDoublePacked(b, 1, []float64{3.14, -1})
+	b = encode.AppendFloatPacked(b, 2, []float32{3.14, -1})
+	b = encode.AppendInt32Packed(b, 3, []int32{-12, 12, 0})
+	b = encode.AppendInt64Packed(b, 4, []int64{-12, 12, 1 << 40})
+	b = encode.AppendUint32Packed(b, 5, []uint32{1, 300, 1 << 31})
+	b = encode.AppendUint64Packed(b, 6, []uint64{1, 300, 1 << 63})
+	b = encode.AppendSint32Packed(b, 7, []int32{-12, 12, -1 << 31})
+	b = encode.AppendSint64Packed(b, 8, []int64{-12, 12, -1 << 63})
+	b = encode.AppendFixed32Packed(b, 9, []uint32{12, 13})
+	b = encode.AppendFixed64Packed(b, 10, []uint64{12, 13})
+	b = encode.AppendSfixed32Packed(b, 11, []int32{12, -13})
+	b = encode.AppendSfixed64Packed(b, 12, []int64{12, -13})
+	b = encode.AppendBoolPacked(b, 13, []bool{true, false, true})
+	require.Equal(t, output.Bytes(), b)
+
+	// Empty slices are not written.
+	require.Len(t, encode.AppendInt64Packed(nil, 4, nil), 0)
+	require.Len(t, encode.AppendBoolPacked(nil, 13, []bool{}), 0)
+}
+
+func TestEncodeEmbedded(t *testing.T) {
+	// values copied from the .proto file
+	const fieldStringField = 1
+	const fieldNestedMessage = 1
+
+	for _, size := range []int{0, 1, 127, 128, 16383, 16384, 100000} {
+		padding := string(bytes.Repeat([]byte("x"), size))
+
+		output := bytes.NewBuffer(nil)
+		ps := molecule.NewProtoStream(output)
+		require.NoError(t, ps.Embedded(fieldNestedMessage, func(ps *molecule.ProtoStream) error {
+			if err := ps.Embedded(fieldNestedMessage, func(ps *molecule.ProtoStream) error {
+				return ps.String(fieldStringField, padding)
+			}); err != nil {
+				return err
+			}
+			return ps.Int64(2, int64(size))
+		}))
+
+		b, outer := encode.AppendEmbeddedStart(nil, fieldNestedMessage)
+		b, inner := encode.AppendEmbeddedStart(b, fieldNestedMessage)
+		b = encode.AppendString(b, fieldStringField, padding)
+		b = encode.AppendEmbeddedEnd(b, inner)
+		b = encode.AppendInt64(b, 2, int64(size))
+		b = encode.AppendEmbeddedEnd(b, outer)
+		require.True(t, bytes.Equal(output.Bytes(), b), "size: %d", size)
+	}
+}
@@ -0,0 +1,315 @@
+package moleculetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The fuzz targets in this file compare molecule with protowire, from the official
+// protobuf library, which is the reference implementation of the wire format. Their seed
+// corpus is in testdata/fuzz, and they run as regular tests over it with `go test`. Run
+// one with `go test -fuzz FuzzMessageEach ./tests` to fuzz it.
+
+// A fuzzField is a field decoded by molecule or protowire.
+type fuzzField struct {
+	num      int32
+	wireType codec.WireType
+	number   uint64
+	bytes    string
+}
+
+// consumeValue decodes a single value of type typ from b with protowire, and returns it
+// and its length, or a negative length if it is invalid.
+func consumeValue(typ protowire.Type, b []byte) (number uint64, bytes string, n int) {
+	switch typ {
+	case protowire.VarintType:
+		number, n = protowire.ConsumeVarint(b)
+	case protowire.Fixed32Type:
+		var v uint32
+		v, n = protowire.ConsumeFixed32(b)
+		number = uint64(v)
+	case protowire.Fixed64Type:
+		number, n = protowire.ConsumeFixed64(b)
+	case protowire.BytesType:
+		var v []byte
+		v, n = protowire.ConsumeBytes(b)
+		bytes = string(v)
+	default:
+		n = -1
+	}
+	return number, bytes, n
+}
+
+func FuzzMessageEach(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var actual []fuzzField
+		err := molecule.MessageEach(codec.NewBuffer(data), func(fieldNum int32, value molecule.Value) (bool, error) {
+			actual = append(actual, fuzzField{
+				num:      fieldNum,
+				wireType: value.WireType,
+				number:   value.Number,
+				bytes:    string(value.Bytes),
+			})
+			return true, nil
+		})
+
+		var (
+			expected    []fuzzField
+			expectedErr bool
+		)
+		for b := data; len(b) > 0; {
+			num, typ, n := protowire.ConsumeTag(b)
+			// molecule does not support groups.
+			if n < 0 || typ == protowire.StartGroupType || typ == protowire.EndGroupType {
+				expectedErr = true
+				break
+			}
+			// ConsumeFieldValue validates the value like molecule does, and
+			// consumeValue decodes it.
+			if protowire.ConsumeFieldValue(num, typ, b[n:]) < 0 {
+				expectedErr = true
+				break
+			}
+			number, bytes, m := consumeValue(typ, b[n:])
+			expected = append(expected, fuzzField{
+				num:      int32(num),
+				wireType: codec.WireType(typ),
+				number:   number,
+				bytes:    bytes,
+			})
+			b = b[n+m:]
+		}
+
+		require.Equal(t, expectedErr, err != nil, "error: %v", err)
+		require.Equal(t, expected, actual)
+	})
+}
+
+func FuzzPackedRepeatedEach(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte, fieldType uint8) {
+		ft := codec.FieldType(fieldType % (uint8(codec.FieldType_SINT64) + 1))
+
+		var (
+			typ         protowire.Type
+			unsupported bool
+			expected    []fuzzField
+			expectedErr bool
+			actual      []fuzzField
+		)
+		switch ft {
+		case codec.FieldType_INT32, codec.FieldType_INT64, codec.FieldType_UINT32, codec.FieldType_UINT64,
+			codec.FieldType_SINT32, codec.FieldType_SINT64, codec.FieldType_BOOL, codec.FieldType_ENUM:
+			typ = protowire.VarintType
+		case codec.FieldType_FIXED32, codec.FieldType_SFIXED32, codec.FieldType_FLOAT:
+			typ = protowire.Fixed32Type
+		case codec.FieldType_FIXED64, codec.FieldType_SFIXED64, codec.FieldType_DOUBLE:
+			typ = protowire.Fixed64Type
+		case codec.FieldType_STRING, codec.FieldType_MESSAGE, codec.FieldType_BYTES:
+			typ = protowire.BytesType
+		default:
+			unsupported = true
+		}
+
+		err := molecule.PackedRepeatedEach(codec.NewBuffer(data), ft, func(value molecule.Value) (bool, error) {
+			actual = append(actual, fuzzField{wireType: value.WireType, number: value.Number, bytes: string(value.Bytes)})
+			return true, nil
+		})
+		if unsupported {
+			require.Error(t, err)
+			return
+		}
+
+		for b := data; len(b) > 0; {
+			number, bytes, n := consumeValue(typ, b)
+			if n < 0 {
+				expectedErr = true
+				break
+			}
+			expected = append(expected, fuzzField{wireType: codec.WireType(typ), number: number, bytes: bytes})
+			b = b[n:]
+		}
+		require.Equal(t, expectedErr, err != nil, "error: %v", err)
+		require.Equal(t, expected, actual)
+
+		// The bulk decoders agree with PackedRepeatedEach for the types that they
+		// don't range check.
+		var (
+			buffer  = codec.NewBuffer(data)
+			numbers []uint64
+		)
+		switch ft {
+		case codec.FieldType_INT64:
+			var values []int64
+			values, err = molecule.DecodePackedInt64(buffer, nil)
+			for _, v := range values {
+				numbers = append(numbers, uint64(v))
+			}
+		case codec.FieldType_UINT64:
+			numbers, err = molecule.DecodePackedUint64(buffer, nil)
+		case codec.FieldType_FIXED32:
+			var values []uint32
+			values, err = molecule.DecodePackedFixed32(buffer, nil)
+			for _, v := range values {
+				numbers = append(numbers, uint64(v))
+			}
+		case codec.FieldType_FIXED64:
+			numbers, err = molecule.DecodePackedFixed64(buffer, nil)
+		default:
+			return
+		}
+		require.Equal(t, expectedErr, err != nil, "error: %v", err)
+		if !expectedErr {
+			require.Equal(t, len(actual), len(numbers))
+			for i := range numbers {
+				require.Equal(t, actual[i].number, numbers[i])
+			}
+		}
+	})
+}
+
+func FuzzDecodeVarint(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buffer := codec.NewBuffer(data)
+		v, err := buffer.DecodeVarint()
+
+		expected, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			require.Error(t, err)
+			return
+		}
+		require.NoError(t, err)
+		require.Equal(t, expected, v)
+		require.Equal(t, len(data)-n, buffer.Len())
+	})
+}
+
+func FuzzProtoStream(f *testing.F) {
+	f.Fuzz(func(t *testing.T, fieldNumber int, i64 int64, u64 uint64, d float64, s string, b []byte, packed []byte) {
+		// Pick a valid field number that leaves room for the fields after it.
+		const numFields = 9
+		if fieldNumber < 0 {
+			fieldNumber = -(fieldNumber + 1)
+		}
+		fieldNumber = fieldNumber%(int(protowire.MaxValidNumber)-numFields) + 1
+
+		var int64s []int64
+		for len(packed) >= 8 {
+			int64s = append(int64s, int64(binary.LittleEndian.Uint64(packed)))
+			packed = packed[8:]
+		}
+
+		var (
+			output = bytes.NewBuffer(nil)
+			ps     = molecule.NewProtoStream(output)
+		)
+		require.NoError(t, ps.Int64(fieldNumber, i64))
+		require.NoError(t, ps.Sint64(fieldNumber+1, i64))
+		require.NoError(t, ps.Uint64(fieldNumber+2, u64))
+		require.NoError(t, ps.Fixed32(fieldNumber+3, uint32(u64)))
+		require.NoError(t, ps.Double(fieldNumber+4, d))
+		require.NoError(t, ps.String(fieldNumber+5, s))
+		require.NoError(t, ps.Bytes(fieldNumber+6, b))
+		require.NoError(t, ps.Int64Packed(fieldNumber+7, int64s))
+		require.NoError(t, ps.Embedded(fieldNumber+8, func(ps *molecule.ProtoStream) error {
+			return ps.Int64(fieldNumber, i64)
+		}))
+
+		// Build the same message with protowire, skipping zero values like
+		// ProtoStream does.
+		var (
+			expected []byte
+			num      = protowire.Number(fieldNumber)
+		)
+		appendVarint := func(num protowire.Number, v uint64) {
+			if v != 0 {
+				expected = protowire.AppendTag(expected, num, protowire.VarintType)
+				expected = protowire.AppendVarint(expected, v)
+			}
+		}
+		appendVarint(num, uint64(i64))
+		appendVarint(num+1, protowire.EncodeZigZag(i64))
+		appendVarint(num+2, u64)
+		if uint32(u64) != 0 {
+			expected = protowire.AppendTag(expected, num+3, protowire.Fixed32Type)
+			expected = protowire.AppendFixed32(expected, uint32(u64))
+		}
+		if d != 0 {
+			expected = protowire.AppendTag(expected, num+4, protowire.Fixed64Type)
+			expected = protowire.AppendFixed64(expected, math.Float64bits(d))
+		}
+		if s != "" {
+			expected = protowire.AppendTag(expected, num+5, protowire.BytesType)
+			expected = protowire.AppendString(expected, s)
+		}
+		if len(b) > 0 {
+			expected = protowire.AppendTag(expected, num+6, protowire.BytesType)
+			expected = protowire.AppendBytes(expected, b)
+		}
+		if len(int64s) > 0 {
+			var payload []byte
+			for _, v := range int64s {
+				payload = protowire.AppendVarint(payload, uint64(v))
+			}
+			expected = protowire.AppendTag(expected, num+7, protowire.BytesType)
+			expected = protowire.AppendBytes(expected, payload)
+		}
+		var embedded []byte
+		if i64 != 0 {
+			embedded = protowire.AppendTag(embedded, num, protowire.VarintType)
+			embedded = protowire.AppendVarint(embedded, uint64(i64))
+		}
+		expected = protowire.AppendTag(expected, num+8, protowire.BytesType)
+		expected = protowire.AppendBytes(expected, embedded)
+
+		require.Equal(t, expected, output.Bytes())
+
+		// Round trip the values through molecule.
+		err := molecule.MessageEach(codec.NewBuffer(output.Bytes()), func(fieldNum int32, value molecule.Value) (bool, error) {
+			var err error
+			switch int(fieldNum) - fieldNumber {
+			case 0:
+				var v int64
+				v, err = value.AsInt64()
+				require.Equal(t, i64, v)
+			case 1:
+				var v int64
+				v, err = value.AsSint64()
+				require.Equal(t, i64, v)
+			case 2:
+				var v uint64
+				v, err = value.AsUint64()
+				require.Equal(t, u64, v)
+			case 3:
+				var v uint32
+				v, err = value.AsFixed32()
+				require.Equal(t, uint32(u64), v)
+			case 4:
+				var v float64
+				v, err = value.AsDouble()
+				require.Equal(t, math.Float64bits(d), math.Float64bits(v))
+			case 5:
+				var v string
+				v, err = value.AsStringUnsafe()
+				require.Equal(t, s, v)
+			case 6:
+				var v []byte
+				v, err = value.AsBytesUnsafe()
+				require.Equal(t, string(b), string(v))
+			case 7:
+				var v []int64
+				v, err = molecule.DecodePackedInt64(codec.NewBuffer(value.Bytes), nil)
+				require.Equal(t, int64s, v)
+			}
+			return true, err
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,167 @@
+package moleculetest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/grpcwire"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGRPCWireFraming(t *testing.T) {
+	var (
+		messages = []*simple.Test{
+			{StringField: "hello", Int64Field: 1},
+			{},
+			{RepeatedInt64Field: make([]int64, 10000)},
+		}
+		r, w = io.Pipe()
+	)
+	for i := range messages[2].RepeatedInt64Field {
+		messages[2].RepeatedInt64Field[i] = int64(i)
+	}
+
+	go func() {
+		fw := grpcwire.NewFrameWriter(w)
+		for _, m := range messages {
+			err := fw.WriteMessage(func(ps *molecule.ProtoStream) error {
+				if err := ps.String(1, m.StringField); err != nil {
+					return err
+				}
+				if err := ps.Int64(2, m.Int64Field); err != nil {
+					return err
+				}
+				return ps.Int64Packed(3, m.RepeatedInt64Field)
+			})
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		if err := fw.WriteFrame(true, []byte("compressed")); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		w.Close()
+	}()
+
+	fr := grpcwire.NewFrameReader(r)
+	for _, expected := range messages {
+		compressed, buffer, err := fr.Next()
+		require.NoError(t, err)
+		require.False(t, compressed)
+
+		var actual simple.Test
+		require.NoError(t, proto.Unmarshal(buffer.Bytes(), &actual))
+		require.True(t, proto.Equal(expected, &actual))
+	}
+
+	compressed, buffer, err := fr.Next()
+	require.NoError(t, err)
+	require.True(t, compressed)
+	require.Equal(t, []byte("compressed"), buffer.Bytes())
+
+	_, _, err = fr.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestGRPCWireFramingErrors(t *testing.T) {
+	// Truncated payload.
+	fr := grpcwire.NewFrameReader(bytes.NewReader([]byte{0, 0, 0, 0, 3, 1, 2}))
+	_, _, err := fr.Next()
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	// Truncated header.
+	fr.Reset(bytes.NewReader([]byte{0, 0}))
+	_, _, err = fr.Next()
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	// Invalid compressed flag.
+	fr.Reset(bytes.NewReader([]byte{2, 0, 0, 0, 0}))
+	_, _, err = fr.Next()
+	require.Error(t, err)
+
+	// Frame too large.
+	fr.Reset(bytes.NewReader([]byte{0, 0, 0, 1, 0}))
+	fr.MaxFrameSize = 255
+	_, _, err = fr.Next()
+	require.True(t, errors.Is(err, grpcwire.ErrFrameTooLarge))
+
+	// Nothing is written if the message can't be encoded.
+	var (
+		output = bytes.NewBuffer(nil)
+		fw     = grpcwire.NewFrameWriter(output)
+	)
+	err = fw.WriteMessage(func(ps *molecule.ProtoStream) error {
+		if err := ps.Int64(1, 1); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	require.EqualError(t, err, "failed")
+	require.Equal(t, 0, output.Len())
+}
+
+func TestGRPCWireCodec(t *testing.T) {
+	var (
+		c        = grpcwire.Codec{}
+		expected = &simple.Test{StringField: "hello", Int64Field: 42}
+	)
+	require.Equal(t, "molecule", c.Name())
+	require.Equal(t, "custom", grpcwire.Codec{CodecName: "custom"}.Name())
+
+	data, err := c.Marshal(grpcwire.MarshalFunc(func(ps *molecule.ProtoStream) error {
+		if err := ps.String(1, expected.StringField); err != nil {
+			return err
+		}
+		return ps.Int64(2, expected.Int64Field)
+	}))
+	require.NoError(t, err)
+
+	var actual simple.Test
+	require.NoError(t, proto.Unmarshal(data, &actual))
+	require.True(t, proto.Equal(expected, &actual))
+
+	var int64Field int64
+	err = c.Unmarshal(data, grpcwire.UnmarshalFunc(func(buffer *codec.Buffer) error {
+		return molecule.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+			if fieldNum == 2 {
+				v, err := value.AsInt64()
+				int64Field = v
+				return false, err
+			}
+			return true, nil
+		})
+	}))
+	require.NoError(t, err)
+	require.Equal(t, expected.Int64Field, int64Field)
+
+	// The streams are reused, but the returned bytes and the options of the stream are
+	// not shared between calls.
+	original := append([]byte(nil), data...)
+	zero, err := c.Marshal(grpcwire.MarshalFunc(func(ps *molecule.ProtoStream) error {
+		ps.EmitZeroValues = true
+		return ps.Int64(2, 0)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []byte{2 << 3, 0}, zero)
+	require.Equal(t, original, data)
+	for i := 0; i < 10; i++ {
+		empty, err := c.Marshal(grpcwire.MarshalFunc(func(ps *molecule.ProtoStream) error {
+			return ps.Int64(2, 0)
+		}))
+		require.NoError(t, err)
+		require.Empty(t, empty)
+	}
+
+	_, err = c.Marshal(expected)
+	require.Error(t, err)
+	require.Error(t, c.Unmarshal(data, &actual))
+}
@@ -0,0 +1,126 @@
+package moleculetest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// requireIndexMatches checks that ix agrees with a linear scan of marshaled.
+func requireIndexMatches(t *testing.T, ix *molecule.Index, marshaled []byte) {
+	expected := map[int32][]molecule.Value{}
+	err := molecule.MessageEach(codec.NewBuffer(marshaled), func(fieldNum int32, value molecule.Value) (bool, error) {
+		expected[fieldNum] = append(expected[fieldNum], value)
+		return true, nil
+	})
+	require.NoError(t, err)
+
+	var numValues int
+	for fieldNum, values := range expected {
+		require.Equal(t, values, ix.LookupAll(fieldNum))
+		last, ok := ix.Lookup(fieldNum)
+		require.True(t, ok)
+		require.Equal(t, values[len(values)-1], last)
+		numValues += len(values)
+	}
+	require.Equal(t, numValues, ix.Len())
+}
+
+func TestIndex(t *testing.T) {
+	var (
+		seed      = time.Now().UnixNano()
+		fuzzer    = fuzz.NewWithSeed(seed)
+		numFuzzes = 1000
+		ix        molecule.Index
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+	fuzzer.NumElements(0, 10)
+
+	for i := 0; i < numFuzzes; i++ {
+		// Concatenating messages merges them, so fields may occur more than once.
+		var marshaled []byte
+		for j := 0; j < 3; j++ {
+			m := &simple.Simple{}
+			fuzzer.Fuzz(&m)
+			if m == nil {
+				continue
+			}
+			b, err := proto.Marshal(m)
+			require.NoError(t, err)
+			marshaled = append(marshaled, b...)
+		}
+
+		require.NoError(t, ix.Reset(codec.NewBuffer(marshaled)))
+		requireIndexMatches(t, &ix, marshaled)
+
+		_, ok := ix.Lookup(17)
+		require.False(t, ok)
+		require.Empty(t, ix.LookupAll(-1))
+		require.Empty(t, ix.LookupAll(1000))
+	}
+}
+
+func TestIndexLargeFieldNumbers(t *testing.T) {
+	var (
+		output = bytes.NewBuffer(nil)
+		ps     = molecule.NewProtoStream(output)
+	)
+	require.NoError(t, ps.Int64(100000, 1))
+	require.NoError(t, ps.String(2, "hello"))
+	require.NoError(t, ps.Int64(100000, 2))
+	require.NoError(t, ps.Int64(5000, 3))
+
+	ix, err := molecule.NewIndex(codec.NewBuffer(output.Bytes()))
+	require.NoError(t, err)
+	requireIndexMatches(t, ix, output.Bytes())
+
+	v, ok := ix.Lookup(100000)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), v.Number)
+	require.Len(t, ix.LookupAll(100000), 2)
+	require.Empty(t, ix.LookupAll(4))
+	require.Empty(t, ix.LookupAll(200000))
+
+	// Errors leave the Index empty.
+	require.Error(t, ix.Reset(codec.NewBuffer([]byte{1 << 3})))
+	require.Equal(t, 0, ix.Len())
+	_, ok = ix.Lookup(1)
+	require.False(t, ok)
+}
+
+func TestIndexDoesNotAllocate(t *testing.T) {
+	m := &simple.Simple{Int64: 1, String_: "hello", RepeatedInt64Packed: []int64{1, 2, 3}}
+	marshaled, err := proto.Marshal(m)
+	require.NoError(t, err)
+	marshaled = append(marshaled, marshaled...)
+
+	var (
+		buffer = codec.NewBuffer(marshaled)
+		ix     molecule.Index
+	)
+	require.NoError(t, ix.Reset(buffer))
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer.Reset(marshaled)
+		if err := ix.Reset(buffer); err != nil {
+			panic(err)
+		}
+		if _, ok := ix.Lookup(14); !ok {
+			panic("missing field")
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+}
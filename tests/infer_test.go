@@ -0,0 +1,127 @@
+package moleculetest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/richardartoul/molecule/src/infer"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// inferredField returns the field of m with the given number.
+func inferredField(t *testing.T, m *infer.Message, number int32) *infer.Field {
+	for _, f := range m.Fields {
+		if f.Number == number {
+			return f
+		}
+	}
+	require.FailNow(t, "missing field", "field %d", number)
+	return nil
+}
+
+func TestInferNested(t *testing.T) {
+	var (
+		inf     = infer.NewInferrer()
+		samples [][]byte
+	)
+	for i := 0; i < 5; i++ {
+		m := &simple.Nested{NestedMessage: &simple.Test{
+			StringField:        "hello world",
+			Int64Field:         int64(-i),
+			RepeatedInt64Field: []int64{1, 2, 300 + int64(i)},
+		}}
+		marshaled, err := proto.Marshal(m)
+		require.NoError(t, err)
+		require.NoError(t, inf.Add(marshaled))
+		samples = append(samples, marshaled)
+	}
+	require.Error(t, inf.Add([]byte{0xff}))
+	require.Equal(t, 5, inf.Samples())
+
+	m := inf.Infer("Nested")
+	require.Len(t, m.Fields, 1)
+	nested := inferredField(t, m, 1)
+	require.Equal(t, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, nested.Type)
+	require.Equal(t, infer.HighConfidence, nested.Confidence)
+	require.Equal(t, 5, nested.Present)
+	require.Equal(t, []*infer.Message{nested.Message}, m.Nested)
+
+	str := inferredField(t, nested.Message, 1)
+	require.Equal(t, descriptorpb.FieldDescriptorProto_TYPE_STRING, str.Type)
+	// Zero values are not encoded, so field 2 is missing from the first sample.
+	int64Field := inferredField(t, nested.Message, 2)
+	require.Equal(t, descriptorpb.FieldDescriptorProto_TYPE_INT64, int64Field.Type)
+	require.Equal(t, 4, int64Field.Present)
+	require.False(t, int64Field.Repeated)
+	repeated := inferredField(t, nested.Message, 3)
+	require.Equal(t, descriptorpb.FieldDescriptorProto_TYPE_INT64, repeated.Type)
+	require.True(t, repeated.Repeated)
+	require.Equal(t, infer.LowConfidence, repeated.Confidence)
+
+	// The descriptor is valid, and decodes the samples without unknown fields.
+	fd, err := protodesc.NewFile(m.FileDescriptor("nested.proto", "inferred"), nil)
+	require.NoError(t, err)
+	for _, sample := range samples {
+		dm := dynamicpb.NewMessage(fd.Messages().ByName("Nested"))
+		require.NoError(t, proto.Unmarshal(sample, dm))
+		require.Empty(t, dm.GetUnknown())
+		inner := dm.Get(fd.Messages().ByName("Nested").Fields().ByNumber(1)).Message()
+		require.Equal(t, "hello world", inner.Get(inner.Descriptor().Fields().ByNumber(1)).String())
+	}
+
+	expected := strings.Join([]string{
+		`message Nested {`,
+		`  message Field1 {`,
+		`    // Seen in 5 of 5 samples, high confidence.`,
+		`    // Every value is a printable string.`,
+		`    string field_1 = 1;`,
+	}, "\n")
+	p := m.Proto("inferred")
+	require.Contains(t, p, expected)
+	require.Contains(t, p, "  Field1 field_1 = 1;\n")
+	require.Contains(t, p, "    repeated int64 field_3 = 3;\n")
+	require.Contains(t, p, "package inferred;\n")
+}
+
+func TestInferScalars(t *testing.T) {
+	inf := infer.NewInferrer()
+	for i := 0; i < 3; i++ {
+		marshaled, err := proto.Marshal(&simple.Simple{
+			Double:  1.5 + float64(i),
+			Float:   2.5,
+			Bool:    true,
+			Fixed32: 12345,
+			Bytes:   []byte{0xff, 0xfe, 0x80},
+		})
+		require.NoError(t, err)
+		// Concatenated messages merge, so field 13 occurs twice, unpacked.
+		require.NoError(t, inf.Add(append(marshaled, 13<<3, 1)))
+	}
+
+	m := inf.Infer("Simple")
+	for _, tc := range []struct {
+		number int32
+		typ    descriptorpb.FieldDescriptorProto_Type
+	}{
+		{1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE},
+		{2, descriptorpb.FieldDescriptorProto_TYPE_FLOAT},
+		{9, descriptorpb.FieldDescriptorProto_TYPE_FIXED32},
+		{13, descriptorpb.FieldDescriptorProto_TYPE_BOOL},
+		{15, descriptorpb.FieldDescriptorProto_TYPE_BYTES},
+	} {
+		require.Equal(t, tc.typ, inferredField(t, m, tc.number).Type, "field %d", tc.number)
+	}
+	boolField := inferredField(t, m, 13)
+	require.True(t, boolField.Repeated)
+	require.True(t, boolField.Unpacked)
+	require.Contains(t, m.Proto(""), "repeated bool field_13 = 13 [packed = false];")
+
+	_, err := protodesc.NewFile(m.FileDescriptor("simple.proto", ""), nil)
+	require.NoError(t, err)
+}
@@ -5,9 +5,11 @@ import (
 
 	"github.com/richardartoul/molecule"
 	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/columnar"
 	simple "github.com/richardartoul/molecule/src/proto"
 
 	fuzz "github.com/google/gofuzz"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -187,6 +189,82 @@ func BenchmarkMolecule(b *testing.B) {
 	})
 }
 
+func BenchmarkPackedInt64(b *testing.B) {
+	var packed []byte
+	for i := 0; i < 1<<16; i++ {
+		// Mix single byte and multi-byte varints.
+		v := int64(i % 100)
+		if i%4 == 0 {
+			v = int64(i) * 1000003
+		}
+		packed = protowire.AppendVarint(packed, uint64(v))
+	}
+
+	b.Run("PackedRepeatedEach", func(b *testing.B) {
+		var (
+			buffer = codec.NewBuffer(packed)
+			int64s []int64
+		)
+		b.SetBytes(int64(len(packed)))
+		for i := 0; i < b.N; i++ {
+			buffer.Reset(packed)
+			int64s = int64s[:0]
+			err := molecule.PackedRepeatedEach(buffer, codec.FieldType_INT64, func(value molecule.Value) (bool, error) {
+				v, err := value.AsInt64()
+				int64s = append(int64s, v)
+				return true, err
+			})
+			noErr(err)
+		}
+	})
+
+	b.Run("DecodePackedInt64", func(b *testing.B) {
+		var (
+			buffer = codec.NewBuffer(packed)
+			int64s []int64
+			err    error
+		)
+		b.SetBytes(int64(len(packed)))
+		for i := 0; i < b.N; i++ {
+			buffer.Reset(packed)
+			int64s, err = molecule.DecodePackedInt64(buffer, int64s[:0])
+			noErr(err)
+		}
+	})
+}
+
+func BenchmarkColumnar(b *testing.B) {
+	var (
+		seed     = int64(1623963202)
+		fuzzer   = fuzz.NewWithSeed(seed)
+		messages [][]byte
+		size     int
+	)
+	fuzzer.NumElements(0, 10)
+	fuzzer.NilChance(0)
+	for i := 0; i < 10000; i++ {
+		m := &simple.Simple{}
+		fuzzer.Fuzz(m)
+		marshaled, err := proto.Marshal(m)
+		noErr(err)
+		messages = append(messages, marshaled)
+		size += len(marshaled)
+	}
+
+	d, err := columnar.NewDecoder([]*columnar.Column{
+		{Path: []int32{4}, Type: codec.FieldType_INT64},
+		{Path: []int32{13}, Type: codec.FieldType_BOOL},
+		{Path: []int32{14}, Type: codec.FieldType_STRING},
+	})
+	noErr(err)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Reset()
+		noErr(d.Append(messages))
+	}
+}
+
 func noErr(err error) {
 	if err != nil {
 		panic(err)
@@ -1,6 +1,7 @@
 package moleculetest
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 
 	fuzz "github.com/google/gofuzz"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -194,3 +196,25 @@ func TestMoleculeTruncated(t *testing.T) {
 	})
 	require.Error(t, err, "unexpected EOF")
 }
+
+func TestMoleculeFieldNumberOverflow(t *testing.T) {
+	// Field numbers that do not fit in an int32 must be rejected rather than truncated,
+	// which would turn 2^31 into a negative field number, and 2^32+1 into field 1.
+	for _, fieldNum := range []uint64{1 << 31, 1<<32 + 1, 1<<61 - 1} {
+		_, _, err := codec.AsTagAndWireType(fieldNum<<3 | uint64(codec.WireVarint))
+		require.Equal(t, codec.ErrBadWireType, err, fieldNum)
+
+		serialized := protowire.AppendVarint(nil, fieldNum<<3|uint64(codec.WireVarint))
+		serialized = protowire.AppendVarint(serialized, 1)
+		err = molecule.MessageEach(codec.NewBuffer(serialized), func(fieldNum int32, value molecule.Value) (bool, error) {
+			return true, nil
+		})
+		require.Error(t, err, fieldNum)
+	}
+
+	// The largest field number that fits in an int32 is still accepted.
+	fieldNum, wireType, err := codec.AsTagAndWireType(math.MaxInt32<<3 | uint64(codec.WireBytes))
+	require.NoError(t, err)
+	require.Equal(t, int32(math.MaxInt32), fieldNum)
+	require.Equal(t, codec.WireBytes, wireType)
+}
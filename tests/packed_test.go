@@ -0,0 +1,214 @@
+package moleculetest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// randomVarints returns n random values with a mix of encoded sizes, so that both the
+// single byte fast path and longer varints are exercised.
+func randomVarints(r *rand.Rand, n int) []uint64 {
+	values := make([]uint64, n)
+	for i := range values {
+		switch r.Intn(4) {
+		case 0, 1:
+			values[i] = uint64(r.Intn(128))
+		case 2:
+			values[i] = r.Uint64() >> uint(r.Intn(64))
+		default:
+			values[i] = r.Uint64()
+		}
+	}
+	return values
+}
+
+// packedEach collects the values of a packed field with PackedRepeatedEach, which the bulk
+// decoders are compared against.
+func packedEach(t *testing.T, packed []byte, fieldType codec.FieldType) []molecule.Value {
+	var values []molecule.Value
+	err := molecule.PackedRepeatedEach(codec.NewBuffer(packed), fieldType, func(value molecule.Value) (bool, error) {
+		values = append(values, value)
+		return true, nil
+	})
+	require.NoError(t, err)
+	return values
+}
+
+func TestDecodePackedVarints(t *testing.T) {
+	var (
+		seed = time.Now().UnixNano()
+		r    = rand.New(rand.NewSource(seed))
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+
+	for i := 0; i < 1000; i++ {
+		var (
+			raw    = randomVarints(r, r.Intn(300))
+			packed []byte
+			small  []byte
+		)
+		for _, v := range raw {
+			packed = protowire.AppendVarint(packed, v)
+			small = protowire.AppendVarint(small, uint64(int64(int32(v))))
+		}
+
+		int64s, err := molecule.DecodePackedInt64(codec.NewBuffer(packed), nil)
+		require.NoError(t, err)
+		uint64s, err := molecule.DecodePackedUint64(codec.NewBuffer(packed), nil)
+		require.NoError(t, err)
+		sint64s, err := molecule.DecodePackedSint64(codec.NewBuffer(packed), nil)
+		require.NoError(t, err)
+		int32s, err := molecule.DecodePackedInt32(codec.NewBuffer(small), nil)
+		require.NoError(t, err)
+		require.Len(t, int64s, len(raw))
+		require.Len(t, uint64s, len(raw))
+		require.Len(t, sint64s, len(raw))
+		require.Len(t, int32s, len(raw))
+
+		for j, value := range packedEach(t, packed, codec.FieldType_INT64) {
+			require.Equal(t, raw[j], value.Number)
+			v, _ := value.AsInt64()
+			require.Equal(t, v, int64s[j])
+			require.Equal(t, value.Number, uint64s[j])
+			s, _ := value.AsSint64()
+			require.Equal(t, s, sint64s[j])
+		}
+		for j, value := range packedEach(t, small, codec.FieldType_INT32) {
+			v, err := value.AsInt32()
+			require.NoError(t, err)
+			require.Equal(t, v, int32s[j])
+		}
+	}
+}
+
+func TestDecodePackedSmallVarints(t *testing.T) {
+	var (
+		packed  []byte
+		uint32s []uint32
+		sint32s []int32
+		bools   []bool
+	)
+	for i := 0; i < 100; i++ {
+		packed = protowire.AppendVarint(packed, uint64(i%2))
+		uint32s = append(uint32s, uint32(i%2))
+		sint32s = append(sint32s, int32(protowire.DecodeZigZag(uint64(i%2))))
+		bools = append(bools, i%2 == 1)
+	}
+
+	decodedUint32s, err := molecule.DecodePackedUint32(codec.NewBuffer(packed), nil)
+	require.NoError(t, err)
+	require.Equal(t, uint32s, decodedUint32s)
+	decodedSint32s, err := molecule.DecodePackedSint32(codec.NewBuffer(packed), nil)
+	require.NoError(t, err)
+	require.Equal(t, sint32s, decodedSint32s)
+	decodedBools, err := molecule.DecodePackedBool(codec.NewBuffer(packed), nil)
+	require.NoError(t, err)
+	require.Equal(t, bools, decodedBools)
+
+	// Values that do not fit in 32 bits should be rejected, just like AsUint32 does.
+	packed = protowire.AppendVarint(packed, math.MaxUint32+1)
+	_, err = molecule.DecodePackedUint32(codec.NewBuffer(packed), nil)
+	require.Error(t, err)
+}
+
+func TestDecodePackedFixed(t *testing.T) {
+	var (
+		packed32 []byte
+		packed64 []byte
+		uint32s  []uint32
+		uint64s  []uint64
+		float32s []float32
+		float64s []float64
+	)
+	for i := 0; i < 100; i++ {
+		f := float64(i) * 1.5
+		packed32 = protowire.AppendFixed32(packed32, math.Float32bits(float32(f)))
+		packed64 = protowire.AppendFixed64(packed64, math.Float64bits(f))
+		uint32s = append(uint32s, math.Float32bits(float32(f)))
+		uint64s = append(uint64s, math.Float64bits(f))
+		float32s = append(float32s, float32(f))
+		float64s = append(float64s, f)
+	}
+
+	// Append to a non-empty destination to check that existing values are preserved.
+	buffer := codec.NewBuffer(packed32)
+	decodedFloat32s, err := molecule.DecodePackedFloat(buffer, []float32{-1})
+	require.NoError(t, err)
+	require.Equal(t, append([]float32{-1}, float32s...), decodedFloat32s)
+	require.True(t, buffer.EOF())
+
+	decodedFixed32s, err := molecule.DecodePackedFixed32(codec.NewBuffer(packed32), nil)
+	require.NoError(t, err)
+	require.Equal(t, uint32s, decodedFixed32s)
+	decodedSfixed32s, err := molecule.DecodePackedSfixed32(codec.NewBuffer(packed32), nil)
+	require.NoError(t, err)
+	require.Len(t, decodedSfixed32s, len(uint32s))
+
+	decodedFloat64s, err := molecule.DecodePackedDouble(codec.NewBuffer(packed64), nil)
+	require.NoError(t, err)
+	require.Equal(t, float64s, decodedFloat64s)
+	decodedFixed64s, err := molecule.DecodePackedFixed64(codec.NewBuffer(packed64), nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64s, decodedFixed64s)
+	decodedSfixed64s, err := molecule.DecodePackedSfixed64(codec.NewBuffer(packed64), nil)
+	require.NoError(t, err)
+	require.Len(t, decodedSfixed64s, len(uint64s))
+
+	// Lengths that are not a multiple of the element size are invalid.
+	_, err = molecule.DecodePackedFixed32(codec.NewBuffer(packed32[:5]), nil)
+	require.Error(t, err)
+	_, err = molecule.DecodePackedDouble(codec.NewBuffer(packed64[:12]), nil)
+	require.Error(t, err)
+}
+
+func TestDecodePackedTruncated(t *testing.T) {
+	var packed []byte
+	for i := 0; i < 20; i++ {
+		packed = protowire.AppendVarint(packed, math.MaxUint64)
+	}
+
+	buffer := codec.NewBuffer(packed[:len(packed)-1])
+	values, err := molecule.DecodePackedUint64(buffer, nil)
+	require.Error(t, err)
+	require.Len(t, values, 19)
+	// The buffer is left untouched on error.
+	require.Equal(t, len(packed)-1, buffer.Len())
+
+	// An 11 byte varint overflows.
+	overflow := append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 0x01)
+	_, err = molecule.DecodePackedInt64(codec.NewBuffer(overflow), nil)
+	require.Error(t, err)
+}
+
+func TestDecodePackedDoesNotAllocate(t *testing.T) {
+	var packed []byte
+	for i := 0; i < 1000; i++ {
+		packed = protowire.AppendVarint(packed, uint64(i*i))
+	}
+
+	var (
+		buffer = codec.NewBuffer(packed)
+		dst    = make([]int64, 0, 1000)
+	)
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer.Reset(packed)
+		var err error
+		dst, err = molecule.DecodePackedInt64(buffer, dst[:0])
+		if err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+	require.Equal(t, int64(999*999), dst[999])
+}
@@ -0,0 +1,150 @@
+package moleculetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+
+	"github.com/stretchr/testify/require"
+)
+
+// parallelTestMessage returns a message with n entries of the repeated embedded field 1,
+// each holding its index in field 2, interleaved with other fields.
+func parallelTestMessage(t *testing.T, n int) []byte {
+	var (
+		output = bytes.NewBuffer(nil)
+		ps     = molecule.NewProtoStream(output)
+	)
+	for i := 0; i < n; i++ {
+		require.NoError(t, ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			return ps.Int64(2, int64(i))
+		}))
+		switch i % 3 {
+		case 0:
+			require.NoError(t, ps.Int64(2, -1))
+		case 1:
+			require.NoError(t, ps.String(3, "skipped"))
+		case 2:
+			require.NoError(t, ps.Fixed64(4, 1))
+			require.NoError(t, ps.Fixed32(5, 1))
+		}
+	}
+	return output.Bytes()
+}
+
+// parallelTestEntry decodes the index held by an entry of parallelTestMessage.
+func parallelTestEntry(buffer *codec.Buffer) (int64, error) {
+	var (
+		value molecule.Value
+		v     int64
+	)
+	for !buffer.EOF() {
+		fieldNum, err := molecule.Next(buffer, &value)
+		if err != nil {
+			return 0, err
+		}
+		if fieldNum == 2 {
+			v, err = value.AsInt64()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func TestParallelRepeatedEach(t *testing.T) {
+	const n = 10000
+	marshaled := parallelTestMessage(t, n)
+
+	// Dynamic load balancing.
+	seen := make([]int32, n)
+	err := molecule.ParallelRepeatedEach(context.Background(), codec.NewBuffer(marshaled), 1, molecule.ParallelOptions{Workers: 8}, func(worker, index int, buffer *codec.Buffer) error {
+		v, err := parallelTestEntry(buffer)
+		if err != nil {
+			return err
+		}
+		if v != int64(index) {
+			return errors.New("entry does not match its index")
+		}
+		atomic.AddInt32(&seen[index], 1)
+		return nil
+	})
+	require.NoError(t, err)
+	for i := range seen {
+		require.Equal(t, int32(1), seen[i])
+	}
+
+	// Static partitioning: concatenating the entries seen by each worker, in worker order,
+	// gives the entries in order.
+	perWorker := make([][]int64, 8)
+	err = molecule.ParallelRepeatedEach(context.Background(), codec.NewBuffer(marshaled), 1, molecule.ParallelOptions{Workers: 8, StaticPartitioning: true}, func(worker, index int, buffer *codec.Buffer) error {
+		v, err := parallelTestEntry(buffer)
+		perWorker[worker] = append(perWorker[worker], v)
+		return err
+	})
+	require.NoError(t, err)
+	var ordered []int64
+	for _, values := range perWorker {
+		ordered = append(ordered, values...)
+	}
+	require.Len(t, ordered, n)
+	for i, v := range ordered {
+		require.Equal(t, int64(i), v)
+	}
+
+	// More workers than entries, and no entries at all.
+	var calls int32
+	err = molecule.ParallelRepeatedEach(context.Background(), codec.NewBuffer(parallelTestMessage(t, 3)), 1, molecule.ParallelOptions{Workers: 100}, func(worker, index int, buffer *codec.Buffer) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), calls)
+	err = molecule.ParallelRepeatedEach(context.Background(), codec.NewBuffer(marshaled), 6, molecule.ParallelOptions{}, func(worker, index int, buffer *codec.Buffer) error {
+		return errors.New("unexpected call")
+	})
+	require.NoError(t, err)
+}
+
+func TestParallelRepeatedEachErrors(t *testing.T) {
+	marshaled := parallelTestMessage(t, 10000)
+
+	// The first error is returned, and no more entries are handed out.
+	var (
+		calls  int32
+		failed = errors.New("failed")
+	)
+	err := molecule.ParallelRepeatedEach(context.Background(), codec.NewBuffer(marshaled), 1, molecule.ParallelOptions{Workers: 4}, func(worker, index int, buffer *codec.Buffer) error {
+		atomic.AddInt32(&calls, 1)
+		if index == 10 {
+			return failed
+		}
+		return nil
+	})
+	require.Equal(t, failed, err)
+	require.Less(t, atomic.LoadInt32(&calls), int32(10000))
+
+	// Cancelling the context stops the workers.
+	ctx, cancel := context.WithCancel(context.Background())
+	err = molecule.ParallelRepeatedEach(ctx, codec.NewBuffer(marshaled), 1, molecule.ParallelOptions{Workers: 4, StaticPartitioning: true}, func(worker, index int, buffer *codec.Buffer) error {
+		cancel()
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+
+	// Fields with the wrong wire type and malformed messages are rejected.
+	err = molecule.ParallelRepeatedEach(context.Background(), codec.NewBuffer(marshaled), 2, molecule.ParallelOptions{}, func(worker, index int, buffer *codec.Buffer) error {
+		return nil
+	})
+	require.Error(t, err)
+	err = molecule.ParallelRepeatedEach(context.Background(), codec.NewBuffer([]byte{3<<3 | 2, 10}), 1, molecule.ParallelOptions{}, func(worker, index int, buffer *codec.Buffer) error {
+		return nil
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,403 @@
+package moleculetest
+
+import (
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/presence"
+	simple "github.com/richardartoul/molecule/src/proto"
+	"github.com/richardartoul/molecule/src/protobridge"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// defaultsSchema declares a proto2 message with a default value for every scalar type,
+// along with required fields, a oneof, a packed repeated enum and a field with a large
+// field number.
+const defaultsSchema = `
+name: "defaults.proto" package: "defaults" syntax: "proto2"
+enum_type { name: "Color" value { name: "RED" number: 1 } value { name: "GREEN" number: 2 } value { name: "BLUE" number: -3 } }
+message_type {
+	name: "Defaults"
+	field { name: "int32" number: 1 label: LABEL_OPTIONAL type: TYPE_INT32 default_value: "-5" }
+	field { name: "int64" number: 2 label: LABEL_OPTIONAL type: TYPE_INT64 default_value: "-1099511627776" }
+	field { name: "uint32" number: 3 label: LABEL_OPTIONAL type: TYPE_UINT32 default_value: "4294967295" }
+	field { name: "uint64" number: 4 label: LABEL_OPTIONAL type: TYPE_UINT64 default_value: "18446744073709551615" }
+	field { name: "sint32" number: 5 label: LABEL_OPTIONAL type: TYPE_SINT32 default_value: "-7" }
+	field { name: "sint64" number: 6 label: LABEL_OPTIONAL type: TYPE_SINT64 default_value: "-8" }
+	field { name: "fixed32" number: 7 label: LABEL_OPTIONAL type: TYPE_FIXED32 default_value: "9" }
+	field { name: "fixed64" number: 8 label: LABEL_OPTIONAL type: TYPE_FIXED64 default_value: "10" }
+	field { name: "sfixed32" number: 9 label: LABEL_OPTIONAL type: TYPE_SFIXED32 default_value: "-11" }
+	field { name: "sfixed64" number: 10 label: LABEL_OPTIONAL type: TYPE_SFIXED64 default_value: "-12" }
+	field { name: "float" number: 11 label: LABEL_OPTIONAL type: TYPE_FLOAT default_value: "1.5" }
+	field { name: "double" number: 12 label: LABEL_OPTIONAL type: TYPE_DOUBLE default_value: "-inf" }
+	field { name: "bool" number: 13 label: LABEL_OPTIONAL type: TYPE_BOOL default_value: "true" }
+	field { name: "string" number: 14 label: LABEL_OPTIONAL type: TYPE_STRING default_value: "hello" }
+	field { name: "bytes" number: 15 label: LABEL_OPTIONAL type: TYPE_BYTES default_value: "\\000\\377" }
+	field { name: "color" number: 16 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".defaults.Color" default_value: "BLUE" }
+	field { name: "first_color" number: 17 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".defaults.Color" }
+	field { name: "no_default" number: 18 label: LABEL_OPTIONAL type: TYPE_INT32 }
+	field { name: "message" number: 19 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".defaults.Defaults" }
+	field { name: "repeated" number: 20 label: LABEL_REPEATED type: TYPE_INT32 }
+	field { name: "required_a" number: 21 label: LABEL_REQUIRED type: TYPE_STRING }
+	field { name: "required_b" number: 22 label: LABEL_REQUIRED type: TYPE_INT64 }
+	field { name: "choice_a" number: 23 label: LABEL_OPTIONAL type: TYPE_INT32 default_value: "23" oneof_index: 0 }
+	field { name: "choice_b" number: 24 label: LABEL_OPTIONAL type: TYPE_STRING oneof_index: 0 }
+	field { name: "colors" number: 25 label: LABEL_REPEATED type: TYPE_ENUM type_name: ".defaults.Color" options { packed: true } }
+	field { name: "large" number: 100000 label: LABEL_OPTIONAL type: TYPE_SINT32 default_value: "-100000" }
+	oneof_decl { name: "choice" }
+}
+`
+
+func defaultsDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	var fdp descriptorpb.FileDescriptorProto
+	require.NoError(t, prototext.Unmarshal([]byte(defaultsSchema), &fdp))
+	fd, err := protodesc.NewFile(&fdp, nil)
+	require.NoError(t, err)
+	return fd.Messages().ByName("Defaults")
+}
+
+// decodedValue decodes value, read from a field described by fd, like the Get method of
+// a protoreflect.Message would return it.
+func decodedValue(t *testing.T, fd protoreflect.FieldDescriptor, value molecule.Value) protoreflect.Value {
+	var (
+		v   protoreflect.Value
+		err error
+	)
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var x bool
+		x, err = value.AsBool()
+		v = protoreflect.ValueOfBool(x)
+	case protoreflect.EnumKind:
+		var x int32
+		x, err = value.AsInt32()
+		v = protoreflect.ValueOfEnum(protoreflect.EnumNumber(x))
+	case protoreflect.Int32Kind:
+		var x int32
+		x, err = value.AsInt32()
+		v = protoreflect.ValueOfInt32(x)
+	case protoreflect.Int64Kind:
+		var x int64
+		x, err = value.AsInt64()
+		v = protoreflect.ValueOfInt64(x)
+	case protoreflect.Uint32Kind:
+		var x uint32
+		x, err = value.AsUint32()
+		v = protoreflect.ValueOfUint32(x)
+	case protoreflect.Uint64Kind:
+		var x uint64
+		x, err = value.AsUint64()
+		v = protoreflect.ValueOfUint64(x)
+	case protoreflect.Sint32Kind:
+		var x int32
+		x, err = value.AsSint32()
+		v = protoreflect.ValueOfInt32(x)
+	case protoreflect.Sint64Kind:
+		var x int64
+		x, err = value.AsSint64()
+		v = protoreflect.ValueOfInt64(x)
+	case protoreflect.Fixed32Kind:
+		var x uint32
+		x, err = value.AsFixed32()
+		v = protoreflect.ValueOfUint32(x)
+	case protoreflect.Fixed64Kind:
+		var x uint64
+		x, err = value.AsFixed64()
+		v = protoreflect.ValueOfUint64(x)
+	case protoreflect.Sfixed32Kind:
+		var x int32
+		x, err = value.AsSFixed32()
+		v = protoreflect.ValueOfInt32(x)
+	case protoreflect.Sfixed64Kind:
+		var x int64
+		x, err = value.AsSFixed64()
+		v = protoreflect.ValueOfInt64(x)
+	case protoreflect.FloatKind:
+		var x float32
+		x, err = value.AsFloat()
+		v = protoreflect.ValueOfFloat32(x)
+	case protoreflect.DoubleKind:
+		var x float64
+		x, err = value.AsDouble()
+		v = protoreflect.ValueOfFloat64(x)
+	case protoreflect.StringKind:
+		var x string
+		x, err = value.AsStringSafe()
+		v = protoreflect.ValueOfString(x)
+	case protoreflect.BytesKind:
+		var x []byte
+		x, err = value.AsBytesSafe()
+		v = protoreflect.ValueOfBytes(x)
+	default:
+		t.Fatalf("unexpected kind: %s", fd.Kind())
+	}
+	require.NoError(t, err)
+	return v
+}
+
+func TestPresenceDefaults(t *testing.T) {
+	var (
+		md      = defaultsDescriptor(t)
+		tracker = presence.NewTracker(md)
+		empty   = dynamicpb.NewMessage(md)
+	)
+	require.NoError(t, tracker.MessageEach(codec.NewBuffer(nil), nil))
+
+	// The default of every singular scalar field matches the getters of the official
+	// library.
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		require.False(t, tracker.Has(int32(fd.Number())), fd.Name())
+		value, ok := tracker.Default(int32(fd.Number()))
+		if fd.IsList() {
+			require.False(t, ok, fd.Name())
+			continue
+		}
+		require.True(t, ok, fd.Name())
+		if fd.Message() != nil {
+			require.Equal(t, molecule.Value{WireType: codec.WireBytes}, value)
+			continue
+		}
+		require.Equal(t, empty.Get(fd).Interface(), decodedValue(t, fd, value).Interface(), fd.Name())
+	}
+	_, ok := tracker.Default(1000)
+	require.False(t, ok)
+
+	// DefaultEach visits the singular scalar fields that are not members of a oneof.
+	var visited []int32
+	require.NoError(t, tracker.DefaultEach(func(fieldNum int32, value molecule.Value) (bool, error) {
+		visited = append(visited, fieldNum)
+		return true, nil
+	}))
+	require.Equal(t, []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 21, 22, 100000}, visited)
+
+	// Both required fields are missing.
+	err := tracker.CheckRequired()
+	require.EqualError(t, err, "CheckRequired: missing required fields: required_a, required_b")
+}
+
+func TestPresenceTracking(t *testing.T) {
+	var (
+		md      = defaultsDescriptor(t)
+		tracker = presence.NewTracker(md)
+		m       = dynamicpb.NewMessage(md)
+		fields  = md.Fields()
+	)
+	m.Set(fields.ByName("int32"), protoreflect.ValueOfInt32(0))
+	m.Set(fields.ByName("string"), protoreflect.ValueOfString(""))
+	m.Set(fields.ByName("required_a"), protoreflect.ValueOfString("a"))
+	m.Set(fields.ByName("large"), protoreflect.ValueOfInt32(1))
+	m.Mutable(fields.ByName("message"))
+	m.Mutable(fields.ByName("repeated")).List().Append(protoreflect.ValueOfInt32(1))
+	marshaled, err := proto.MarshalOptions{AllowPartial: true}.Marshal(m)
+	require.NoError(t, err)
+	// Both members of the oneof are on the wire, so the last one wins.
+	marshaled = protowire.AppendTag(marshaled, 23, protowire.VarintType)
+	marshaled = protowire.AppendVarint(marshaled, 1)
+	marshaled = protowire.AppendTag(marshaled, 24, protowire.BytesType)
+	marshaled = protowire.AppendString(marshaled, "b")
+	// Fields that the descriptor does not declare are ignored.
+	marshaled = protowire.AppendTag(marshaled, 1000, protowire.VarintType)
+	marshaled = protowire.AppendVarint(marshaled, 1)
+
+	var seen int
+	require.NoError(t, tracker.MessageEach(codec.NewBuffer(marshaled), func(fieldNum int32, value molecule.Value) (bool, error) {
+		seen++
+		return true, nil
+	}))
+	require.Equal(t, 9, seen)
+
+	// Presence matches proto.Unmarshal, including for fields set to zero values.
+	expected := dynamicpb.NewMessage(md)
+	require.NoError(t, proto.UnmarshalOptions{AllowPartial: true}.Unmarshal(marshaled, expected))
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		require.Equal(t, expected.Has(fd), tracker.Has(int32(fd.Number())), fd.Name())
+	}
+	require.False(t, tracker.Has(1000))
+	require.False(t, tracker.Has(-1))
+
+	present := tracker.Present()
+	for _, fieldNum := range []int32{1, 14, 19, 20, 21, 24} {
+		require.True(t, present.Has(fieldNum), fieldNum)
+	}
+	for _, fieldNum := range []int32{2, 22, 23, 1000, 100000} {
+		require.False(t, present.Has(fieldNum), fieldNum)
+	}
+
+	err = tracker.CheckRequired()
+	require.EqualError(t, err, "CheckRequired: missing required fields: required_b")
+	require.Equal(t, proto.CheckInitialized(expected) != nil, err != nil)
+
+	// Reset forgets the fields.
+	tracker.Reset()
+	require.False(t, tracker.Has(1))
+	require.False(t, tracker.Has(100000))
+
+	// Visit records fields one at a time.
+	tracker.Visit(21)
+	tracker.Visit(22)
+	require.NoError(t, tracker.CheckRequired())
+}
+
+// Test that fields whose wire type does not match their descriptor are not recorded, like
+// proto.Unmarshal treats them as unknown fields.
+func TestPresenceWireTypes(t *testing.T) {
+	var (
+		md      = defaultsDescriptor(t)
+		tracker = presence.NewTracker(md)
+		fields  = md.Fields()
+	)
+	var marshaled []byte
+	marshaled = protowire.AppendTag(marshaled, 1, protowire.Fixed32Type)
+	marshaled = protowire.AppendFixed32(marshaled, 1)
+	marshaled = protowire.AppendTag(marshaled, 14, protowire.VarintType)
+	marshaled = protowire.AppendVarint(marshaled, 1)
+	marshaled = protowire.AppendTag(marshaled, 21, protowire.VarintType)
+	marshaled = protowire.AppendVarint(marshaled, 1)
+	marshaled = protowire.AppendTag(marshaled, 22, protowire.VarintType)
+	marshaled = protowire.AppendVarint(marshaled, 1)
+	marshaled = protowire.AppendTag(marshaled, 7, protowire.Fixed32Type)
+	marshaled = protowire.AppendFixed32(marshaled, 1)
+	// Repeated scalar fields may be packed.
+	marshaled = protowire.AppendTag(marshaled, 20, protowire.BytesType)
+	marshaled = protowire.AppendBytes(marshaled, protowire.AppendVarint(nil, 1))
+
+	var seen int
+	require.NoError(t, tracker.MessageEach(codec.NewBuffer(marshaled), func(fieldNum int32, value molecule.Value) (bool, error) {
+		seen++
+		return true, nil
+	}))
+	require.Equal(t, 6, seen)
+
+	expected := dynamicpb.NewMessage(md)
+	require.NoError(t, proto.UnmarshalOptions{AllowPartial: true}.Unmarshal(marshaled, expected))
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		require.Equal(t, expected.Has(fd), tracker.Has(int32(fd.Number())), fd.Name())
+	}
+	for _, fieldNum := range []int32{7, 20, 22} {
+		require.True(t, tracker.Has(fieldNum), fieldNum)
+	}
+	for _, fieldNum := range []int32{1, 14, 21} {
+		require.False(t, tracker.Has(fieldNum), fieldNum)
+	}
+	require.EqualError(t, tracker.CheckRequired(), "CheckRequired: missing required fields: required_a")
+}
+
+// Test that values of closed enums that the enum does not declare are not recorded, like
+// Populate adds them to the unknown fields.
+func TestPresenceClosedEnums(t *testing.T) {
+	var (
+		md      = defaultsDescriptor(t)
+		tracker = presence.NewTracker(md)
+		varint  = func(fieldNum protowire.Number, v uint64) []byte {
+			return protowire.AppendVarint(protowire.AppendTag(nil, fieldNum, protowire.VarintType), v)
+		}
+		packed = func(fieldNum protowire.Number, values ...uint64) []byte {
+			var b []byte
+			for _, v := range values {
+				b = protowire.AppendVarint(b, v)
+			}
+			return protowire.AppendBytes(protowire.AppendTag(nil, fieldNum, protowire.BytesType), b)
+		}
+		// BLUE is -3, which is encoded as the two's complement varint.
+		blue = ^uint64(2)
+	)
+	for _, test := range []struct {
+		input   []byte
+		present bool
+	}{
+		{input: varint(16, 1), present: true},
+		{input: varint(16, blue), present: true},
+		{input: varint(16, 99), present: false},
+		{input: varint(25, 2), present: true},
+		{input: varint(25, 99), present: false},
+		{input: packed(25, 99, 2), present: true},
+		{input: packed(25, 99, 98), present: false},
+	} {
+		require.NoError(t, tracker.MessageEach(codec.NewBuffer(test.input), nil))
+		num, _, _ := protowire.ConsumeTag(test.input)
+		require.Equal(t, test.present, tracker.Has(int32(num)), "%x", test.input)
+
+		m := dynamicpb.NewMessage(md)
+		require.NoError(t, protobridge.Populate(codec.NewBuffer(test.input), m, num))
+		require.Equal(t, test.present, m.Has(md.Fields().ByNumber(num)), "%x", test.input)
+	}
+}
+
+func TestPresenceProto3(t *testing.T) {
+	var (
+		m       = &simple.Simple{Int32: 5}
+		tracker = presence.NewTracker(m.ProtoReflect().Descriptor())
+	)
+	marshaled, err := proto.Marshal(m)
+	require.NoError(t, err)
+	require.NoError(t, tracker.MessageEach(codec.NewBuffer(marshaled), nil))
+	require.True(t, tracker.Has(3))
+	require.False(t, tracker.Has(4))
+	require.NoError(t, tracker.CheckRequired())
+
+	// Absent proto3 fields default to zero values, which decode like the fields of an
+	// empty message.
+	decoded := &simple.Simple{Int32: 5}
+	require.NoError(t, tracker.DefaultEach(func(fieldNum int32, value molecule.Value) (bool, error) {
+		fd := m.ProtoReflect().Descriptor().Fields().ByNumber(protoreflect.FieldNumber(fieldNum))
+		decoded.ProtoReflect().Set(fd, decodedValue(t, fd, value))
+		return true, nil
+	}))
+	require.True(t, proto.Equal(m, decoded))
+}
+
+func TestPresenceDoesNotAllocate(t *testing.T) {
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+
+	var (
+		md      = defaultsDescriptor(t)
+		tracker = presence.NewTracker(md)
+		m       = dynamicpb.NewMessage(md)
+		fields  = md.Fields()
+		n       int
+	)
+	m.Set(fields.ByName("required_a"), protoreflect.ValueOfString("a"))
+	m.Set(fields.ByName("required_b"), protoreflect.ValueOfInt64(1))
+	m.Set(fields.ByName("choice_b"), protoreflect.ValueOfString("b"))
+	m.Set(fields.ByName("large"), protoreflect.ValueOfInt32(1))
+	marshaled, err := proto.Marshal(m)
+	require.NoError(t, err)
+
+	buffer := codec.NewBuffer(marshaled)
+	fn := func(fieldNum int32, value molecule.Value) (bool, error) {
+		n++
+		return true, nil
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer.Reset(marshaled)
+		if err := tracker.MessageEach(buffer, fn); err != nil {
+			panic(err)
+		}
+		if err := tracker.DefaultEach(fn); err != nil {
+			panic(err)
+		}
+		if err := tracker.CheckRequired(); err != nil {
+			panic(err)
+		}
+		if !tracker.Has(100000) {
+			panic("large field is missing")
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+	require.NotZero(t, n)
+}
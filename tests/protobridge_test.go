@@ -0,0 +1,234 @@
+package moleculetest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	simple "github.com/richardartoul/molecule/src/proto"
+	"github.com/richardartoul/molecule/src/protobridge"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// requireBridgeRoundTrip checks that m survives being decoded with Populate and encoded
+// with Stream.
+func requireBridgeRoundTrip(t *testing.T, m proto.Message) {
+	marshaled, err := proto.Marshal(m)
+	require.NoError(t, err)
+
+	populated := m.ProtoReflect().New()
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(marshaled), populated))
+	require.True(t, proto.Equal(m, populated.Interface()), "expected %v, got %v", m, populated)
+
+	output := bytes.NewBuffer(nil)
+	require.NoError(t, protobridge.Stream(molecule.NewProtoStream(output), m.ProtoReflect()))
+	streamed := m.ProtoReflect().New().Interface()
+	require.NoError(t, proto.Unmarshal(output.Bytes(), streamed))
+	require.True(t, proto.Equal(m, streamed), "expected %v, got %v", m, streamed)
+}
+
+func TestProtoBridgeSimple(t *testing.T) {
+	var (
+		seed      = time.Now().UnixNano()
+		fuzzer    = fuzz.NewWithSeed(seed)
+		numFuzzes = 1000
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+	fuzzer.NumElements(0, 100)
+
+	for i := 0; i < numFuzzes; i++ {
+		m := &simple.Simple{}
+		fuzzer.Fuzz(&m)
+		if m == nil {
+			continue
+		}
+		requireBridgeRoundTrip(t, m)
+	}
+}
+
+func TestProtoBridgeComplex(t *testing.T) {
+	// A google.protobuf.Struct covers maps of messages, oneofs, enums, and repeated
+	// messages, and a FileDescriptorProto covers proto2 fields with explicit presence,
+	// and repeated fields that are not packed.
+	s, err := structpb.NewStruct(map[string]interface{}{
+		"null":   nil,
+		"zero":   0,
+		"string": "hello",
+		"false":  false,
+		"struct": map[string]interface{}{"nested": true},
+		"list":   []interface{}{1, "two", []interface{}{}, map[string]interface{}{}},
+	})
+	require.NoError(t, err)
+	requireBridgeRoundTrip(t, s)
+	requireBridgeRoundTrip(t, protodesc.ToFileDescriptorProto(simple.File_simple_proto))
+}
+
+func TestProtoBridgeSubsetAndUnknownFields(t *testing.T) {
+	m := &simple.Simple{
+		Int64:               1,
+		String_:             "hello",
+		RepeatedInt64Packed: []int64{1, 2, 3},
+	}
+	marshaled, err := proto.Marshal(m)
+	require.NoError(t, err)
+
+	// Only the chosen fields are decoded.
+	var subset simple.Simple
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(marshaled), subset.ProtoReflect(), 14, 16))
+	require.True(t, proto.Equal(&simple.Simple{
+		String_:             "hello",
+		RepeatedInt64Packed: []int64{1, 2, 3},
+	}, &subset))
+
+	// Populate merges into the fields that are already set.
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(marshaled), subset.ProtoReflect(), 16))
+	require.Equal(t, []int64{1, 2, 3, 1, 2, 3}, subset.RepeatedInt64Packed)
+
+	// Fields that the message does not define are kept as unknown fields, and written
+	// back out by Stream.
+	var test simple.Test
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(marshaled), test.ProtoReflect()))
+	require.NotEmpty(t, test.ProtoReflect().GetUnknown())
+
+	output := bytes.NewBuffer(nil)
+	require.NoError(t, protobridge.Stream(molecule.NewProtoStream(output), test.ProtoReflect()))
+	var roundTripped simple.Simple
+	require.NoError(t, proto.Unmarshal(output.Bytes(), &roundTripped))
+	require.True(t, proto.Equal(m, &roundTripped))
+
+	// Strings are copied out of the buffer.
+	var copied simple.Simple
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(marshaled), copied.ProtoReflect()))
+	for i := range marshaled {
+		marshaled[i] = 0
+	}
+	require.Equal(t, "hello", copied.String_)
+
+	// Like proto.Unmarshal, fields with mismatched wire types are kept as unknown fields,
+	// and 32-bit varints that overflow are truncated.
+	var (
+		mismatched = []byte{14 << 3, 1, 3 << 3, 0x81, 0x80, 0x80, 0x80, 0x10}
+		expected   = new(simple.Simple)
+		actual     = new(simple.Simple)
+	)
+	require.NoError(t, proto.Unmarshal(mismatched, expected))
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(mismatched), actual.ProtoReflect()))
+	require.True(t, proto.Equal(expected, actual))
+	require.Equal(t, []byte{14 << 3, 1}, []byte(actual.ProtoReflect().GetUnknown()))
+	require.Equal(t, int32(1), actual.Int32)
+}
+
+// closedEnumSchema declares a proto2 message with closed enum fields of every kind.
+const closedEnumSchema = `
+name: "closed.proto" package: "closed" syntax: "proto2"
+enum_type { name: "Color" value { name: "NONE" number: 0 } value { name: "RED" number: 1 } value { name: "GREEN" number: 2 } }
+message_type {
+	name: "Enums"
+	field { name: "color" number: 1 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".closed.Color" }
+	field { name: "unpacked" number: 2 label: LABEL_REPEATED type: TYPE_ENUM type_name: ".closed.Color" }
+	field { name: "packed" number: 3 label: LABEL_REPEATED type: TYPE_ENUM type_name: ".closed.Color" options { packed: true } }
+	field { name: "by_id" number: 4 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".closed.Enums.ByIdEntry" }
+	nested_type {
+		name: "ByIdEntry"
+		field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_INT32 }
+		field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".closed.Color" }
+		options { map_entry: true }
+	}
+}
+`
+
+// Test that values of closed enums that the enum does not declare are added to the
+// unknown fields instead of being set.
+func TestProtoBridgeClosedEnums(t *testing.T) {
+	var fdp descriptorpb.FileDescriptorProto
+	require.NoError(t, prototext.Unmarshal([]byte(closedEnumSchema), &fdp))
+	file, err := protodesc.NewFile(&fdp, nil)
+	require.NoError(t, err)
+	md := file.Messages().ByName("Enums")
+
+	var (
+		input, unknown []byte
+		varintField    = func(num protowire.Number, v uint64) []byte {
+			return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), v)
+		}
+		entry = func(key, value uint64) []byte {
+			b := append(varintField(1, key), varintField(2, value)...)
+			return protowire.AppendBytes(protowire.AppendTag(nil, 4, protowire.BytesType), b)
+		}
+	)
+	input = append(input, varintField(1, 99)...)
+	unknown = append(unknown, varintField(1, 99)...)
+	input = append(input, varintField(2, 1)...)
+	input = append(input, varintField(2, 99)...)
+	unknown = append(unknown, varintField(2, 99)...)
+	input = protowire.AppendTag(input, 3, protowire.BytesType)
+	input = protowire.AppendBytes(input, []byte{2, 99, 1})
+	unknown = append(unknown, varintField(3, 99)...)
+	input = append(input, entry(7, 99)...)
+	unknown = append(unknown, entry(7, 99)...)
+	input = append(input, entry(8, 1)...)
+
+	m := dynamicpb.NewMessage(md)
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(input), m))
+
+	fields := md.Fields()
+	require.False(t, m.Has(fields.ByName("color")))
+	unpacked := m.Get(fields.ByName("unpacked")).List()
+	require.Equal(t, 1, unpacked.Len())
+	require.Equal(t, protoreflect.EnumNumber(1), unpacked.Get(0).Enum())
+	packed := m.Get(fields.ByName("packed")).List()
+	require.Equal(t, 2, packed.Len())
+	require.Equal(t, protoreflect.EnumNumber(2), packed.Get(0).Enum())
+	require.Equal(t, protoreflect.EnumNumber(1), packed.Get(1).Enum())
+	byID := m.Get(fields.ByName("by_id")).Map()
+	require.Equal(t, 1, byID.Len())
+	require.Equal(t, protoreflect.EnumNumber(1), byID.Get(protoreflect.ValueOfInt32(8).MapKey()).Enum())
+	require.Equal(t, unknown, []byte(m.GetUnknown()))
+}
+
+// editionsEnumSchema declares an editions file, where enums are open unless they opt in
+// to closed semantics, so the syntax of the file does not tell whether an enum is closed.
+const editionsEnumSchema = `
+name: "editions.proto" package: "editions" syntax: "editions" edition: EDITION_2023
+enum_type { name: "Open" value { name: "OPEN_NONE" number: 0 } }
+enum_type { name: "Closed" value { name: "CLOSED_NONE" number: 0 } options { features { enum_type: CLOSED } } }
+message_type {
+	name: "Enums"
+	field { name: "open" number: 1 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".editions.Open" }
+	field { name: "closed" number: 2 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".editions.Closed" }
+}
+`
+
+func TestProtoBridgeEditionsEnums(t *testing.T) {
+	var fdp descriptorpb.FileDescriptorProto
+	require.NoError(t, prototext.Unmarshal([]byte(editionsEnumSchema), &fdp))
+	file, err := protodesc.NewFile(&fdp, nil)
+	require.NoError(t, err)
+	md := file.Messages().ByName("Enums")
+
+	var input []byte
+	input = protowire.AppendVarint(protowire.AppendTag(input, 1, protowire.VarintType), 99)
+	closed := protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 99)
+	input = append(input, closed...)
+
+	m := dynamicpb.NewMessage(md)
+	require.NoError(t, protobridge.Populate(codec.NewBuffer(input), m))
+	require.Equal(t, protoreflect.EnumNumber(99), m.Get(md.Fields().ByName("open")).Enum())
+	require.False(t, m.Has(md.Fields().ByName("closed")))
+	require.Equal(t, closed, []byte(m.GetUnknown()))
+}
@@ -0,0 +1,588 @@
+package moleculetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// otlpSchema is a trimmed down copy of the OpenTelemetry trace and metric protocols,
+// which are typical of the large, deeply nested messages that molecule is used for.
+// There is no generated code for it, so proto.Unmarshal decodes it into dynamicpb
+// messages.
+const otlpSchema = `
+name: "otlp.proto" package: "otlp" syntax: "proto3"
+message_type {
+	name: "AnyValue"
+	field { name: "string_value" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING oneof_index: 0 }
+	field { name: "bool_value" number: 2 label: LABEL_OPTIONAL type: TYPE_BOOL oneof_index: 0 }
+	field { name: "int_value" number: 3 label: LABEL_OPTIONAL type: TYPE_INT64 oneof_index: 0 }
+	field { name: "double_value" number: 4 label: LABEL_OPTIONAL type: TYPE_DOUBLE oneof_index: 0 }
+	oneof_decl { name: "value" }
+}
+message_type {
+	name: "KeyValue"
+	field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.AnyValue" }
+}
+message_type {
+	name: "Resource"
+	field { name: "attributes" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+	field { name: "dropped_attributes_count" number: 2 label: LABEL_OPTIONAL type: TYPE_UINT32 }
+}
+message_type {
+	name: "InstrumentationScope"
+	field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "version" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+}
+message_type {
+	name: "Event"
+	field { name: "time_unix_nano" number: 1 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "name" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "attributes" number: 3 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+}
+message_type {
+	name: "Status"
+	field { name: "message" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "code" number: 3 label: LABEL_OPTIONAL type: TYPE_INT32 }
+}
+message_type {
+	name: "Span"
+	field { name: "trace_id" number: 1 label: LABEL_OPTIONAL type: TYPE_BYTES }
+	field { name: "span_id" number: 2 label: LABEL_OPTIONAL type: TYPE_BYTES }
+	field { name: "parent_span_id" number: 4 label: LABEL_OPTIONAL type: TYPE_BYTES }
+	field { name: "name" number: 5 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "kind" number: 6 label: LABEL_OPTIONAL type: TYPE_INT32 }
+	field { name: "start_time_unix_nano" number: 7 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "end_time_unix_nano" number: 8 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "attributes" number: 9 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+	field { name: "events" number: 11 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.Event" }
+	field { name: "status" number: 15 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Status" }
+}
+message_type {
+	name: "ScopeSpans"
+	field { name: "scope" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.InstrumentationScope" }
+	field { name: "spans" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.Span" }
+}
+message_type {
+	name: "ResourceSpans"
+	field { name: "resource" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Resource" }
+	field { name: "scope_spans" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.ScopeSpans" }
+}
+message_type {
+	name: "TracesData"
+	field { name: "resource_spans" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.ResourceSpans" }
+}
+message_type {
+	name: "NumberDataPoint"
+	field { name: "start_time_unix_nano" number: 2 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "time_unix_nano" number: 3 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "as_double" number: 4 label: LABEL_OPTIONAL type: TYPE_DOUBLE }
+	field { name: "attributes" number: 7 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+}
+message_type {
+	name: "HistogramDataPoint"
+	field { name: "start_time_unix_nano" number: 2 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "time_unix_nano" number: 3 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "count" number: 4 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+	field { name: "sum" number: 5 label: LABEL_OPTIONAL type: TYPE_DOUBLE }
+	field { name: "bucket_counts" number: 6 label: LABEL_REPEATED type: TYPE_FIXED64 }
+	field { name: "explicit_bounds" number: 7 label: LABEL_REPEATED type: TYPE_DOUBLE }
+	field { name: "attributes" number: 9 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+}
+message_type {
+	name: "Gauge"
+	field { name: "data_points" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.NumberDataPoint" }
+}
+message_type {
+	name: "Histogram"
+	field { name: "data_points" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.HistogramDataPoint" }
+}
+message_type {
+	name: "Metric"
+	field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "description" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "unit" number: 3 label: LABEL_OPTIONAL type: TYPE_STRING }
+	field { name: "gauge" number: 5 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Gauge" }
+	field { name: "histogram" number: 9 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Histogram" }
+}
+message_type {
+	name: "ScopeMetrics"
+	field { name: "scope" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.InstrumentationScope" }
+	field { name: "metrics" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.Metric" }
+}
+message_type {
+	name: "ResourceMetrics"
+	field { name: "resource" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Resource" }
+	field { name: "scope_metrics" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.ScopeMetrics" }
+}
+message_type {
+	name: "MetricsData"
+	field { name: "resource_metrics" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.ResourceMetrics" }
+}
+`
+
+// otlpFile parses otlpSchema.
+func otlpFile() protoreflect.FileDescriptor {
+	var fdp descriptorpb.FileDescriptorProto
+	noErr(prototext.Unmarshal([]byte(otlpSchema), &fdp))
+	fd, err := protodesc.NewFile(&fdp, nil)
+	noErr(err)
+	return fd
+}
+
+// A benchPayload is a realistic encoded message, along with everything needed to decode
+// it with proto.Unmarshal, protowire and molecule.
+type benchPayload struct {
+	name    string
+	encoded []byte
+	// newMessage returns the message that proto.Unmarshal decodes encoded into.
+	newMessage func() proto.Message
+	schema     *walkSchema
+}
+
+// marshalJSON builds a message of type md from v, which is marshaled to JSON first so
+// that large messages can be described with plain Go maps and slices.
+func marshalJSON(md protoreflect.MessageDescriptor, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	noErr(err)
+	m := dynamicpb.NewMessage(md)
+	noErr(protojson.Unmarshal(b, m))
+	encoded, err := proto.Marshal(m)
+	noErr(err)
+	return encoded
+}
+
+func otlpAttributes(n int, prefix string) []interface{} {
+	attributes := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		var value map[string]interface{}
+		switch i % 4 {
+		case 0:
+			value = map[string]interface{}{"stringValue": fmt.Sprintf("%s-value-%d", prefix, i)}
+		case 1:
+			value = map[string]interface{}{"intValue": i * 1000003}
+		case 2:
+			value = map[string]interface{}{"doubleValue": float64(i) / 3}
+		case 3:
+			value = map[string]interface{}{"boolValue": true}
+		}
+		attributes = append(attributes, map[string]interface{}{"key": fmt.Sprintf("%s.attribute.%d", prefix, i), "value": value})
+	}
+	return attributes
+}
+
+func otlpResource() map[string]interface{} {
+	return map[string]interface{}{"attributes": otlpAttributes(12, "resource")}
+}
+
+func otlpID(n, seed int) string {
+	id := make([]byte, n)
+	for i := range id {
+		id[i] = byte(seed*31 + i*7)
+	}
+	return base64.StdEncoding.EncodeToString(id)
+}
+
+// otlpTraces returns a batch of 2 resources with 4 scopes of 64 spans each.
+func otlpTraces(file protoreflect.FileDescriptor) benchPayload {
+	var resourceSpans []interface{}
+	for r := 0; r < 2; r++ {
+		var scopeSpans []interface{}
+		for s := 0; s < 4; s++ {
+			var spans []interface{}
+			for i := 0; i < 64; i++ {
+				start := 1700000000000000000 + i*1000
+				spans = append(spans, map[string]interface{}{
+					"traceId":           otlpID(16, i),
+					"spanId":            otlpID(8, i),
+					"parentSpanId":      otlpID(8, i+1),
+					"name":              fmt.Sprintf("HTTP GET /api/v1/resource/%d", i),
+					"kind":              2,
+					"startTimeUnixNano": fmt.Sprint(start),
+					"endTimeUnixNano":   fmt.Sprint(start + 12345),
+					"attributes":        otlpAttributes(8, "span"),
+					"events": []interface{}{
+						map[string]interface{}{"timeUnixNano": fmt.Sprint(start + 10), "name": "request.sent", "attributes": otlpAttributes(2, "event")},
+						map[string]interface{}{"timeUnixNano": fmt.Sprint(start + 20), "name": "response.received", "attributes": otlpAttributes(2, "event")},
+					},
+					"status": map[string]interface{}{"code": 1, "message": "OK"},
+				})
+			}
+			scopeSpans = append(scopeSpans, map[string]interface{}{
+				"scope": map[string]interface{}{"name": "io.opentelemetry.http", "version": "1.2.3"},
+				"spans": spans,
+			})
+		}
+		resourceSpans = append(resourceSpans, map[string]interface{}{"resource": otlpResource(), "scopeSpans": scopeSpans})
+	}
+
+	md := file.Messages().ByName("TracesData")
+	return benchPayload{
+		name:       "otlp traces",
+		encoded:    marshalJSON(md, map[string]interface{}{"resourceSpans": resourceSpans}),
+		newMessage: func() proto.Message { return dynamicpb.NewMessage(md) },
+		schema:     newWalkSchema(md),
+	}
+}
+
+// otlpMetrics returns a batch of gauges and histograms, whose bucket counts and bounds
+// are packed repeated fields.
+func otlpMetrics(file protoreflect.FileDescriptor) benchPayload {
+	var metrics []interface{}
+	for i := 0; i < 32; i++ {
+		var gaugePoints, histogramPoints []interface{}
+		for p := 0; p < 16; p++ {
+			gaugePoints = append(gaugePoints, map[string]interface{}{
+				"startTimeUnixNano": "1700000000000000000",
+				"timeUnixNano":      fmt.Sprint(1700000000000000000 + p),
+				"asDouble":          float64(p) * 1.5,
+				"attributes":        otlpAttributes(4, "point"),
+			})
+			var (
+				bucketCounts   []string
+				explicitBounds []float64
+			)
+			for b := 0; b < 64; b++ {
+				bucketCounts = append(bucketCounts, fmt.Sprint(b*p))
+				explicitBounds = append(explicitBounds, float64(b)*0.25)
+			}
+			histogramPoints = append(histogramPoints, map[string]interface{}{
+				"startTimeUnixNano": "1700000000000000000",
+				"timeUnixNano":      fmt.Sprint(1700000000000000000 + p),
+				"count":             fmt.Sprint(64 * p),
+				"sum":               float64(p) * 100,
+				"bucketCounts":      bucketCounts,
+				"explicitBounds":    explicitBounds,
+				"attributes":        otlpAttributes(4, "point"),
+			})
+		}
+		metrics = append(metrics,
+			map[string]interface{}{"name": fmt.Sprintf("system.cpu.utilization.%d", i), "unit": "1", "gauge": map[string]interface{}{"dataPoints": gaugePoints}},
+			map[string]interface{}{"name": fmt.Sprintf("http.server.duration.%d", i), "unit": "ms", "histogram": map[string]interface{}{"dataPoints": histogramPoints}},
+		)
+	}
+
+	md := file.Messages().ByName("MetricsData")
+	return benchPayload{
+		name: "otlp metrics",
+		encoded: marshalJSON(md, map[string]interface{}{"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     otlpResource(),
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": map[string]interface{}{"name": "io.opentelemetry.runtime"}, "metrics": metrics}},
+		}}}),
+		newMessage: func() proto.Message { return dynamicpb.NewMessage(md) },
+		schema:     newWalkSchema(md),
+	}
+}
+
+// packedArrays returns a Simple message with a packed repeated field of 64k varints.
+func packedArrays() benchPayload {
+	m := &simple.Simple{String_: "packed"}
+	for i := 0; i < 1<<16; i++ {
+		v := int64(i % 100)
+		if i%4 == 0 {
+			v = int64(i) * 1000003
+		}
+		m.RepeatedInt64Packed = append(m.RepeatedInt64Packed, v)
+	}
+	encoded, err := proto.Marshal(m)
+	noErr(err)
+	return benchPayload{
+		name:       "packed arrays",
+		encoded:    encoded,
+		newMessage: func() proto.Message { return new(simple.Simple) },
+		schema:     newWalkSchema(m.ProtoReflect().Descriptor()),
+	}
+}
+
+// mapsAndNesting returns a google.protobuf.Struct, whose fields are a map, with wide
+// maps and lists nested 32 levels deep.
+func mapsAndNesting() benchPayload {
+	var nest func(depth int) interface{}
+	nest = func(depth int) interface{} {
+		fields := map[string]interface{}{}
+		for i := 0; i < 8; i++ {
+			fields[fmt.Sprintf("key-%d", i)] = fmt.Sprintf("value-%d-%d", depth, i)
+			fields[fmt.Sprintf("number-%d", i)] = float64(depth*i) / 7
+		}
+		fields["list"] = []interface{}{true, nil, "item", float64(depth)}
+		if depth > 0 {
+			fields["child"] = nest(depth - 1)
+		}
+		return fields
+	}
+	s, err := structpb.NewStruct(nest(32).(map[string]interface{}))
+	noErr(err)
+	encoded, err := proto.Marshal(s)
+	noErr(err)
+	return benchPayload{
+		name:       "maps and deep nesting",
+		encoded:    encoded,
+		newMessage: func() proto.Message { return new(structpb.Struct) },
+		schema:     newWalkSchema(s.ProtoReflect().Descriptor()),
+	}
+}
+
+// descriptors returns the descriptors of every file linked into the tests, which is a
+// large message with many levels of nesting and many small fields.
+func descriptors() benchPayload {
+	set := new(descriptorpb.FileDescriptorSet)
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+		return true
+	})
+	encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+	noErr(err)
+	return benchPayload{
+		name:       "descriptors",
+		encoded:    encoded,
+		newMessage: func() proto.Message { return new(descriptorpb.FileDescriptorSet) },
+		schema:     newWalkSchema(set.ProtoReflect().Descriptor()),
+	}
+}
+
+func benchPayloads() []benchPayload {
+	file := otlpFile()
+	return []benchPayload{otlpTraces(file), otlpMetrics(file), packedArrays(), mapsAndNesting(), descriptors()}
+}
+
+// A walkSchema tells the walkers which fields of a message hold embedded messages or
+// packed repeated fields, indexed by field number.
+type walkSchema struct {
+	messages []*walkSchema
+	packed   []codec.FieldType
+}
+
+func newWalkSchema(md protoreflect.MessageDescriptor) *walkSchema {
+	return buildWalkSchema(md, map[protoreflect.FullName]*walkSchema{})
+}
+
+func buildWalkSchema(md protoreflect.MessageDescriptor, seen map[protoreflect.FullName]*walkSchema) *walkSchema {
+	if s, ok := seen[md.FullName()]; ok {
+		return s
+	}
+	var (
+		s      = new(walkSchema)
+		fields = md.Fields()
+		max    protoreflect.FieldNumber
+	)
+	seen[md.FullName()] = s
+	for i := 0; i < fields.Len(); i++ {
+		if n := fields.Get(i).Number(); n > max {
+			max = n
+		}
+	}
+	s.messages = make([]*walkSchema, max+1)
+	s.packed = make([]codec.FieldType, max+1)
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		switch {
+		case fd.Message() != nil:
+			s.messages[fd.Number()] = buildWalkSchema(fd.Message(), seen)
+		case fd.IsPacked():
+			s.packed[fd.Number()] = codec.FieldType(fd.Kind())
+		}
+	}
+	return s
+}
+
+func (s *walkSchema) message(fieldNum int32) *walkSchema {
+	if int(fieldNum) < len(s.messages) {
+		return s.messages[fieldNum]
+	}
+	return nil
+}
+
+func (s *walkSchema) packedType(fieldNum int32) codec.FieldType {
+	if int(fieldNum) < len(s.packed) {
+		return s.packed[fieldNum]
+	}
+	return 0
+}
+
+// A walkResult summarizes every value in a message, so that the walkers can be checked
+// against each other and so that the compiler can't skip their work.
+type walkResult struct {
+	fields  int
+	numbers uint64
+	bytes   int
+}
+
+// moleculeWalker visits every value in a message with MessageEach, descending into
+// embedded messages and packed repeated fields.  It keeps a buffer for each level of
+// nesting, so that it does not allocate once it has seen the deepest message.
+type moleculeWalker struct {
+	buffers []codec.Buffer
+	result  walkResult
+}
+
+func (w *moleculeWalker) walk(b []byte, schema *walkSchema) error {
+	w.result = walkResult{}
+	return w.message(0, b, schema)
+}
+
+func (w *moleculeWalker) message(depth int, b []byte, schema *walkSchema) error {
+	if depth == len(w.buffers) {
+		w.buffers = append(w.buffers, codec.Buffer{})
+	}
+	buffer := &w.buffers[depth]
+	buffer.Reset(b)
+	return molecule.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+		w.result.fields++
+		if value.WireType != codec.WireBytes {
+			w.result.numbers += value.Number
+			return true, nil
+		}
+		if child := schema.message(fieldNum); child != nil {
+			return true, w.message(depth+1, value.Bytes, child)
+		}
+		if typ := schema.packedType(fieldNum); typ != 0 {
+			// The buffer of the next level is free, since packed fields are leaves.
+			return true, w.packed(depth+1, value.Bytes, typ)
+		}
+		w.result.bytes += len(value.Bytes)
+		return true, nil
+	})
+}
+
+func (w *moleculeWalker) packed(depth int, b []byte, typ codec.FieldType) error {
+	if depth == len(w.buffers) {
+		w.buffers = append(w.buffers, codec.Buffer{})
+	}
+	buffer := &w.buffers[depth]
+	buffer.Reset(b)
+	return molecule.PackedRepeatedEach(buffer, typ, func(value molecule.Value) (bool, error) {
+		w.result.fields++
+		w.result.numbers += value.Number
+		return true, nil
+	})
+}
+
+// protowireWalk visits every value in a message like moleculeWalker, using the
+// protowire package of the official protobuf library.
+func protowireWalk(b []byte, schema *walkSchema, result *walkResult) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		result.fields++
+
+		var number uint64
+		switch typ {
+		case protowire.VarintType:
+			number, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			number = uint64(v)
+		case protowire.Fixed64Type:
+			number, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			if n < 0 {
+				break
+			}
+			if child := schema.message(int32(num)); child != nil {
+				if err := protowireWalk(v, child, result); err != nil {
+					return err
+				}
+			} else if packedType := schema.packedType(int32(num)); packedType != 0 {
+				if err := protowireWalkPacked(v, packedType, result); err != nil {
+					return err
+				}
+			} else {
+				result.bytes += len(v)
+			}
+		default:
+			return fmt.Errorf("unsupported wire type: %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		result.numbers += number
+		b = b[n:]
+	}
+	return nil
+}
+
+func protowireWalkPacked(b []byte, typ codec.FieldType, result *walkResult) error {
+	for len(b) > 0 {
+		var (
+			number uint64
+			n      int
+		)
+		switch typ {
+		case codec.FieldType_FIXED32, codec.FieldType_SFIXED32, codec.FieldType_FLOAT:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			number = uint64(v)
+		case codec.FieldType_FIXED64, codec.FieldType_SFIXED64, codec.FieldType_DOUBLE:
+			number, n = protowire.ConsumeFixed64(b)
+		default:
+			number, n = protowire.ConsumeVarint(b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		result.fields++
+		result.numbers += number
+		b = b[n:]
+	}
+	return nil
+}
+
+// BenchmarkRealistic decodes realistic messages with proto.Unmarshal, which builds the
+// whole message, and visits every value in them with protowire and with molecule.
+func BenchmarkRealistic(b *testing.B) {
+	for _, payload := range benchPayloads() {
+		payload := payload
+		b.Run(payload.name, func(b *testing.B) {
+			b.Run("proto.Unmarshal", func(b *testing.B) {
+				m := payload.newMessage()
+				b.SetBytes(int64(len(payload.encoded)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					proto.Reset(m)
+					noErr(proto.Unmarshal(payload.encoded, m))
+				}
+			})
+
+			b.Run("protowire", func(b *testing.B) {
+				b.SetBytes(int64(len(payload.encoded)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					var result walkResult
+					noErr(protowireWalk(payload.encoded, payload.schema, &result))
+				}
+			})
+
+			b.Run("molecule", func(b *testing.B) {
+				var w moleculeWalker
+				b.SetBytes(int64(len(payload.encoded)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					noErr(w.walk(payload.encoded, payload.schema))
+				}
+			})
+		})
+	}
+}
@@ -3,13 +3,17 @@ package moleculetest
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"math"
 	"testing"
 	"time"
 
 	fuzz "github.com/google/gofuzz"
 	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
 	simple "github.com/richardartoul/molecule/src/proto"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"gotest.tools/assert"
 )
@@ -143,6 +147,72 @@ func TestPackedEncodingZero(t *testing.T) {
 	require.Equal(t, 0, len(buf))
 }
 
+// Test that zero values are written when EmitZeroValues is set, including in
+// embedded messages.
+func TestEmitZeroValues(t *testing.T) {
+	output := bytes.NewBuffer([]byte{})
+	ps := molecule.NewProtoStream(output)
+	ps.EmitZeroValues = true
+
+	writeZeros := func(ps *molecule.ProtoStream) error {
+		require.NoError(t, ps.Double(fieldDouble, 0))
+		require.NoError(t, ps.Float(fieldFloat, 0))
+		require.NoError(t, ps.Int32(fieldInt32, 0))
+		require.NoError(t, ps.Int64(fieldInt64, 0))
+		require.NoError(t, ps.Uint32(fieldUint32, 0))
+		require.NoError(t, ps.Uint64(fieldUint64, 0))
+		require.NoError(t, ps.Sint32(fieldSint32, 0))
+		require.NoError(t, ps.Sint64(fieldSint64, 0))
+		require.NoError(t, ps.Fixed32(fieldFixed32, 0))
+		require.NoError(t, ps.Fixed64(fieldFixed64, 0))
+		require.NoError(t, ps.Sfixed32(fieldSfixed32, 0))
+		require.NoError(t, ps.Sfixed64(fieldSfixed64, 0))
+		require.NoError(t, ps.Bool(fieldBool, false))
+		require.NoError(t, ps.String(fieldString, ""))
+		require.NoError(t, ps.Bytes(fieldBytes, nil))
+		// Empty packed fields are still not written.
+		return ps.Int64Packed(fieldRepeatedInt64Packed, nil)
+	}
+	expected := []byte{
+		0x09, 0, 0, 0, 0, 0, 0, 0, 0, // double
+		0x15, 0, 0, 0, 0, // float
+		0x18, 0, // int32
+		0x20, 0, // int64
+		0x28, 0, // uint32
+		0x30, 0, // uint64
+		0x38, 0, // sint32
+		0x40, 0, // sint64
+		0x4d, 0, 0, 0, 0, // fixed32
+		0x51, 0, 0, 0, 0, 0, 0, 0, 0, // fixed64
+		0x5d, 0, 0, 0, 0, // sfixed32
+		0x61, 0, 0, 0, 0, 0, 0, 0, 0, // sfixed64
+		0x68, 0, // bool
+		0x72, 0, // string
+		0x7a, 0, // bytes
+	}
+
+	require.NoError(t, writeZeros(ps))
+	require.Equal(t, expected, output.Bytes())
+
+	// The flag is inherited by embedded messages, regardless of the writer.
+	for _, w := range []interface {
+		io.Writer
+		Bytes() []byte
+	}{bytes.NewBuffer(nil), molecule.NewOutputBuffer(nil)} {
+		ps.Reset(w)
+		require.NoError(t, ps.Embedded(1, writeZeros))
+		require.Equal(t, append([]byte{0x0a, byte(len(expected))}, expected...), w.Bytes())
+	}
+
+	// Zero values are skipped once the flag is unset.
+	output.Reset()
+	ps.Reset(output)
+	ps.EmitZeroValues = false
+	require.NoError(t, writeZeros(ps))
+	require.NoError(t, ps.Embedded(1, writeZeros))
+	require.Equal(t, []byte{0x0a, 0}, output.Bytes())
+}
+
 // Test that *Packed functions work.
 func TestPacking(t *testing.T) {
 	output := bytes.NewBuffer([]byte{})
@@ -300,6 +370,179 @@ func TestPacking(t *testing.T) {
 	})
 }
 
+// Test that *Expanded functions write a key for every value, including zero
+// values, and handle slices that are much larger than the scratch buffer.
+func TestExpanded(t *testing.T) {
+	const numValues = 10000
+	var (
+		output = &maxWriteRecorder{}
+		ps     = molecule.NewProtoStream(output)
+
+		int64s   = make([]int64, 0, numValues)
+		uint32s  = make([]uint32, 0, numValues)
+		float32s = make([]float32, 0, numValues)
+		bools    = make([]bool, 0, numValues)
+		expected []byte
+	)
+	for i := 0; i < numValues; i++ {
+		int64s = append(int64s, int64(i%7)*int64(i)*int64(i)*-7919)
+		uint32s = append(uint32s, uint32(i%300))
+		float32s = append(float32s, float32(i%5)*1.5)
+		bools = append(bools, i%3 == 0)
+	}
+
+	require.NoError(t, ps.Int64Expanded(fieldInt64, int64s))
+	for _, v := range int64s {
+		expected = protowire.AppendTag(expected, protowire.Number(fieldInt64), protowire.VarintType)
+		expected = protowire.AppendVarint(expected, uint64(v))
+	}
+	require.NoError(t, ps.Sint64Expanded(fieldSint64, int64s))
+	for _, v := range int64s {
+		expected = protowire.AppendTag(expected, protowire.Number(fieldSint64), protowire.VarintType)
+		expected = protowire.AppendVarint(expected, protowire.EncodeZigZag(v))
+	}
+	require.NoError(t, ps.Sfixed64Expanded(fieldSfixed64, int64s))
+	for _, v := range int64s {
+		expected = protowire.AppendTag(expected, protowire.Number(fieldSfixed64), protowire.Fixed64Type)
+		expected = protowire.AppendFixed64(expected, uint64(v))
+	}
+	require.NoError(t, ps.Uint32Expanded(fieldUint32, uint32s))
+	for _, v := range uint32s {
+		expected = protowire.AppendTag(expected, protowire.Number(fieldUint32), protowire.VarintType)
+		expected = protowire.AppendVarint(expected, uint64(v))
+	}
+	require.NoError(t, ps.FloatExpanded(fieldFloat, float32s))
+	for _, v := range float32s {
+		expected = protowire.AppendTag(expected, protowire.Number(fieldFloat), protowire.Fixed32Type)
+		expected = protowire.AppendFixed32(expected, math.Float32bits(v))
+	}
+	require.NoError(t, ps.BoolExpanded(fieldBool, bools))
+	for _, v := range bools {
+		expected = protowire.AppendTag(expected, protowire.Number(fieldBool), protowire.VarintType)
+		expected = protowire.AppendVarint(expected, protowire.EncodeBool(v))
+	}
+	require.NoError(t, ps.Int32Expanded(fieldInt32, nil))
+
+	require.True(t, output.maxWrite <= 8*1024, "largest write was %d bytes", output.maxWrite)
+	require.True(t, bytes.Equal(expected, output.Bytes()))
+
+	// Parsers must accept expanded encodings of packed fields.
+	output.Reset()
+	require.NoError(t, ps.Int64Expanded(fieldRepeatedInt64Packed, int64s))
+	var res simple.Simple
+	require.NoError(t, proto.Unmarshal(output.Bytes(), &res))
+	require.Equal(t, int64s, res.RepeatedInt64Packed)
+}
+
+// Test ps.Group, including nested groups and embedded messages.
+func TestGroup(t *testing.T) {
+	output := bytes.NewBuffer([]byte{})
+	ps := molecule.NewProtoStream(output)
+
+	require.NoError(t, ps.Group(5, func(ps *molecule.ProtoStream) error {
+		if err := ps.Int64(1, 7); err != nil {
+			return err
+		}
+		if err := ps.Group(2, func(ps *molecule.ProtoStream) error {
+			return ps.String(1, "a")
+		}); err != nil {
+			return err
+		}
+		return ps.Embedded(3, func(ps *molecule.ProtoStream) error {
+			return ps.Bool(1, true)
+		})
+	}))
+	require.Equal(t, []byte{
+		0x2b,       // start group 5
+		0x08, 0x07, // field 1: 7
+		0x13,             // start group 2
+		0x0a, 0x01, 0x61, // field 1: "a"
+		0x14,                   // end group 2
+		0x1a, 0x02, 0x08, 0x01, // field 3: embedded message
+		0x2c, // end group 5
+	}, output.Bytes())
+
+	// Errors from the inner function are returned.
+	require.Error(t, ps.Group(5, func(ps *molecule.ProtoStream) error {
+		return fmt.Errorf("inner failed")
+	}))
+}
+
+// maxWriteRecorder is an io.Writer that records the size of the largest write.
+type maxWriteRecorder struct {
+	bytes.Buffer
+	maxWrite int
+}
+
+func (w *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.maxWrite {
+		w.maxWrite = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+// Test that *Packed functions handle slices that are much larger than the
+// scratch buffer, streaming them out in bounded writes.
+func TestPackingLarge(t *testing.T) {
+	const numValues = 100000
+	var (
+		output = &maxWriteRecorder{}
+		ps     = molecule.NewProtoStream(output)
+
+		int64s   = make([]int64, 0, numValues)
+		uint32s  = make([]uint32, 0, numValues)
+		float64s = make([]float64, 0, numValues)
+		bools    = make([]bool, 0, numValues)
+	)
+	for i := 0; i < numValues; i++ {
+		// Mix small and large values so varints of every size are exercised.
+		int64s = append(int64s, int64(i)*int64(i)*int64(i)*-7919)
+		uint32s = append(uint32s, uint32(i%300))
+		float64s = append(float64s, float64(i)*1.5)
+		bools = append(bools, i%3 == 0)
+	}
+
+	require.NoError(t, ps.Int64Packed(fieldInt64, int64s))
+	require.NoError(t, ps.Sint64Packed(fieldSint64, int64s))
+	require.NoError(t, ps.Uint32Packed(fieldUint32, uint32s))
+	require.NoError(t, ps.DoublePacked(fieldDouble, float64s))
+	require.NoError(t, ps.BoolPacked(fieldBool, bools))
+	require.True(t, output.maxWrite <= 8*1024, "largest write was %d bytes", output.maxWrite)
+
+	var expected []byte
+	appendPacked := func(fieldNumber int, payload []byte) {
+		expected = protowire.AppendTag(expected, protowire.Number(fieldNumber), protowire.BytesType)
+		expected = protowire.AppendBytes(expected, payload)
+	}
+	var payload []byte
+	for _, v := range int64s {
+		payload = protowire.AppendVarint(payload, uint64(v))
+	}
+	appendPacked(fieldInt64, payload)
+	payload = payload[:0]
+	for _, v := range int64s {
+		payload = protowire.AppendVarint(payload, protowire.EncodeZigZag(v))
+	}
+	appendPacked(fieldSint64, payload)
+	payload = payload[:0]
+	for _, v := range uint32s {
+		payload = protowire.AppendVarint(payload, uint64(v))
+	}
+	appendPacked(fieldUint32, payload)
+	payload = payload[:0]
+	for _, v := range float64s {
+		payload = protowire.AppendFixed64(payload, math.Float64bits(v))
+	}
+	appendPacked(fieldDouble, payload)
+	payload = payload[:0]
+	for _, v := range bools {
+		payload = protowire.AppendVarint(payload, protowire.EncodeBool(v))
+	}
+	appendPacked(fieldBool, payload)
+
+	require.True(t, bytes.Equal(expected, output.Bytes()))
+}
+
 // Microbenchmark simple encoding performance
 func BenchmarkSimple(b *testing.B) {
 	output := bytes.NewBuffer([]byte{})
@@ -355,6 +598,52 @@ func BenchmarkPacking(b *testing.B) {
 	}
 }
 
+// Microbenchmark embedding performance for deeply nested messages
+func BenchmarkEmbedding(b *testing.B) {
+	const depth = 10
+	var (
+		str    = string(bytes.Repeat([]byte("x"), 100000))
+		levels = make([]func(*molecule.ProtoStream) error, depth)
+	)
+	levels[0] = func(ps *molecule.ProtoStream) error {
+		return ps.String(fieldString, str)
+	}
+	for i := 1; i < depth; i++ {
+		child := levels[i-1]
+		levels[i] = func(ps *molecule.ProtoStream) error {
+			if err := ps.Int64(fieldInt64, int64(i)); err != nil {
+				return err
+			}
+			return ps.Embedded(1, child)
+		}
+	}
+	inner := levels[depth-1]
+
+	b.Run("bytes.Buffer", func(b *testing.B) {
+		output := bytes.NewBuffer([]byte{})
+		ps := molecule.NewProtoStream(output)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			output.Reset()
+			if err := ps.Embedded(1, inner); err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	b.Run("OutputBuffer", func(b *testing.B) {
+		output := molecule.NewOutputBuffer(nil)
+		ps := molecule.NewProtoStream(output)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			output.Reset()
+			if err := ps.Embedded(1, inner); err != nil {
+				panic(err)
+			}
+		}
+	})
+}
+
 // Test ps.Embedded embedding a repeated message
 func TestEmbedding(t *testing.T) {
 	output := bytes.NewBuffer([]byte{})
@@ -403,6 +692,76 @@ func TestEmbedding(t *testing.T) {
 	require.Equal(t, []int64{104, 101, 108, 108, 111}, res.NestedMessage.RepeatedInt64Field)
 }
 
+// Test that ps.Embedded produces the same output when writing directly into an
+// OutputBuffer, including when length prefixes need more than one byte.
+func TestEmbeddingOutputBuffer(t *testing.T) {
+	// values copied from the .proto file
+	const fieldStringField = 1
+	const fieldNestedMessage = 1
+
+	// nest wraps a message in depth levels of Nested, padding each level with a string
+	// so that message sizes cross the one, two and three byte length prefix boundaries.
+	var nest func(depth int, padding string) func(*molecule.ProtoStream) error
+	nest = func(depth int, padding string) func(*molecule.ProtoStream) error {
+		return func(ps *molecule.ProtoStream) error {
+			if depth == 0 {
+				return ps.String(fieldStringField, padding)
+			}
+			if err := ps.Embedded(fieldNestedMessage, nest(depth-1, padding)); err != nil {
+				return err
+			}
+			return ps.Int64Packed(2, []int64{int64(depth), int64(len(padding))})
+		}
+	}
+
+	var (
+		expected   = bytes.NewBuffer(nil)
+		expectedPS = molecule.NewProtoStream(expected)
+		output     = molecule.NewOutputBuffer(nil)
+		ps         = molecule.NewProtoStream(output)
+	)
+	for _, size := range []int{0, 1, 100, 127, 128, 1000, 16383, 16384, 100000} {
+		for _, depth := range []int{0, 1, 5} {
+			padding := string(bytes.Repeat([]byte("x"), size))
+			expected.Reset()
+			require.NoError(t, expectedPS.Embedded(fieldNestedMessage, nest(depth, padding)))
+			output.Reset()
+			require.NoError(t, ps.Embedded(fieldNestedMessage, nest(depth, padding)))
+			require.True(t, bytes.Equal(expected.Bytes(), output.Bytes()), "size: %d, depth: %d", size, depth)
+		}
+	}
+
+	// Nothing should be written if the inner function fails.
+	output.Reset()
+	require.NoError(t, ps.String(fieldString, "before"))
+	before := output.Len()
+	err := ps.Embedded(fieldNestedMessage, func(ps *molecule.ProtoStream) error {
+		if err := ps.String(fieldStringField, "partial"); err != nil {
+			return err
+		}
+		return fmt.Errorf("inner failed")
+	})
+	require.Error(t, err)
+	require.Equal(t, before, output.Len())
+
+	// Encoding nested messages into a warmed up buffer should not allocate. The
+	// functions for each level are created up front, since creating them allocates.
+	levels := make([]func(*molecule.ProtoStream) error, 5)
+	levels[0] = func(ps *molecule.ProtoStream) error { return ps.String(fieldStringField, "hello") }
+	for i := 1; i < len(levels); i++ {
+		child := levels[i-1]
+		levels[i] = func(ps *molecule.ProtoStream) error { return ps.Embedded(fieldNestedMessage, child) }
+	}
+	inner := levels[len(levels)-1]
+	allocs := testing.AllocsPerRun(100, func() {
+		output.Reset()
+		if err := ps.Embedded(fieldNestedMessage, inner); err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+}
+
 func TestProtoStreamFuzzing(t *testing.T) {
 	var (
 		seed      = time.Now().UnixNano()
@@ -449,3 +808,96 @@ func TestProtoStreamFuzzing(t *testing.T) {
 		require.True(t, proto.Equal(m, m2))
 	}
 }
+
+// Test that fields decoded with NextField can be written back unchanged with
+// ps.Value and ps.Write.
+func TestPassthrough(t *testing.T) {
+	var (
+		seed      = time.Now().UnixNano()
+		fuzzer    = fuzz.NewWithSeed(seed)
+		numFuzzes = 1000
+	)
+	defer func() {
+		// Log the seed to make debugging failures easier.
+		t.Logf("Running test with seed: %d", seed)
+	}()
+	// Limit slice size to prevent tests from taking too long.
+	fuzzer.NumElements(0, 100)
+
+	var (
+		values   = bytes.NewBuffer(nil)
+		valuesPS = molecule.NewProtoStream(values)
+		raw      = bytes.NewBuffer(nil)
+		rawPS    = molecule.NewProtoStream(raw)
+	)
+	for i := 0; i < numFuzzes; i++ {
+		m := &simple.Simple{}
+		fuzzer.Fuzz(&m)
+		if m == nil {
+			continue
+		}
+		marshaled, err := proto.Marshal(m)
+		require.NoError(t, err)
+
+		values.Reset()
+		raw.Reset()
+		var (
+			buffer = codec.NewBuffer(marshaled)
+			value  molecule.Value
+		)
+		for !buffer.EOF() {
+			fieldNum, field, err := molecule.NextField(buffer, &value)
+			require.NoError(t, err)
+			require.NoError(t, valuesPS.Value(int(fieldNum), value))
+			_, err = rawPS.Write(field)
+			require.NoError(t, err)
+		}
+		require.Equal(t, marshaled, raw.Bytes())
+		require.Equal(t, marshaled, values.Bytes())
+	}
+
+	// Explicit zero values are preserved by ps.Value, and non-minimal varints are
+	// preserved by copying the original encoding.
+	input := []byte{
+		0x18, 0x00, // int32: 0
+		0x72, 0x00, // string: ""
+		0x20, 0x81, 0x80, 0x00, // int64: 1, encoded with padding
+	}
+	values.Reset()
+	raw.Reset()
+	buffer := codec.NewBuffer(input)
+	var value molecule.Value
+	for !buffer.EOF() {
+		fieldNum, field, err := molecule.NextField(buffer, &value)
+		require.NoError(t, err)
+		require.NoError(t, valuesPS.Value(int(fieldNum), value))
+		_, err = rawPS.Write(field)
+		require.NoError(t, err)
+	}
+	require.Equal(t, input, raw.Bytes())
+	require.Equal(t, []byte{0x18, 0x00, 0x72, 0x00, 0x20, 0x01}, values.Bytes())
+
+	// Groups can not be represented as a Value.
+	require.Error(t, valuesPS.Value(1, molecule.Value{WireType: codec.WireStartGroup}))
+}
+
+// Test that ps.RawField writes the key followed by the raw value.
+func TestRawField(t *testing.T) {
+	output := bytes.NewBuffer([]byte{})
+	ps := molecule.NewProtoStream(output)
+
+	require.NoError(t, ps.RawField(fieldInt64, codec.WireVarint, []byte{0x81, 0x00}))
+	require.NoError(t, ps.RawField(fieldString, codec.WireBytes, []byte{0x02, 'h', 'i'}))
+	require.NoError(t, ps.RawField(fieldFixed32, codec.WireFixed32, []byte{0x01, 0x00, 0x00, 0x00}))
+	require.Equal(t, []byte{
+		0x20, 0x81, 0x00,
+		0x72, 0x02, 'h', 'i',
+		0x4d, 0x01, 0x00, 0x00, 0x00,
+	}, output.Bytes())
+
+	var res simple.Simple
+	require.NoError(t, proto.Unmarshal(output.Bytes(), &res))
+	require.Equal(t, int64(1), res.Int64)
+	require.Equal(t, "hi", res.String_)
+	require.Equal(t, uint32(1), res.Fixed32)
+}
@@ -0,0 +1,98 @@
+package moleculetest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUnknownFields(t *testing.T) {
+	m := &simple.Simple{
+		Double:              1.5,
+		Int64:               10,
+		Uint32:              20,
+		String_:             "hello",
+		Bytes:               []byte("world"),
+		RepeatedInt64Packed: []int64{1, 2, 3},
+	}
+	marshaled, err := proto.Marshal(m)
+	require.NoError(t, err)
+
+	var (
+		unknown  molecule.UnknownFields
+		int64Val int64
+		ps       = molecule.NewProtoStream(nil)
+		rewrite  = func(buffer *codec.Buffer, output *bytes.Buffer) {
+			unknown.Reset()
+			err := unknown.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+				if fieldNum != 4 {
+					return false, nil
+				}
+				v, err := value.AsInt64()
+				int64Val = v
+				return true, err
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			ps.Reset(output)
+			if err := ps.Int64(4, int64Val*2); err != nil {
+				panic(err)
+			}
+			if err := unknown.WriteTo(ps); err != nil {
+				panic(err)
+			}
+		}
+	)
+
+	output := bytes.NewBuffer(nil)
+	rewrite(codec.NewBuffer(marshaled), output)
+	require.Equal(t, int64(10), int64Val)
+
+	// Field 4 is the only varint field before the string, so the unhandled fields are
+	// the original message with field 4 cut out.
+	int64Field := []byte{4 << 3, 10}
+	i := bytes.Index(marshaled, int64Field)
+	require.True(t, i >= 0)
+	expectedUnknown := append(append([]byte(nil), marshaled[:i]...), marshaled[i+len(int64Field):]...)
+	require.Equal(t, len(expectedUnknown), unknown.Len())
+	require.Equal(t, append([]byte{4 << 3, 20}, expectedUnknown...), output.Bytes())
+
+	var rewritten simple.Simple
+	require.NoError(t, proto.Unmarshal(output.Bytes(), &rewritten))
+	expected := proto.Clone(m).(*simple.Simple)
+	expected.Int64 = 20
+	require.True(t, proto.Equal(expected, &rewritten))
+
+	// Fields recorded by hand with NextField are written back too.
+	var (
+		buffer = codec.NewBuffer(marshaled)
+		value  molecule.Value
+	)
+	unknown.Reset()
+	for !buffer.EOF() {
+		_, field, err := molecule.NextField(buffer, &value)
+		require.NoError(t, err)
+		unknown.Record(field)
+	}
+	output.Reset()
+	require.NoError(t, unknown.WriteTo(molecule.NewProtoStream(output)))
+	require.Equal(t, marshaled, output.Bytes())
+
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		output.Reset()
+		buffer.Reset(marshaled)
+		rewrite(buffer, output)
+	})
+	require.Equal(t, float64(0), allocs)
+}
@@ -0,0 +1,107 @@
+package moleculetest
+
+import (
+	"testing"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	simple "github.com/richardartoul/molecule/src/proto"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Test that the unsafe conversions alias the underlying bytes.
+func TestUnsafeConversions(t *testing.T) {
+	value := molecule.Value{WireType: codec.WireBytes, Bytes: []byte("hello")}
+
+	str, err := value.AsStringUnsafe()
+	require.NoError(t, err)
+	require.Equal(t, "hello", str)
+
+	b, err := value.AsBytesUnsafe()
+	require.NoError(t, err)
+	require.True(t, &b[0] == &value.Bytes[0])
+
+	value.Bytes[0] = 'j'
+	require.Equal(t, "jello", str)
+
+	value.Bytes = nil
+	str, err = value.AsStringUnsafe()
+	require.NoError(t, err)
+	require.Equal(t, "", str)
+}
+
+func TestPackedRepeatedStringEach(t *testing.T) {
+	var packed []byte
+	for _, s := range []string{"a", "", "bc"} {
+		packed = protowire.AppendString(packed, s)
+	}
+
+	var strs []string
+	err := molecule.PackedRepeatedStringEach(codec.NewBuffer(packed), func(value string) (bool, error) {
+		strs = append(strs, value)
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "", "bc"}, strs)
+
+	// Truncated input should be reported.
+	err = molecule.PackedRepeatedStringEach(codec.NewBuffer(packed[:len(packed)-1]), func(value string) (bool, error) {
+		return true, nil
+	})
+	require.Error(t, err)
+}
+
+// Test that the zero-copy conversions, and iterating over values with them, do not allocate.
+func TestUnsafeConversionsDoNotAllocate(t *testing.T) {
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+
+	marshaled, err := proto.Marshal(&simple.Simple{String_: "hello", Bytes: []byte("world")})
+	require.NoError(t, err)
+	var packed []byte
+	for _, s := range []string{"a", "bc", "def"} {
+		packed = protowire.AppendString(packed, s)
+	}
+
+	var (
+		buffer = codec.NewBuffer(marshaled)
+		n      int
+	)
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer.Reset(marshaled)
+		err := molecule.MessageEach(buffer, func(fieldNum int32, value molecule.Value) (bool, error) {
+			switch fieldNum {
+			case 14:
+				s, err := value.AsStringUnsafe()
+				n += len(s)
+				return true, err
+			case 15:
+				b, err := value.AsBytesUnsafe()
+				n += len(b)
+				return true, err
+			}
+			return true, nil
+		})
+		if err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+
+	allocs = testing.AllocsPerRun(100, func() {
+		buffer.Reset(packed)
+		err := molecule.PackedRepeatedStringEach(buffer, func(value string) (bool, error) {
+			n += len(value)
+			return true, nil
+		})
+		if err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+	require.NotZero(t, n)
+}
@@ -0,0 +1,440 @@
+package moleculetest
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/richardartoul/molecule"
+	"github.com/richardartoul/molecule/src/codec"
+	"github.com/richardartoul/molecule/src/wkt"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// encodeEmbedded calls encode to write an embedded message with field number 1 and returns
+// the encoded message.
+func encodeEmbedded(t *testing.T, encode func(ps *molecule.ProtoStream) error) []byte {
+	output := bytes.NewBuffer(nil)
+	require.NoError(t, encode(molecule.NewProtoStream(output)))
+
+	var embedded []byte
+	err := molecule.MessageEach(codec.NewBuffer(output.Bytes()), func(fieldNum int32, value molecule.Value) (bool, error) {
+		require.Equal(t, int32(1), fieldNum)
+		embedded = value.Bytes
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, embedded)
+	return embedded
+}
+
+func marshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return b
+}
+
+func TestWKTTime(t *testing.T) {
+	for _, ts := range []time.Time{
+		time.Unix(0, 0),
+		time.Unix(1623963202, 123456789),
+		time.Unix(-1623963202, 1),
+		time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	} {
+		decoded, err := wkt.DecodeTimestamp(codec.NewBuffer(marshal(t, timestamppb.New(ts))))
+		require.NoError(t, err)
+		require.True(t, ts.Equal(decoded), "expected %v, got %v", ts, decoded)
+		require.Equal(t, time.UTC, decoded.Location())
+
+		var res timestamppb.Timestamp
+		require.NoError(t, proto.Unmarshal(encodeEmbedded(t, func(ps *molecule.ProtoStream) error {
+			return wkt.EncodeTimestamp(ps, 1, ts)
+		}), &res))
+		require.True(t, ts.Equal(res.AsTime()))
+	}
+
+	for _, d := range []time.Duration{0, time.Nanosecond, -time.Nanosecond, 90 * time.Minute, -1500 * time.Millisecond, math.MaxInt64, math.MinInt64} {
+		decoded, err := wkt.DecodeDuration(codec.NewBuffer(marshal(t, durationpb.New(d))))
+		require.NoError(t, err)
+		require.Equal(t, d, decoded)
+
+		var res durationpb.Duration
+		require.NoError(t, proto.Unmarshal(encodeEmbedded(t, func(ps *molecule.ProtoStream) error {
+			return wkt.EncodeDuration(ps, 1, d)
+		}), &res))
+		require.NoError(t, res.CheckValid())
+		require.Equal(t, d, res.AsDuration())
+	}
+
+	// Out of range values are rejected.
+	_, err := wkt.DecodeTimestamp(codec.NewBuffer(marshal(t, &timestamppb.Timestamp{Nanos: -1})))
+	require.Error(t, err)
+	_, err = wkt.DecodeDuration(codec.NewBuffer(marshal(t, &durationpb.Duration{Seconds: math.MaxInt64})))
+	require.Error(t, err)
+	_, err = wkt.DecodeDuration(codec.NewBuffer(marshal(t, &durationpb.Duration{Seconds: 9223372036, Nanos: 999999999})))
+	require.Error(t, err)
+
+	// The seconds and nanos of a duration must have the same sign.
+	for _, d := range []*durationpb.Duration{{Seconds: 1, Nanos: -1}, {Seconds: -1, Nanos: 1}} {
+		require.Error(t, d.CheckValid())
+		_, err = wkt.DecodeDuration(codec.NewBuffer(marshal(t, d)))
+		require.Error(t, err)
+	}
+}
+
+// Test that fields encoded with the wrong wire type are rejected rather than decoded as
+// garbage.
+func TestWKTWireTypes(t *testing.T) {
+	var (
+		varint  = protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1)
+		fixed32 = protowire.AppendFixed32(protowire.AppendTag(nil, 1, protowire.Fixed32Type), 1)
+		bytes1  = protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), []byte("a"))
+		bytes2  = protowire.AppendBytes(protowire.AppendTag(nil, 2, protowire.BytesType), []byte("a"))
+		varint2 = protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 1)
+	)
+	for _, test := range []struct {
+		name   string
+		input  []byte
+		decode func(buffer *codec.Buffer) error
+	}{
+		{"Timestamp", bytes1, func(b *codec.Buffer) error { _, err := wkt.DecodeTimestamp(b); return err }},
+		{"Duration", bytes2, func(b *codec.Buffer) error { _, err := wkt.DecodeDuration(b); return err }},
+		{"DoubleValue", fixed32, func(b *codec.Buffer) error { _, err := wkt.DecodeDoubleValue(b); return err }},
+		{"FloatValue", varint, func(b *codec.Buffer) error { _, err := wkt.DecodeFloatValue(b); return err }},
+		{"Int64Value", fixed32, func(b *codec.Buffer) error { _, err := wkt.DecodeInt64Value(b); return err }},
+		{"BoolValue", bytes1, func(b *codec.Buffer) error { _, err := wkt.DecodeBoolValue(b); return err }},
+		{"StringValue", varint, func(b *codec.Buffer) error { _, err := wkt.DecodeStringValue(b); return err }},
+		{"BytesValue", fixed32, func(b *codec.Buffer) error { _, err := wkt.DecodeBytesValue(b); return err }},
+		{"AnyTypeURL", varint, func(b *codec.Buffer) error { _, _, err := wkt.DecodeAny(b); return err }},
+		{"AnyValue", varint2, func(b *codec.Buffer) error { _, _, err := wkt.DecodeAny(b); return err }},
+		{"NullValue", bytes1, func(b *codec.Buffer) error { _, err := wkt.DecodeValue(b); return err }},
+		{"NumberValue", varint2, func(b *codec.Buffer) error { _, err := wkt.DecodeValue(b); return err }},
+		{"StringValueKind", protowire.AppendVarint(protowire.AppendTag(nil, 3, protowire.VarintType), 1), func(b *codec.Buffer) error { _, err := wkt.DecodeValue(b); return err }},
+		{"StructFields", varint, func(b *codec.Buffer) error { return wkt.StructEach(b, nil) }},
+		{"ListValues", varint, func(b *codec.Buffer) error { return wkt.ListValueEach(b, nil) }},
+	} {
+		require.Error(t, test.decode(codec.NewBuffer(test.input)), test.name)
+	}
+
+	_, err := wkt.AnyTypeURL(molecule.Value{WireType: codec.WireBytes, Bytes: varint})
+	require.Error(t, err)
+}
+
+func TestWKTWrappers(t *testing.T) {
+	d, err := wkt.DecodeDoubleValue(codec.NewBuffer(marshal(t, wrapperspb.Double(3.14))))
+	require.NoError(t, err)
+	require.Equal(t, 3.14, d)
+	f, err := wkt.DecodeFloatValue(codec.NewBuffer(marshal(t, wrapperspb.Float(3.14))))
+	require.NoError(t, err)
+	require.Equal(t, float32(3.14), f)
+	i64, err := wkt.DecodeInt64Value(codec.NewBuffer(marshal(t, wrapperspb.Int64(-5))))
+	require.NoError(t, err)
+	require.Equal(t, int64(-5), i64)
+	u64, err := wkt.DecodeUInt64Value(codec.NewBuffer(marshal(t, wrapperspb.UInt64(math.MaxUint64))))
+	require.NoError(t, err)
+	require.Equal(t, uint64(math.MaxUint64), u64)
+	i32, err := wkt.DecodeInt32Value(codec.NewBuffer(marshal(t, wrapperspb.Int32(-5))))
+	require.NoError(t, err)
+	require.Equal(t, int32(-5), i32)
+	u32, err := wkt.DecodeUInt32Value(codec.NewBuffer(marshal(t, wrapperspb.UInt32(5))))
+	require.NoError(t, err)
+	require.Equal(t, uint32(5), u32)
+	b, err := wkt.DecodeBoolValue(codec.NewBuffer(marshal(t, wrapperspb.Bool(true))))
+	require.NoError(t, err)
+	require.True(t, b)
+	s, err := wkt.DecodeStringValue(codec.NewBuffer(marshal(t, wrapperspb.String("hello"))))
+	require.NoError(t, err)
+	require.Equal(t, "hello", s)
+	bs, err := wkt.DecodeBytesValue(codec.NewBuffer(marshal(t, wrapperspb.Bytes([]byte("hello")))))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), bs)
+
+	// A wrapper holding the zero value is an empty message.
+	i64, err = wkt.DecodeInt64Value(codec.NewBuffer(marshal(t, wrapperspb.Int64(0))))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), i64)
+
+	encoded := []struct {
+		encode   func(ps *molecule.ProtoStream) error
+		expected proto.Message
+	}{
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeDoubleValue(ps, 1, 3.14) }, wrapperspb.Double(3.14)},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeFloatValue(ps, 1, 3.14) }, wrapperspb.Float(3.14)},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeInt64Value(ps, 1, -5) }, wrapperspb.Int64(-5)},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeUInt64Value(ps, 1, 5) }, wrapperspb.UInt64(5)},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeInt32Value(ps, 1, -5) }, wrapperspb.Int32(-5)},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeUInt32Value(ps, 1, 5) }, wrapperspb.UInt32(5)},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeBoolValue(ps, 1, true) }, wrapperspb.Bool(true)},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeStringValue(ps, 1, "hello") }, wrapperspb.String("hello")},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeBytesValue(ps, 1, []byte("hello")) }, wrapperspb.Bytes([]byte("hello"))},
+		{func(ps *molecule.ProtoStream) error { return wkt.EncodeInt64Value(ps, 1, 0) }, wrapperspb.Int64(0)},
+	}
+	for _, e := range encoded {
+		require.Equal(t, marshal(t, e.expected), encodeEmbedded(t, e.encode))
+	}
+}
+
+func TestWKTAny(t *testing.T) {
+	a, err := anypb.New(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	typeURL, value, err := wkt.DecodeAny(codec.NewBuffer(marshal(t, a)))
+	require.NoError(t, err)
+	require.Equal(t, "type.googleapis.com/google.protobuf.StringValue", typeURL)
+	require.Equal(t, a.Value, value)
+
+	var res anypb.Any
+	require.NoError(t, proto.Unmarshal(encodeEmbedded(t, func(ps *molecule.ProtoStream) error {
+		return wkt.EncodeAny(ps, 1, typeURL, value)
+	}), &res))
+	require.True(t, proto.Equal(a, &res))
+}
+
+// Test that a duplicated type URL is resolved like proto.Unmarshal resolves it, with the
+// last occurrence winning, so that a message is routed and decoded by the same type.
+func TestWKTAnyDuplicateTypeURL(t *testing.T) {
+	var (
+		duration, _ = anypb.New(durationpb.New(90 * time.Second))
+		str, _      = anypb.New(wrapperspb.String("hello"))
+	)
+	encoded := encodeEmbedded(t, func(ps *molecule.ProtoStream) error {
+		return ps.Embedded(1, func(ps *molecule.ProtoStream) error {
+			if err := ps.String(1, str.TypeUrl); err != nil {
+				return err
+			}
+			if err := ps.Bytes(2, duration.Value); err != nil {
+				return err
+			}
+			return ps.String(1, duration.TypeUrl)
+		})
+	})
+	var expected anypb.Any
+	require.NoError(t, proto.Unmarshal(encoded, &expected))
+	require.Equal(t, duration.TypeUrl, expected.TypeUrl)
+
+	typeURL, value, err := wkt.DecodeAny(codec.NewBuffer(encoded))
+	require.NoError(t, err)
+	require.Equal(t, expected.TypeUrl, typeURL)
+	require.Equal(t, expected.Value, value)
+
+	typeURL, err = wkt.AnyTypeURL(molecule.Value{WireType: codec.WireBytes, Bytes: encoded})
+	require.NoError(t, err)
+	require.Equal(t, expected.TypeUrl, typeURL)
+
+	var (
+		registry = wkt.NewAnyRegistry()
+		decoded  time.Duration
+	)
+	registry.Register(duration.TypeUrl, func(buffer *codec.Buffer) error {
+		var err error
+		decoded, err = wkt.DecodeDuration(buffer)
+		return err
+	})
+	ok, err := registry.Dispatch(codec.NewBuffer(encoded))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 90*time.Second, decoded)
+}
+
+func TestWKTAnyRegistry(t *testing.T) {
+	var (
+		duration, _ = anypb.New(durationpb.New(90 * time.Second))
+		str, _      = anypb.New(wrapperspb.String("hello"))
+		output      = bytes.NewBuffer(nil)
+		ps          = molecule.NewProtoStream(output)
+	)
+	require.NoError(t, wkt.EncodeAny(ps, 1, duration.TypeUrl, duration.Value))
+	require.NoError(t, wkt.EncodeAny(ps, 1, str.TypeUrl, str.Value))
+
+	var values []molecule.Value
+	require.NoError(t, molecule.MessageEach(codec.NewBuffer(output.Bytes()), func(fieldNum int32, value molecule.Value) (bool, error) {
+		values = append(values, value)
+		return true, nil
+	}))
+	require.Len(t, values, 2)
+
+	var (
+		registry = wkt.NewAnyRegistry()
+		decoded  time.Duration
+	)
+	registry.Register(duration.TypeUrl, func(buffer *codec.Buffer) error {
+		var err error
+		decoded, err = wkt.DecodeDuration(buffer)
+		return err
+	})
+
+	for i, a := range []*anypb.Any{duration, str} {
+		typeURL, err := wkt.AnyTypeURL(values[i])
+		require.NoError(t, err)
+		require.Equal(t, a.TypeUrl, typeURL)
+	}
+
+	ok, err := registry.DispatchValue(values[0])
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 90*time.Second, decoded)
+
+	ok, err = registry.DispatchValue(values[1])
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	registry.Register(str.TypeUrl, func(buffer *codec.Buffer) error {
+		return errors.New("handler failed")
+	})
+	_, err = registry.DispatchValue(values[1])
+	require.EqualError(t, err, "handler failed")
+
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := wkt.AnyTypeURL(values[0]); err != nil {
+			panic(err)
+		}
+		if _, err := registry.DispatchValue(values[0]); err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+}
+
+func TestWKTStruct(t *testing.T) {
+	s, err := structpb.NewStruct(map[string]interface{}{
+		"null":   nil,
+		"zero":   0,
+		"number": 3.5,
+		"empty":  "",
+		"string": "hello",
+		"false":  false,
+		"struct": map[string]interface{}{"nested": true},
+		"list":   []interface{}{1, "two", []interface{}{}, map[string]interface{}{}},
+		"":       "empty key",
+	})
+	require.NoError(t, err)
+
+	// Decode the struct back into a structpb.Struct with the wkt iterators.
+	var (
+		decodeStruct func(b []byte) *structpb.Struct
+		decodeList   func(b []byte) *structpb.ListValue
+	)
+	toValue := func(v wkt.Value) *structpb.Value {
+		switch v.Kind {
+		case wkt.NullKind:
+			return structpb.NewNullValue()
+		case wkt.NumberKind:
+			return structpb.NewNumberValue(v.Number)
+		case wkt.StringKind:
+			return structpb.NewStringValue(v.String)
+		case wkt.BoolKind:
+			return structpb.NewBoolValue(v.Bool)
+		case wkt.StructKind:
+			return structpb.NewStructValue(decodeStruct(v.Message))
+		case wkt.ListKind:
+			return structpb.NewListValue(decodeList(v.Message))
+		}
+		t.Fatalf("unexpected kind: %d", v.Kind)
+		return nil
+	}
+	decodeStruct = func(b []byte) *structpb.Struct {
+		res := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		require.NoError(t, wkt.StructEach(codec.NewBuffer(b), func(key string, value wkt.Value) (bool, error) {
+			res.Fields[key] = toValue(value)
+			return true, nil
+		}))
+		return res
+	}
+	decodeList = func(b []byte) *structpb.ListValue {
+		res := &structpb.ListValue{}
+		require.NoError(t, wkt.ListValueEach(codec.NewBuffer(b), func(value wkt.Value) (bool, error) {
+			res.Values = append(res.Values, toValue(value))
+			return true, nil
+		}))
+		return res
+	}
+	require.True(t, proto.Equal(s, decodeStruct(marshal(t, s))))
+
+	value, err := wkt.DecodeValue(codec.NewBuffer(marshal(t, structpb.NewStructValue(s))))
+	require.NoError(t, err)
+	require.Equal(t, wkt.StructKind, value.Kind)
+	require.True(t, proto.Equal(s, decodeStruct(value.Message)))
+
+	// Encode the same struct with the wkt encoders.
+	encoded := encodeEmbedded(t, func(ps *molecule.ProtoStream) error {
+		return wkt.EncodeStruct(ps, 1, func(ps *molecule.ProtoStream) error {
+			for _, err := range []error{
+				wkt.EncodeStructField(ps, "null", wkt.Value{Kind: wkt.NullKind}),
+				wkt.EncodeStructField(ps, "zero", wkt.Value{Kind: wkt.NumberKind}),
+				wkt.EncodeStructField(ps, "number", wkt.Value{Kind: wkt.NumberKind, Number: 3.5}),
+				wkt.EncodeStructField(ps, "empty", wkt.Value{Kind: wkt.StringKind}),
+				wkt.EncodeStructField(ps, "string", wkt.Value{Kind: wkt.StringKind, String: "hello"}),
+				wkt.EncodeStructField(ps, "false", wkt.Value{Kind: wkt.BoolKind}),
+				wkt.EncodeStructFieldStruct(ps, "struct", func(ps *molecule.ProtoStream) error {
+					return wkt.EncodeStructField(ps, "nested", wkt.Value{Kind: wkt.BoolKind, Bool: true})
+				}),
+				wkt.EncodeStructFieldList(ps, "list", func(ps *molecule.ProtoStream) error {
+					if err := wkt.EncodeListElement(ps, wkt.Value{Kind: wkt.NumberKind, Number: 1}); err != nil {
+						return err
+					}
+					if err := wkt.EncodeListElement(ps, wkt.Value{Kind: wkt.StringKind, String: "two"}); err != nil {
+						return err
+					}
+					if err := wkt.EncodeListElementList(ps, func(ps *molecule.ProtoStream) error { return nil }); err != nil {
+						return err
+					}
+					return wkt.EncodeListElementStruct(ps, func(ps *molecule.ProtoStream) error { return nil })
+				}),
+				wkt.EncodeStructField(ps, "", wkt.Value{Kind: wkt.StringKind, String: "empty key"}),
+			} {
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	var res structpb.Struct
+	require.NoError(t, proto.Unmarshal(encoded, &res))
+	require.True(t, proto.Equal(s, &res))
+
+	// Values decoded from a message can be encoded again as is.
+	var resValue structpb.Value
+	require.NoError(t, proto.Unmarshal(encodeEmbedded(t, func(ps *molecule.ProtoStream) error {
+		return wkt.EncodeValue(ps, 1, value)
+	}), &resValue))
+	require.True(t, proto.Equal(structpb.NewStructValue(s), &resValue))
+}
+
+func TestWKTDoesNotAllocate(t *testing.T) {
+	var (
+		timestamp = marshal(t, timestamppb.New(time.Unix(1623963202, 123456789)))
+		s, _      = structpb.NewStruct(map[string]interface{}{"a": 1, "b": "two", "c": []interface{}{true}})
+		encoded   = marshal(t, s)
+		buffer    = codec.NewBuffer(nil)
+	)
+	if codec.Debug {
+		t.Skip("debug builds allocate to track unsafe views")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer.Reset(timestamp)
+		if _, err := wkt.DecodeTimestamp(buffer); err != nil {
+			panic(err)
+		}
+		buffer.Reset(encoded)
+		if err := wkt.StructEach(buffer, func(key string, value wkt.Value) (bool, error) {
+			return true, nil
+		}); err != nil {
+			panic(err)
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+}
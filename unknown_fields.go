@@ -0,0 +1,92 @@
+package molecule
+
+import (
+	"fmt"
+
+	"github.com/richardartoul/molecule/src/codec"
+)
+
+// UnknownFields records the fields of a message that a partial decoder did not handle, so
+// that they can be written back verbatim, in their original order, when the message is
+// re-encoded.
+//
+// The recorded fields are unsafe views over the bytes of the message that was decoded, so
+// those bytes must not be modified until the fields have been written.
+//
+// The zero value is ready to use, and an UnknownFields can be reused for many messages by
+// calling Reset.
+type UnknownFields struct {
+	// The fields are the recorded fields.  Adjacent fields are merged into a
+	// single slice, so a run of unhandled fields is written with a single call
+	// to Write.
+	fields [][]byte
+}
+
+// PartialMessageEachFn is a function that will be called for each top-level field in a
+// message passed to UnknownFields.MessageEach. It returns whether it handled the field;
+// fields that it did not handle are recorded.
+type PartialMessageEachFn func(fieldNum int32, value Value) (handled bool, err error)
+
+// MessageEach iterates over each top-level field in the message stored in buffer and calls
+// fn on each one, like the MessageEach function, and records every field that fn does not
+// handle. Unlike the MessageEach function, the whole message is always scanned.
+func (u *UnknownFields) MessageEach(buffer *codec.Buffer, fn PartialMessageEachFn) error {
+	var value Value
+	for !buffer.EOF() {
+		fieldNum, field, err := NextField(buffer, &value)
+		if err != nil {
+			return err
+		}
+		handled, err := fn(fieldNum, value)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			u.Record(field)
+		}
+	}
+	return nil
+}
+
+// Record records field, which must be the encoding of an entire field including its key,
+// as returned by NextField.
+func (u *UnknownFields) Record(field []byte) {
+	if len(field) == 0 {
+		return
+	}
+	if n := len(u.fields); n > 0 {
+		last := u.fields[n-1]
+		if len(last) < cap(last) && &last[:len(last)+1][len(last)] == &field[0] {
+			u.fields[n-1] = last[:len(last)+len(field)]
+			return
+		}
+	}
+	u.fields = append(u.fields, field)
+}
+
+// Len returns the total size of the recorded fields in bytes.
+func (u *UnknownFields) Len() int {
+	var n int
+	for _, field := range u.fields {
+		n += len(field)
+	}
+	return n
+}
+
+// WriteTo writes the recorded fields to ps, in the order in which they were recorded.
+func (u *UnknownFields) WriteTo(ps *ProtoStream) error {
+	for _, field := range u.fields {
+		if _, err := ps.Write(field); err != nil {
+			return fmt.Errorf("WriteTo: %v", err)
+		}
+	}
+	return nil
+}
+
+// Reset forgets the recorded fields, retaining the memory used to track them for reuse.
+func (u *UnknownFields) Reset() {
+	for i := range u.fields {
+		u.fields[i] = nil
+	}
+	u.fields = u.fields[:0]
+}
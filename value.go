@@ -3,7 +3,6 @@ package molecule
 import (
 	"fmt"
 	"math"
-	"reflect"
 	"unsafe"
 
 	"github.com/richardartoul/molecule/src/codec"
@@ -122,7 +121,15 @@ func (v *Value) AsBool() (bool, error) {
 // AsStringUnsafe interprets the value as a string. The returned string is an unsafe view over
 // the underlying bytes. Use AsStringSafe() to obtain a "safe" string that is a copy of the
 // underlying data.
+//
+// In debug builds, AsStringUnsafe returns codec.ErrStaleView if the buffer the value was
+// decoded from has been Reset.
 func (v *Value) AsStringUnsafe() (string, error) {
+	if codec.Debug {
+		if err := codec.CheckView(v.Bytes); err != nil {
+			return "", err
+		}
+	}
 	return unsafeBytesToString(v.Bytes), nil
 }
 
@@ -134,7 +141,15 @@ func (v *Value) AsStringSafe() (string, error) {
 // AsBytesUnsafe interprets the value as a byte slice. The returned []byte is an unsafe view over
 // the underlying bytes. Use AsBytesSafe() to obtain a "safe" [] that is a copy of the
 // underlying data.
+//
+// In debug builds, AsBytesUnsafe returns codec.ErrStaleView if the buffer the value was
+// decoded from has been Reset.
 func (v *Value) AsBytesUnsafe() ([]byte, error) {
+	if codec.Debug {
+		if err := codec.CheckView(v.Bytes); err != nil {
+			return nil, err
+		}
+	}
 	return v.Bytes, nil
 }
 
@@ -144,11 +159,5 @@ func (v *Value) AsBytesSafe() ([]byte, error) {
 }
 
 func unsafeBytesToString(b []byte) string {
-	bh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
-
-	var s string
-	sh := (*reflect.StringHeader)(unsafe.Pointer(&s))
-	sh.Data = bh.Data
-	sh.Len = bh.Len
-	return s
+	return unsafe.String(unsafe.SliceData(b), len(b))
 }